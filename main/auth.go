@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Access scopes an API key can hold
+const (
+	// submit receipts, and void your own
+	ScopeSubmit = "submit"
+	// read your own receipts and images
+	ScopeRead = "read"
+	// everything - every client's receipts, the event stream, merchants and webhooks
+	ScopeAdmin = "admin"
+)
+
+// Struct definitions & constructors
+
+// Struct representing an API key - only the SHA-256 hash of the key is kept
+type APIKey struct {
+	// name of the client the key belongs to - receipts it submits are tagged with it
+	Name    string   `json:"name"`
+	KeyHash string   `json:"keyHash"`
+	Scopes  []string `json:"scopes"`
+}
+
+// Struct representing the authenticated caller of a request - a client with an API key, or an end user with a bearer token
+type Principal struct {
+	ClientID string
+	UserID   string
+	Scopes   []string
+}
+
+// Struct representing who submitted a receipt or image - empty when authentication is off
+type Owner struct {
+	// name of the API key it was submitted with
+	ClientID string `json:"clientId,omitempty"`
+	// end user whose bearer token it was submitted with
+	UserID string `json:"userId,omitempty"`
+}
+
+// Struct representing APIKeys - the keys accepted by the service, by hash
+type APIKeys struct {
+	mu sync.RWMutex
+	// store a map of API keys accessed via the hash of the key
+	KeysMap map[string]APIKey `json:"keys"`
+}
+
+// Constructor for APIKeys
+func NewAPIKeys() *APIKeys {
+	var ks APIKeys
+	ks.KeysMap = make(map[string]APIKey)
+	return &ks
+}
+
+// Internal data
+
+// Global API keys object - authentication is off until a key is added
+var apiKeys = NewAPIKeys() // pointer to APIKeys object
+
+// Header API keys are sent in
+const apiKeyHeader = "X-API-Key"
+
+// Valid scopes, for checking key files
+var scopes = map[string]bool{ScopeSubmit: true, ScopeRead: true, ScopeAdmin: true}
+
+var keyHashRegex = regexp.MustCompile("^[0-9a-f]{64}$")
+
+// context key for the request's Principal
+type principalKey struct{}
+
+// Internal functions - not exported
+
+// Hash an API key as it is stored - hex SHA-256
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Add a key
+func (ks *APIKeys) add(k APIKey) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.KeysMap[k.KeyHash] = k
+}
+
+// Look up the key presented by a caller
+func (ks *APIKeys) lookup(key string) (APIKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	k, present := ks.KeysMap[hashAPIKey(key)]
+	return k, present
+}
+
+// Check if authentication is on - it is once any key is configured
+func (ks *APIKeys) enabled() bool {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return len(ks.KeysMap) > 0
+}
+
+// Load API keys from a JSON file - a list of {name, keyHash, scopes}
+func loadAPIKeys(path string) (*APIKeys, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []APIKey
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	ks := NewAPIKeys()
+	for _, k := range list {
+		if k.Name == "" || !keyHashRegex.MatchString(k.KeyHash) || len(k.Scopes) == 0 {
+			return nil, fmt.Errorf("invalid API key %q - needs a name, a hex SHA-256 keyHash and scopes", k.Name)
+		}
+		for _, scope := range k.Scopes {
+			if !scopes[scope] {
+				return nil, fmt.Errorf("invalid scope %q for API key %q", scope, k.Name)
+			}
+		}
+		ks.add(k)
+	}
+	return ks, nil
+}
+
+// Check if the principal holds a scope - admin holds them all
+func (p *Principal) has(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if a principal holds any of the scopes
+func (p *Principal) hasAny(scopes []string) bool {
+	for _, scope := range scopes {
+		if p.has(scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// Attach the request's principal to a context
+func withPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// The request's principal - nil when authentication is off
+func principalFrom(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// Check the caller holds a scope - always true when authentication is off
+func allowed(ctx context.Context, scope string) bool {
+	p := principalFrom(ctx)
+	return p == nil || p.has(scope)
+}
+
+// The caller making the request, as the owner of what it submits
+func ownerFrom(ctx context.Context) Owner {
+	if p := principalFrom(ctx); p != nil {
+		return Owner{ClientID: p.ClientID, UserID: p.UserID}
+	}
+	return Owner{}
+}
+
+// Check the caller may see a stored receipt or image - its own, or any with the admin scope
+// End users see what was submitted with their token, clients what was submitted with their key
+func canAccess(ctx context.Context, owner Owner) bool {
+	p := principalFrom(ctx)
+	switch {
+	case p == nil || p.has(ScopeAdmin):
+		return true
+	case p.UserID != "":
+		return owner.UserID == p.UserID
+	default:
+		return owner.ClientID == p.ClientID
+	}
+}
+
+// Check if authentication is on - it is once API keys or a JWKS file are configured
+func authEnabled() bool {
+	return apiKeys.enabled() || jwtVerifier != nil
+}
+
+// Authenticate a caller by bearer token, if JWTs are configured and one is sent, or else by API key
+// Returns an error status and description if it may use none of the scopes
+func authenticate(authorization string, key string, scopes ...string) (*Principal, int, string) {
+	if token, ok := bearerToken(authorization); ok && jwtVerifier != nil {
+		userID, err := jwtVerifier.verify(token)
+		if err != nil {
+			return nil, http.StatusUnauthorized, "The bearer token is invalid"
+		}
+		p := &Principal{UserID: userID, Scopes: userScopes}
+		if !p.hasAny(scopes) {
+			return nil, http.StatusForbidden, "Bearer tokens cannot use the " + strings.Join(scopes, " or ") + " scope"
+		}
+		return p, 0, ""
+	}
+	if key == "" && jwtVerifier != nil {
+		return nil, http.StatusUnauthorized, "An API key or bearer token is required"
+	}
+	return authenticateAPIKey(key, scopes)
+}
+
+// Authenticate a caller by API key - an error status and description if it may use none of the scopes
+func authenticateAPIKey(key string, scopes []string) (*Principal, int, string) {
+	if key == "" {
+		return nil, http.StatusUnauthorized, "An API key is required"
+	}
+	k, present := apiKeys.lookup(key)
+	if !present {
+		return nil, http.StatusUnauthorized, "The API key is invalid"
+	}
+	p := &Principal{ClientID: k.Name, Scopes: k.Scopes}
+	if !p.hasAny(scopes) {
+		return nil, http.StatusForbidden, "The API key lacks the " + strings.Join(scopes, " or ") + " scope"
+	}
+	return p, 0, ""
+}
+
+// Middleware requiring an API key or bearer token with one of the scopes, once authentication is on
+// The caller is attached to the request context, for handlers to tag and scope receipts with
+func requireScope(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authEnabled() {
+			c.Next()
+			return
+		}
+		p, status, description := authenticate(c.GetHeader("Authorization"), c.GetHeader(apiKeyHeader), scopes...)
+		if p == nil {
+			if status == http.StatusUnauthorized && jwtVerifier != nil {
+				c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			}
+			// the v2 routes keep their structured errors
+			if strings.HasPrefix(c.FullPath(), "/v2/") {
+				code := ErrCodeUnauthorized
+				if status == http.StatusForbidden {
+					code = ErrCodeForbidden
+				}
+				respondErrorV2(c, status, code, description)
+				c.Abort()
+				return
+			}
+			c.AbortWithStatusJSON(status, gin.H{"description": description})
+			return
+		}
+		c.Request = c.Request.WithContext(withPrincipal(c.Request.Context(), p))
+		c.Next()
+	}
+}
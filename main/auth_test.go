@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/joelyoshiya/Fetch_Rewards_Backend_Coding_Challenge/receiptclient"
+	"github.com/joelyoshiya/Fetch_Rewards_Backend_Coding_Challenge/receiptpb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// turn on authentication for the duration of a test, with keys named after their clients
+// partner-a and partner-b can submit and read, reader can only read, ops is an admin
+func withAPIKeys(t *testing.T) *APIKeys {
+	ks := NewAPIKeys()
+	ks.add(APIKey{Name: "partner-a", KeyHash: hashAPIKey("key-a"), Scopes: []string{ScopeSubmit, ScopeRead}})
+	ks.add(APIKey{Name: "partner-b", KeyHash: hashAPIKey("key-b"), Scopes: []string{ScopeSubmit, ScopeRead}})
+	ks.add(APIKey{Name: "reader", KeyHash: hashAPIKey("key-reader"), Scopes: []string{ScopeRead}})
+	ks.add(APIKey{Name: "ops", KeyHash: hashAPIKey("key-ops"), Scopes: []string{ScopeAdmin}})
+	previous := apiKeys
+	apiKeys = ks
+	t.Cleanup(func() { apiKeys = previous })
+	return ks
+}
+
+// perform a request with an API key against a fresh router
+func doKeyRequest(t *testing.T, method string, path string, body []byte, key string) *httptest.ResponseRecorder {
+	router := setupRouter()
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest(method, path, bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(apiKeyHeader, key)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuth_Required(t *testing.T) {
+	withAPIKeys(t)
+
+	w := doKeyRequest(t, http.MethodPost, "/receipts/process", body_valid_2, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = doKeyRequest(t, http.MethodPost, "/receipts/process", body_valid_2, "wrong")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = doKeyRequest(t, http.MethodPost, "/receipts/process", body_valid_2, "key-reader")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = doKeyRequest(t, http.MethodGet, "/merchants", nil, "key-a")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = doKeyRequest(t, http.MethodGet, "/merchants", nil, "key-ops")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// v2 keeps its structured errors
+	w = doKeyRequest(t, http.MethodPost, "/v2/receipts/process", body_v2_valid_2, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, ErrCodeUnauthorized, decodeErrorV2(t, w.Body.Bytes()).Code)
+
+	// the spec and docs stay public
+	w = doKeyRequest(t, http.MethodGet, "/openapi.yaml", nil, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAuth_Receipts_Scoped_To_Client(t *testing.T) {
+	ks := withAPIKeys(t)
+	withReceipts(t)
+
+	w := doKeyRequest(t, http.MethodPost, "/receipts/process", body_valid_2, "key-a")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var created map[string]string
+	json.Unmarshal(w.Body.Bytes(), &created)
+	id := created["id"]
+	rp, _ := rs.get(id)
+	assert.Equal(t, "partner-a", rp.ClientID)
+
+	// the submitting client and admins can read it - other clients cannot tell it exists
+	assert.Equal(t, http.StatusOK, doKeyRequest(t, http.MethodGet, "/receipts/"+id+"/points", nil, "key-a").Code)
+	assert.Equal(t, http.StatusOK, doKeyRequest(t, http.MethodGet, "/receipts/"+id+"/points", nil, "key-ops").Code)
+	assert.Equal(t, http.StatusNotFound, doKeyRequest(t, http.MethodGet, "/receipts/"+id+"/points", nil, "key-b").Code)
+	assert.Equal(t, http.StatusNotFound, doKeyRequest(t, http.MethodGet, "/v2/receipts/"+id, nil, "key-b").Code)
+	assert.Equal(t, http.StatusNotFound, doKeyRequest(t, http.MethodPost, "/receipts/"+id+"/void", nil, "key-b").Code)
+	assert.Equal(t, http.StatusOK, doKeyRequest(t, http.MethodPost, "/receipts/"+id+"/void", nil, "key-a").Code)
+
+	// GraphQL lists only the caller's receipts
+	query, _ := json.Marshal(map[string]string{"query": "{ receipts { id } }"})
+	w = doKeyRequest(t, http.MethodPost, "/graphql", query, "key-b")
+	assert.JSONEq(t, `{"data": {"receipts": []}}`, w.Body.String())
+	w = doKeyRequest(t, http.MethodPost, "/graphql", query, "key-a")
+	assert.Contains(t, w.Body.String(), id)
+
+	// the mutation needs the submit scope
+	mutation, _ := json.Marshal(map[string]string{"query": `mutation { processReceipt(receipt: {retailer: "Target", purchaseDate: "2022-01-01", purchaseTime: "13:01", items: [{shortDescription: "Pepsi", price: "1.25"}], total: "1.25"}) { id } }`})
+	w = doKeyRequest(t, http.MethodPost, "/graphql", mutation, "key-reader")
+	assert.Contains(t, w.Body.String(), "The API key lacks the submit scope")
+
+	// and a submit-only key reaches it, though not the queries
+	ks.add(APIKey{Name: "submitter", KeyHash: hashAPIKey("key-submit"), Scopes: []string{ScopeSubmit}})
+	w = doKeyRequest(t, http.MethodPost, "/graphql", mutation, "key-submit")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "errors")
+	w = doKeyRequest(t, http.MethodPost, "/graphql", query, "key-submit")
+	assert.Contains(t, w.Body.String(), "The API key lacks the read scope")
+	w = doKeyRequest(t, http.MethodPost, "/graphql", query, "key-ops")
+	assert.Contains(t, w.Body.String(), id)
+}
+
+func TestAuth_Client(t *testing.T) {
+	withAPIKeys(t)
+	withReceipts(t)
+	ctx := context.Background()
+
+	a := newClient(t, setupRouter(), receiptclient.WithAPIKey("key-a"))
+	id, err := a.ProcessReceipt(ctx, clientValue[receiptclient.Receipt](t, body_valid_2))
+	assert.NoError(t, err)
+
+	b := newClient(t, setupRouter(), receiptclient.WithAPIKey("key-b"))
+	_, err = b.GetPoints(ctx, id)
+	assert.ErrorIs(t, err, receiptclient.ErrNotFound)
+	_, err = b.ListMerchants(ctx)
+	assert.ErrorIs(t, err, receiptclient.ErrForbidden)
+	_, err = newClient(t, setupRouter()).GetPoints(ctx, id)
+	assert.ErrorIs(t, err, receiptclient.ErrUnauthorized)
+}
+
+func TestAuth_GRPC(t *testing.T) {
+	withAPIKeys(t)
+	client := newGRPCClient(t)
+	req := &receiptpb.ProcessReceiptRequest{Receipt: proto_valid_2}
+
+	_, err := client.ProcessReceipt(context.Background(), req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.ProcessReceipt(metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "key-reader"), req)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	resp, err := client.ProcessReceipt(metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "key-a"), req)
+	assert.NoError(t, err)
+	_, err = client.GetPoints(metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "key-b"), &receiptpb.GetPointsRequest{Id: resp.Id})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "keys.json")
+	os.WriteFile(path, []byte(`[{"name": "partner-a", "keyHash": "`+hashAPIKey("key-a")+`", "scopes": ["submit", "read"]}]`), 0o600)
+	ks, err := loadAPIKeys(path)
+	assert.NoError(t, err)
+	k, present := ks.lookup("key-a")
+	assert.True(t, present)
+	assert.Equal(t, "partner-a", k.Name)
+
+	// keys are stored hashed, so plain keys are rejected
+	os.WriteFile(path, []byte(`[{"name": "partner-a", "keyHash": "key-a", "scopes": ["submit"]}]`), 0o600)
+	_, err = loadAPIKeys(path)
+	assert.Error(t, err)
+	os.WriteFile(path, []byte(`[{"name": "partner-a", "keyHash": "`+hashAPIKey("key-a")+`", "scopes": ["root"]}]`), 0o600)
+	_, err = loadAPIKeys(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/joelyoshiya/Fetch_Rewards_Backend_Coding_Challenge/receiptclient"
+	"github.com/stretchr/testify/assert"
+)
+
+// start the service in process and return a client for it
+func newClient(t *testing.T, handler http.Handler, opts ...receiptclient.Option) *receiptclient.Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return receiptclient.New(server.URL, append([]receiptclient.Option{receiptclient.WithRetries(3, time.Millisecond)}, opts...)...)
+}
+
+// decode a test body into a client type
+func clientValue[T any](t *testing.T, body []byte) T {
+	var v T
+	if err := json.Unmarshal(body, &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestClient_Receipts(t *testing.T) {
+	withReceipts(t)
+	client := newClient(t, setupRouter())
+	ctx := context.Background()
+
+	id, err := client.ProcessReceipt(ctx, clientValue[receiptclient.Receipt](t, body_valid_2))
+	assert.NoError(t, err)
+	points, err := client.GetPoints(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, &receiptclient.Points{Status: receiptclient.StatusProcessed, Points: body_valid_2_pts}, points)
+
+	assert.NoError(t, client.VoidReceipt(ctx, id))
+	err = client.VoidReceipt(ctx, id)
+	assert.ErrorIs(t, err, receiptclient.ErrConflict)
+	points, _ = client.GetPoints(ctx, id)
+	assert.Equal(t, receiptclient.StatusVoided, points.Status)
+
+	// typed errors
+	_, err = client.ProcessReceipt(ctx, clientValue[receiptclient.Receipt](t, body_bad_negative_total))
+	assert.ErrorIs(t, err, receiptclient.ErrInvalid)
+	var apiErr *receiptclient.Error
+	if assert.ErrorAs(t, err, &apiErr) {
+		assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+		assert.Equal(t, "The receipt is invalid", apiErr.Message)
+	}
+	_, err = client.GetPoints(ctx, "unknown")
+	assert.ErrorIs(t, err, receiptclient.ErrNotFound)
+
+	parsed, err := client.ProcessTextReceipt(ctx, text_valid_2)
+	assert.NoError(t, err)
+	assert.Equal(t, "M&M Corner Market", parsed.Receipt.Retailer)
+	_, present := rs.get(parsed.ID)
+	assert.True(t, present)
+}
+
+func TestClient_Images(t *testing.T) {
+	withReceipts(t)
+	withFakeOCR(t).Register(image_valid_2, text_valid_2)
+	client := newClient(t, setupRouter())
+	ctx := context.Background()
+
+	parsed, err := client.ProcessImageReceipt(ctx, "receipt.png", bytes.NewReader(image_valid_2))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, parsed.ID)
+	image, contentType, err := client.GetImage(ctx, parsed.ImageID)
+	assert.NoError(t, err)
+	assert.Equal(t, image_valid_2, image)
+	assert.Equal(t, "image/png", contentType)
+}
+
+func TestClient_V2(t *testing.T) {
+	withReceipts(t)
+	client := newClient(t, setupRouter())
+	ctx := context.Background()
+
+	created, err := client.ProcessReceiptV2(ctx, clientValue[receiptclient.ReceiptV2](t, body_v2_valid_2))
+	assert.NoError(t, err)
+	assert.Equal(t, receiptclient.StatusProcessed, created.Status)
+	assert.NotEmpty(t, created.Breakdown)
+
+	stored, err := client.GetReceiptV2(ctx, created.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, json.Number("9.00"), stored.Receipt.Total)
+	assert.Equal(t, *created.Points, *stored.Points)
+
+	// the fields at fault come back with the error
+	_, err = client.ProcessReceiptV2(ctx, receiptclient.ReceiptV2{Retailer: "Target", Total: "1.25"})
+	var apiErr *receiptclient.Error
+	if assert.ErrorAs(t, err, &apiErr) {
+		assert.Equal(t, http.StatusUnprocessableEntity, apiErr.StatusCode)
+		assert.Equal(t, ErrCodeInvalidReceipt, apiErr.Code)
+		assert.Equal(t, []receiptclient.ValidationError{{Field: "purchaseDate", Reason: "is required"}}, apiErr.Details)
+	}
+	assert.ErrorIs(t, err, receiptclient.ErrInvalid)
+	_, err = client.GetPointsV2(ctx, "unknown")
+	assert.ErrorIs(t, err, receiptclient.ErrNotFound)
+}
+
+func TestClient_Merchants_And_Webhooks(t *testing.T) {
+	withMerchants(t)
+	withWebhooks(t)
+	client := newClient(t, setupRouter())
+	ctx := context.Background()
+
+	m, err := client.CreateMerchant(ctx, clientValue[receiptclient.Merchant](t, body_merchant_mm))
+	assert.NoError(t, err)
+	_, err = client.CreateMerchant(ctx, *m)
+	assert.ErrorIs(t, err, receiptclient.ErrConflict)
+	m, err = client.AddMerchantAlias(ctx, m.ID, "M & M CORNER MKT")
+	assert.NoError(t, err)
+	assert.Contains(t, m.Aliases, "M & M CORNER MKT")
+	m, err = client.RemoveMerchantAlias(ctx, m.ID, "M & M CORNER MKT")
+	assert.NoError(t, err)
+	assert.NotContains(t, m.Aliases, "M & M CORNER MKT")
+	list, err := client.ListMerchants(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, list, 1)
+
+	subscriber, _ := newSubscriber(t)
+	s, err := client.CreateSubscription(ctx, subscriber.URL, receiptclient.EventReceiptVoided)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, s.Secret)
+	subscriptions, err := client.ListSubscriptions(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, subscriptions, 1) {
+		assert.Empty(t, subscriptions[0].Secret)
+	}
+	deliveries, err := client.ListDeliveries(ctx, s.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, deliveries)
+	assert.NoError(t, client.DeleteSubscription(ctx, s.ID))
+	assert.ErrorIs(t, client.DeleteSubscription(ctx, s.ID), receiptclient.ErrNotFound)
+}
+
+func TestClient_Retries(t *testing.T) {
+	withReceipts(t)
+	id, _ := submitReceipt(context.Background(), receipt_valid_2())
+	router := setupRouter()
+
+	// the first two requests fail, then the service recovers
+	var calls int32
+	flaky := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		router.ServeHTTP(w, r)
+	})
+	client := newClient(t, flaky)
+	points, err := client.GetPoints(context.Background(), id)
+	assert.NoError(t, err)
+	assert.Equal(t, body_valid_2_pts, points.Points)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	// not retried - the receipt may have been processed
+	atomic.StoreInt32(&calls, 0)
+	_, err = client.ProcessReceipt(context.Background(), clientValue[receiptclient.Receipt](t, body_valid_2))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Error(t, err)
+
+	// a full queue is retried after Retry-After, as the receipt was never accepted - unless the context ends first
+	withQueue(t, 1, 0)
+	enqueueReceipt(context.Background(), ReceiptPoints{}, func(ctx context.Context) (Receipt, error) { return receipt_valid_2(), nil })
+	atomic.StoreInt32(&calls, 2)
+	client = newClient(t, flaky, receiptclient.WithRetries(1, time.Millisecond))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = client.ProcessReceiptAsync(ctx, clientValue[receiptclient.Receipt](t, body_valid_2))
+	assert.ErrorIs(t, err, receiptclient.ErrUnavailable)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
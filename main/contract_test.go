@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xeipuuv/gojsonschema"
+	"gopkg.in/yaml.v3"
+)
+
+// a request made against the spec
+type contractCase struct {
+	method string
+	path   string
+	body   []byte
+	// defaults to application/json
+	contentType string
+	// the body is meant to break the request schema, so is not checked against it
+	badRequest bool
+}
+
+// api.yml, decoded
+type contractSpec struct {
+	doc   map[string]interface{}
+	paths map[string]interface{}
+}
+
+// load and decode api.yml
+func loadContractSpec(t *testing.T) contractSpec {
+	data, err := os.ReadFile("../api.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	spec := contractSpec{doc: jsonValue(doc).(map[string]interface{})}
+	spec.paths = spec.doc["paths"].(map[string]interface{})
+	return spec
+}
+
+// find the operation for a request - literal paths win over templated ones, like in the router
+func (spec contractSpec) operation(method string, path string) (string, map[string]interface{}) {
+	path = strings.SplitN(path, "?", 2)[0]
+	templates := make([]string, 0, len(spec.paths))
+	for template := range spec.paths {
+		templates = append(templates, template)
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		return strings.Count(templates[i], "{") < strings.Count(templates[j], "{")
+	})
+	for _, template := range templates {
+		pattern := "^" + regexp.MustCompile(`\\\{\w+\\\}`).ReplaceAllString(regexp.QuoteMeta(template), `[^/]+`) + "$"
+		if !regexp.MustCompile(pattern).MatchString(path) {
+			continue
+		}
+		if op, ok := spec.paths[template].(map[string]interface{})[strings.ToLower(method)]; ok {
+			return strings.ToUpper(method) + " " + template, op.(map[string]interface{})
+		}
+	}
+	return "", nil
+}
+
+// the schema declared for a media type, or nil - media ranges like image/* match too
+func mediaSchema(content interface{}, mediaType string) (map[string]interface{}, bool) {
+	declared := content.(map[string]interface{})
+	for _, key := range []string{mediaType, strings.Split(mediaType, "/")[0] + "/*", "*/*"} {
+		if media, ok := declared[key]; ok {
+			schema, _ := media.(map[string]interface{})["schema"].(map[string]interface{})
+			return schema, true
+		}
+	}
+	return nil, false
+}
+
+// follow a $ref to a shared response
+func (spec contractSpec) resolve(v map[string]interface{}) map[string]interface{} {
+	ref, ok := v["$ref"].(string)
+	if !ok {
+		return v
+	}
+	target := spec.doc
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		target = target[part].(map[string]interface{})
+	}
+	return target
+}
+
+// validate a JSON document against a schema from the spec - $refs resolve against the spec's components
+func (spec contractSpec) validate(schema map[string]interface{}, body []byte) []string {
+	root := map[string]interface{}{"components": spec.doc["components"]}
+	for k, v := range schema {
+		root[k] = v
+	}
+	result, err := gojsonschema.Validate(gojsonschema.NewGoLoader(root), gojsonschema.NewBytesLoader(body))
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var errs []string
+	for _, e := range result.Errors() {
+		errs = append(errs, e.String())
+	}
+	return errs
+}
+
+// check a request and its response against the spec, returning the operation exercised
+func (spec contractSpec) check(t *testing.T, server *httptest.Server, tc contractCase) string {
+	name := tc.method + " " + tc.path
+	key, op := spec.operation(tc.method, tc.path)
+	if op == nil {
+		t.Errorf("%s: not in the spec", name)
+		return ""
+	}
+	if tc.contentType == "" {
+		tc.contentType = "application/json"
+	}
+
+	// the request body matches the declared schema
+	if requestBody, ok := op["requestBody"].(map[string]interface{}); ok && !tc.badRequest {
+		schema, declared := mediaSchema(requestBody["content"], strings.Split(tc.contentType, ";")[0])
+		assert.True(t, declared, "%s: request content type %s not in the spec", name, tc.contentType)
+		if schema != nil && tc.contentType == "application/json" {
+			assert.Empty(t, spec.validate(schema, tc.body), "%s: request", name)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, tc.method, server.URL+tc.path, bytes.NewReader(tc.body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", tc.contentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "text/event-stream" {
+		// the stream never ends - the status and headers are all there is to check
+		cancel()
+	}
+	var body bytes.Buffer
+	body.ReadFrom(resp.Body)
+
+	// the status code is documented
+	responses := op["responses"].(map[string]interface{})
+	response, ok := responses[strconv.Itoa(resp.StatusCode)].(map[string]interface{})
+	if !ok {
+		t.Errorf("%s: status %d not in the spec", name, resp.StatusCode)
+		return key
+	}
+	response = spec.resolve(response)
+
+	// the response body matches the declared schema
+	content, ok := response["content"]
+	if !ok || mediaType == "text/event-stream" {
+		return key
+	}
+	schema, declared := mediaSchema(content, mediaType)
+	if !declared {
+		t.Errorf("%s: %d response content type %s not in the spec", name, resp.StatusCode, mediaType)
+		return key
+	}
+	if schema != nil && mediaType == "application/json" {
+		assert.Empty(t, spec.validate(schema, body.Bytes()), "%s: %d response %s", name, resp.StatusCode, body.String())
+	}
+	return key
+}
+
+// a multipart upload of a receipt image
+func imageUpload(t *testing.T, data []byte) ([]byte, string) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("image", "receipt.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	form.Close()
+	return body.Bytes(), form.FormDataContentType()
+}
+
+// use an empty merchant catalog for the duration of a test
+func withMerchants(t *testing.T) *MerchantCatalog {
+	mc := NewMerchantCatalog()
+	previous := merchants
+	merchants = mc
+	t.Cleanup(func() { merchants = previous })
+	return mc
+}
+
+func TestContract(t *testing.T) {
+	spec := loadContractSpec(t)
+	withReceipts(t)
+	withMerchants(t)
+	withWebhooks(t)
+	withEventStream(t)
+	withLifecycle(t, lifecycleServing)
+	withFakeOCR(t).Register(image_valid_2, text_valid_2)
+	// no workers, so queued receipts stay pending
+	withQueue(t, 2, 0)
+	server := httptest.NewServer(setupRouter())
+	t.Cleanup(server.Close)
+
+	processedID, _ := submitReceipt(context.Background(), receipt_valid_2())
+	voidID, _ := submitReceipt(context.Background(), receipt_valid_2())
+	pendingID, _ := enqueueReceipt(context.Background(), ReceiptPoints{}, func(ctx context.Context) (Receipt, error) { return receipt_valid_2(), nil })
+	imageID := images.add("image/png", image_valid_2, Owner{})
+	subscriber, _ := newSubscriber(t)
+	subscription := subscribe(t, `{"url": "`+subscriber.URL+`"}`)
+	upload, uploadType := imageUpload(t, image_valid_2)
+	graphQL := []byte(`{"query": "{ receipts(first: 5) { id points } }"}`)
+
+	cases := []contractCase{
+		{method: http.MethodPost, path: "/receipts/process", body: body_valid_2},
+		{method: http.MethodPost, path: "/receipts/process", body: body_bad_negative_total, badRequest: true},
+		{method: http.MethodPost, path: "/receipts/process?async=true", body: body_valid_1},
+		{method: http.MethodPost, path: "/receipts/process/text", body: []byte(text_valid_2), contentType: "text/plain"},
+		{method: http.MethodPost, path: "/receipts/process/text", body: []byte("nothing to see"), contentType: "text/plain"},
+		{method: http.MethodPost, path: "/receipts/process/image", body: upload, contentType: uploadType},
+		{method: http.MethodPost, path: "/receipts/process/image", body: body_valid_2, badRequest: true},
+		{method: http.MethodGet, path: "/images/" + imageID},
+		{method: http.MethodGet, path: "/images/unknown"},
+		{method: http.MethodGet, path: "/receipts/events"},
+		{method: http.MethodGet, path: "/receipts/" + processedID + "/points"},
+		{method: http.MethodGet, path: "/receipts/" + pendingID + "/points"},
+		{method: http.MethodGet, path: "/receipts/unknown/points"},
+		{method: http.MethodPost, path: "/receipts/" + voidID + "/void"},
+		{method: http.MethodPost, path: "/receipts/" + pendingID + "/void"},
+		{method: http.MethodPost, path: "/receipts/unknown/void"},
+		{method: http.MethodGet, path: "/receipts/" + voidID + "/points"},
+		{method: http.MethodPost, path: "/v2/receipts/process", body: body_v2_valid_2},
+		{method: http.MethodPost, path: "/v2/receipts/process", body: []byte(`{"retailer": "Target", "total": "1.25"}`), badRequest: true},
+		{method: http.MethodPost, path: "/v2/receipts/process", body: []byte(`{"retailer": `), badRequest: true},
+		{method: http.MethodGet, path: "/v2/receipts/" + processedID},
+		{method: http.MethodGet, path: "/v2/receipts/unknown"},
+		{method: http.MethodGet, path: "/v2/receipts/" + pendingID + "/points"},
+		{method: http.MethodGet, path: "/v2/receipts/unknown/points"},
+		{method: http.MethodPost, path: "/graphql", body: graphQL},
+		{method: http.MethodGet, path: "/graphql?query=" + "%7B%20receipts%20%7B%20id%20%7D%20%7D"},
+		{method: http.MethodGet, path: "/graphql?query=" + "mutation%20%7B%20processReceipt(receipt%3A%20%7B%7D)%20%7B%20id%20%7D%20%7D"},
+		{method: http.MethodPost, path: "/merchants", body: body_merchant_mm},
+		{method: http.MethodPost, path: "/merchants", body: body_merchant_mm},
+		{method: http.MethodGet, path: "/merchants"},
+		{method: http.MethodGet, path: "/merchants/mm-corner-market"},
+		{method: http.MethodGet, path: "/merchants/unknown"},
+		{method: http.MethodPost, path: "/merchants/mm-corner-market/aliases", body: []byte(`{"alias": "M & M CORNER MKT"}`)},
+		{method: http.MethodDelete, path: "/merchants/mm-corner-market/aliases/M%20%26%20M%20CORNER%20MKT"},
+		{method: http.MethodDelete, path: "/merchants/mm-corner-market/aliases/unknown"},
+		{method: http.MethodGet, path: "/webhooks"},
+		{method: http.MethodPost, path: "/webhooks", body: []byte(`{"url": "` + subscriber.URL + `", "events": ["receipt.voided"]}`)},
+		{method: http.MethodPost, path: "/webhooks", body: []byte(`{"url": "ftp://example.com"}`), badRequest: true},
+		{method: http.MethodGet, path: "/webhooks/" + subscription.ID + "/deliveries"},
+		{method: http.MethodGet, path: "/webhooks/unknown/deliveries"},
+		{method: http.MethodGet, path: "/webhooks/dead-letters"},
+		{method: http.MethodDelete, path: "/webhooks/" + subscription.ID},
+		{method: http.MethodDelete, path: "/webhooks/" + subscription.ID},
+		{method: http.MethodGet, path: "/openapi.yaml"},
+		{method: http.MethodGet, path: "/openapi.json"},
+		{method: http.MethodGet, path: "/docs"},
+		{method: http.MethodGet, path: "/docs/swagger-ui.css"},
+		{method: http.MethodGet, path: "/docs/swagger-ui-bundle.js"},
+		{method: http.MethodGet, path: "/docs/index.html"},
+		{method: http.MethodGet, path: "/metrics"},
+		{method: http.MethodGet, path: "/healthz"},
+		{method: http.MethodGet, path: "/readyz"},
+		{method: http.MethodGet, path: "/version"},
+	}
+
+	exercised := make(map[string]bool)
+	for _, tc := range cases {
+		exercised[spec.check(t, server, tc)] = true
+	}
+
+	// every operation in the spec is exercised
+	for template, ops := range spec.paths {
+		for method := range ops.(map[string]interface{}) {
+			key := strings.ToUpper(method) + " " + template
+			assert.True(t, exercised[key], "%s: not exercised", key)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Struct definitions & constructors
+
+// Struct representing how amounts in a currency are validated and scored
+type CurrencyRules struct {
+	// number of digits after the decimal point (ISO 4217 minor unit) - 2 for USD, 0 for JPY, 3 for BHD
+	MinorUnits int `json:"minorUnits"`
+	// a total is "round" if it is a multiple of this many minor units - 100 for USD (one dollar)
+	RoundStep int64 `json:"roundStep"`
+	// a total earns the quarter bonus if it is a multiple of this many minor units - 25 for USD (0.25)
+	QuarterStep int64 `json:"quarterStep"`
+	// value of one unit of the currency in USD - used to normalize price based points
+	RateToUSD float64 `json:"rateToUSD"`
+}
+
+// Default currency used when a receipt does not specify one
+const DefaultCurrency = "USD"
+
+// Internal data
+
+// Currency table used by validateReceipt and processPoints - replaced from the CURRENCY_TABLE file on startup, if set
+// Exchange rates are an offline snapshot, so points stay reproducible for a given receipt
+var currencies = map[string]CurrencyRules{
+	"USD": {MinorUnits: 2, RoundStep: 100, QuarterStep: 25, RateToUSD: 1},
+	"CAD": {MinorUnits: 2, RoundStep: 100, QuarterStep: 25, RateToUSD: 0.73},
+	"AUD": {MinorUnits: 2, RoundStep: 100, QuarterStep: 25, RateToUSD: 0.66},
+	"EUR": {MinorUnits: 2, RoundStep: 100, QuarterStep: 25, RateToUSD: 1.08},
+	"GBP": {MinorUnits: 2, RoundStep: 100, QuarterStep: 25, RateToUSD: 1.26},
+	"CHF": {MinorUnits: 2, RoundStep: 100, QuarterStep: 5, RateToUSD: 1.12},
+	"MXN": {MinorUnits: 2, RoundStep: 100, QuarterStep: 50, RateToUSD: 0.058},
+	"INR": {MinorUnits: 2, RoundStep: 100, QuarterStep: 50, RateToUSD: 0.012},
+	"JPY": {MinorUnits: 0, RoundStep: 100, QuarterStep: 10, RateToUSD: 0.0067},
+	"KRW": {MinorUnits: 0, RoundStep: 1000, QuarterStep: 100, RateToUSD: 0.00075},
+	"BHD": {MinorUnits: 3, RoundStep: 1000, QuarterStep: 250, RateToUSD: 2.65},
+	"KWD": {MinorUnits: 3, RoundStep: 1000, QuarterStep: 250, RateToUSD: 3.25},
+}
+
+// currency codes are three uppercase letters
+var currencyCodeRegex = regexp.MustCompile("^[A-Z]{3}$")
+
+// Internal functions - not exported
+
+// Get the currency of a receipt, falling back to the default currency
+func receiptCurrency(r Receipt) string {
+	if r.Currency == "" {
+		return DefaultCurrency
+	}
+	return r.Currency
+}
+
+// Get the rules for a currency - returns false if the currency is not in the table
+func currencyRules(code string) (CurrencyRules, bool) {
+	rules, present := currencies[code]
+	return rules, present
+}
+
+// Parse a non-negative decimal amount into minor units ("9.05" USD -> 905)
+// Returns an error if the amount is malformed or has more decimal places than the currency allows
+func parseMinorUnits(amount string, rules CurrencyRules) (int64, error) {
+	whole, fraction, hasFraction := strings.Cut(amount, ".")
+	if whole == "" || strings.Trim(whole, "0123456789") != "" || strings.Trim(fraction, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid amount %q", amount)
+	}
+	if hasFraction && (fraction == "" || len(fraction) > rules.MinorUnits) {
+		return 0, fmt.Errorf("amount %q has more than %d decimal places", amount, rules.MinorUnits)
+	}
+	// pad the fraction out to the full number of minor units
+	fraction += strings.Repeat("0", rules.MinorUnits-len(fraction))
+	minor, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", amount)
+	}
+	return minor, nil
+}
+
+// Format an amount in minor units with the currency's number of decimal places - the inverse of parseMinorUnits
+func formatMinorUnits(minor int64, rules CurrencyRules) string {
+	digits := strconv.FormatInt(minor, 10)
+	if rules.MinorUnits == 0 {
+		return digits
+	}
+	// pad so there is at least one whole digit
+	if len(digits) <= rules.MinorUnits {
+		digits = strings.Repeat("0", rules.MinorUnits-len(digits)+1) + digits
+	}
+	return digits[:len(digits)-rules.MinorUnits] + "." + digits[len(digits)-rules.MinorUnits:]
+}
+
+// Check if an amount is a multiple of a step in minor units - a step of 0 disables the check
+func isMultipleOf(minor int64, step int64) bool {
+	return step > 0 && minor%step == 0
+}
+
+// Convert an amount in a currency to USD
+func toUSD(amount float64, rules CurrencyRules) float64 {
+	return amount * rules.RateToUSD
+}
+
+// Check that a currency's rules are usable
+func validCurrencyRules(rules CurrencyRules) bool {
+	return rules.MinorUnits >= 0 && rules.MinorUnits <= 4 && rules.RoundStep >= 0 && rules.QuarterStep >= 0 &&
+		rules.RateToUSD > 0 && !math.IsInf(rules.RateToUSD, 0)
+}
+
+// Load a currency table from a JSON file mapping currency codes to rules
+func loadCurrencyTable(path string) (map[string]CurrencyRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	table := make(map[string]CurrencyRules)
+	if err := json.Unmarshal(data, &table); err != nil {
+		return nil, err
+	}
+	for code, rules := range table {
+		if !currencyCodeRegex.MatchString(code) || !validCurrencyRules(rules) {
+			return nil, fmt.Errorf("invalid rules for currency %q", code)
+		}
+	}
+	if _, present := table[DefaultCurrency]; !present {
+		return nil, fmt.Errorf("currency table must include %s", DefaultCurrency)
+	}
+	return table, nil
+}
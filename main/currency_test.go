@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMinorUnits(t *testing.T) {
+	usd := currencies["USD"]
+	for amount, expected := range map[string]int64{"9.00": 900, "9.05": 905, "9.5": 950, "9": 900, "0.01": 1, "012.30": 1230} {
+		minor, err := parseMinorUnits(amount, usd)
+		assert.NoError(t, err, amount)
+		assert.Equal(t, expected, minor, amount)
+	}
+	for _, amount := range []string{"", "-1.00", "1.001", "1.", ".50", "1e3", "1,00", "+1.00", "NaN"} {
+		_, err := parseMinorUnits(amount, usd)
+		assert.Error(t, err, amount)
+	}
+
+	// zero decimal currencies reject any fraction
+	minor, err := parseMinorUnits("1500", currencies["JPY"])
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1500), minor)
+	_, err = parseMinorUnits("1500.50", currencies["JPY"])
+	assert.Error(t, err)
+
+	// three decimal currencies allow a third place
+	minor, err = parseMinorUnits("1.250", currencies["BHD"])
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1250), minor)
+}
+
+func TestValidateReceipt_Currency(t *testing.T) {
+	r := Receipt{
+		Retailer:     "Lawson",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "10:00",
+		Items:        []Item{{ShortDescription: "Onigiri", Price: "150"}},
+		Total:        "150",
+		Currency:     "JPY",
+	}
+	assert.True(t, validateReceipt(r))
+
+	r.Currency = "XYZ"
+	assert.False(t, validateReceipt(r))
+
+	r.Currency = "USD"
+	r.Total = "1.505"
+	assert.False(t, validateReceipt(r))
+
+	r.Currency = "JPY"
+	r.Total = "150"
+	r.Items[0].Price = "150.5"
+	assert.False(t, validateReceipt(r))
+}
+
+func TestProcessPoints_Currency(t *testing.T) {
+	r := Receipt{
+		Retailer:     "Lawson",
+		PurchaseDate: "2022-03-20",
+		PurchaseTime: "10:00",
+		Items:        []Item{{ShortDescription: "Onigiri Sets", Price: "1200"}},
+		Total:        "1200",
+		Currency:     "JPY",
+	}
+	// 6 retailer + 50 round (multiple of 100 yen) + 25 quarter (multiple of 10 yen)
+	// + ceil(1200 * 0.0067 * 0.2) for the 12 character description
+	assert.Equal(t, 6+50+25+2, processPoints(r))
+
+	r.Total = "1205"
+	assert.Equal(t, 6+2, processPoints(r))
+
+	// the same receipt in dollars scores the item rule on the full price
+	r.Currency = ""
+	r.Total = "12.00"
+	r.Items[0].Price = "12.00"
+	assert.Equal(t, 6+50+25+3, processPoints(r))
+}
+
+func TestLoadCurrencyTable(t *testing.T) {
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "valid.json")
+	assert.NoError(t, os.WriteFile(valid, []byte(`{
+		"USD": {"minorUnits": 2, "roundStep": 100, "quarterStep": 25, "rateToUSD": 1},
+		"SEK": {"minorUnits": 2, "roundStep": 100, "quarterStep": 50, "rateToUSD": 0.095}
+	}`), 0o600))
+	table, err := loadCurrencyTable(valid)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(50), table["SEK"].QuarterStep)
+
+	missingUSD := filepath.Join(dir, "missing-usd.json")
+	assert.NoError(t, os.WriteFile(missingUSD, []byte(`{"SEK": {"minorUnits": 2, "roundStep": 100, "rateToUSD": 0.095}}`), 0o600))
+	_, err = loadCurrencyTable(missingUSD)
+	assert.Error(t, err)
+
+	badRate := filepath.Join(dir, "bad-rate.json")
+	assert.NoError(t, os.WriteFile(badRate, []byte(`{"USD": {"minorUnits": 2, "roundStep": 100, "rateToUSD": 0}}`), 0o600))
+	_, err = loadCurrencyTable(badRate)
+	assert.Error(t, err)
+
+	_, err = loadCurrencyTable(filepath.Join(dir, "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestProcessReceipt_Bad_Currency(t *testing.T) {
+	w := doRequest(t, http.MethodPost, "/receipts/process", []byte(`{
+	"retailer": "Target",
+	"purchaseDate": "2022-01-01",
+	"purchaseTime": "13:01",
+	"items": [{"shortDescription": "Mountain Dew 12PK", "price": "6.49"}],
+	"total": "6.49",
+	"currency": "usd"
+	}`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"The receipt is invalid"`)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Internal data
+
+// quantities are whole numbers or decimals with up to 3 places
+var quantityRegex = regexp.MustCompile(`^\d+(\.\d{1,3})?$`)
+
+// Accepted payment methods
+var paymentMethods = map[string]bool{
+	"cash":      true,
+	"credit":    true,
+	"debit":     true,
+	"giftCard":  true,
+	"mobile":    true,
+	"check":     true,
+	"ebt":       true,
+	"storeCard": true,
+	"other":     true,
+}
+
+// Internal functions - not exported
+
+// Check the optional item fields - quantity, unit price, SKU and UPC
+// Returns why the item is invalid, with the field relative to the item, or nil
+func checkItemDetails(item Item, currency CurrencyRules) *ValidationError {
+	// check quantity is a positive decimal, if given
+	quantity := itemQuantity(item)
+	if item.Quantity != "" && quantity <= 0 {
+		return &ValidationError{Field: "quantity", Reason: "must be a positive number with up to 3 decimal places"}
+	}
+	// check unit price is valid, and that the line price is the quantity times the unit price (to the nearest minor unit)
+	if item.UnitPrice != "" {
+		unitPrice, err := parseMinorUnits(item.UnitPrice, currency)
+		if err != nil {
+			return &ValidationError{Field: "unitPrice", Reason: err.Error()}
+		}
+		price, _ := parseMinorUnits(item.Price, currency)
+		if math.Abs(float64(unitPrice)*quantity-float64(price)) > 1 {
+			return &ValidationError{Field: "price", Reason: "must be the quantity times the unit price"}
+		}
+	}
+	// check SKU is not blank, if given
+	if item.SKU != "" && strings.TrimSpace(item.SKU) == "" {
+		return &ValidationError{Field: "sku", Reason: "may not be blank"}
+	}
+	// check UPC has a valid check digit, if given
+	if item.UPC != "" && !validGTIN(item.UPC) {
+		return &ValidationError{Field: "upc", Reason: "must be 8, 12, 13 or 14 digits with a valid check digit"}
+	}
+	return nil
+}
+
+// Check the optional receipt fields - subtotal, tax, discounts and payment method
+// Returns why the receipt is invalid, or nil
+func checkReceiptDetails(r Receipt, currency CurrencyRules) *ValidationError {
+	// check tax is a valid amount, if given
+	var tax int64
+	if r.Tax != "" {
+		t, err := parseMinorUnits(r.Tax, currency)
+		if err != nil {
+			return &ValidationError{Field: "tax", Reason: err.Error()}
+		}
+		tax = t
+	}
+	// check discounts have a description and a valid amount
+	var discounts int64
+	for i, d := range r.Discounts {
+		amount, err := parseMinorUnits(d.Amount, currency)
+		if err != nil {
+			return &ValidationError{Field: fmt.Sprintf("discounts[%d].amount", i), Reason: err.Error()}
+		}
+		if strings.TrimSpace(d.Description) == "" {
+			return &ValidationError{Field: fmt.Sprintf("discounts[%d].description", i), Reason: "is required"}
+		}
+		discounts += amount
+	}
+	// check the subtotal adds up to the total, if given
+	if r.Subtotal != "" {
+		subtotal, err := parseMinorUnits(r.Subtotal, currency)
+		if err != nil {
+			return &ValidationError{Field: "subtotal", Reason: err.Error()}
+		}
+		total, _ := parseMinorUnits(r.Total, currency)
+		if subtotal+tax-discounts != total {
+			return &ValidationError{Field: "subtotal", Reason: "subtotal + tax - discounts must equal the total"}
+		}
+	}
+	// check payment method is one we know, if given
+	if r.PaymentMethod != "" && !paymentMethods[r.PaymentMethod] {
+		return &ValidationError{Field: "paymentMethod", Reason: "is not a known payment method"}
+	}
+	return nil
+}
+
+// Parse an item quantity - a non-negative decimal with at most 3 decimal places (weighed items)
+func parseQuantity(quantity string) (float64, error) {
+	if !quantityRegex.MatchString(quantity) {
+		return 0, fmt.Errorf("invalid quantity %q", quantity)
+	}
+	return strconv.ParseFloat(quantity, 64)
+}
+
+// Check a GTIN (UPC-E/EAN-8, UPC-A, EAN-13 or GTIN-14) - all digits, with a valid check digit
+// An 8-digit code may be either an EAN-8 or a UPC-E, whose check digit is that of the UPC-A it expands to, so either is accepted
+func validGTIN(code string) bool {
+	if n := len(code); n != 8 && n != 12 && n != 13 && n != 14 {
+		return false
+	}
+	for i := 0; i < len(code); i++ {
+		if code[i] < '0' || code[i] > '9' {
+			return false
+		}
+	}
+	if len(code) == 8 && validCheckDigit(expandUPCE(code)) {
+		return true
+	}
+	return validCheckDigit(code)
+}
+
+// Check the last digit of an all-digit code is its GTIN check digit
+func validCheckDigit(code string) bool {
+	sum := 0
+	for i := 0; i < len(code)-1; i++ {
+		digit := int(code[i] - '0')
+		// digits are weighted 3, 1, 3, ... counting back from the one before the check digit
+		if (len(code)-1-i)%2 == 1 {
+			sum += digit * 3
+		} else {
+			sum += digit
+		}
+	}
+	check := int(code[len(code)-1] - '0')
+	return (10-sum%10)%10 == check
+}
+
+// Expand an 8-digit UPC-E code to the 12-digit UPC-A it stands for, keeping its check digit
+// The last of its six middle digits says where the zeros it suppressed go. Codes outside number systems 0 and 1 are not UPC-E, and are returned as is
+func expandUPCE(code string) string {
+	if code[0] != '0' && code[0] != '1' {
+		return code
+	}
+	system, d, check := code[:1], code[1:7], code[7:]
+	var body string
+	switch d[5] {
+	case '0', '1', '2':
+		body = d[:2] + d[5:] + "0000" + d[2:5]
+	case '3':
+		body = d[:3] + "00000" + d[3:5]
+	case '4':
+		body = d[:4] + "00000" + d[4:5]
+	default:
+		body = d[:5] + "0000" + d[5:]
+	}
+	return system + body + check
+}
+
+// Quantity of an item, defaulting to 1 - 0 if it is not a valid quantity
+// For validation, and for scoring rules that want units rather than lines
+func itemQuantity(item Item) float64 {
+	if item.Quantity == "" {
+		return 1
+	}
+	quantity, _ := parseQuantity(item.Quantity)
+	return quantity
+}
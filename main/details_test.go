@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// receipt using every optional field
+var body_valid_detailed = []byte(`{
+	"retailer": "Target",
+	"purchaseDate": "2022-01-01",
+	"purchaseTime": "13:01",
+	"items": [
+	  {
+		"shortDescription": "Mountain Dew 12PK",
+		"price": "12.98",
+		"quantity": "2",
+		"unitPrice": "6.49",
+		"sku": "MTD-12PK",
+		"upc": "012000161155"
+	  },{
+		"shortDescription": "Bananas",
+		"price": "0.87",
+		"quantity": "1.452",
+		"unitPrice": "0.60"
+	  }
+	],
+	"subtotal": "13.85",
+	"tax": "1.15",
+	"discounts": [{"description": "Circle coupon", "amount": "1.00"}],
+	"total": "14.00",
+	"paymentMethod": "credit"
+  }`)
+
+// valid detailed receipt, to be modified by each test case
+func detailedReceipt() Receipt {
+	return Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items: []Item{
+			{ShortDescription: "Mountain Dew 12PK", Price: "12.98", Quantity: "2", UnitPrice: "6.49", SKU: "MTD-12PK", UPC: "012000161155"},
+		},
+		Subtotal:      "12.98",
+		Tax:           "1.02",
+		Discounts:     []Discount{{Description: "Circle coupon", Amount: "1.00"}},
+		Total:         "13.00",
+		PaymentMethod: "debit",
+	}
+}
+
+func TestValidGTIN(t *testing.T) {
+	for _, code := range []string{"036000291452", "012000161155", "4006381333931", "96385074", "10012345678902"} {
+		assert.True(t, validGTIN(code), code)
+	}
+	for _, code := range []string{"036000291453", "03600029145", "03600029145a", "", "4006381333932"} {
+		assert.False(t, validGTIN(code), code)
+	}
+
+	// UPC-E codes are checked as the UPC-A they expand to - one for each place the zeros can be suppressed
+	for upce, upca := range map[string]string{
+		"04252614": "042100005264",
+		"01234531": "012300000451",
+		"06543240": "065430000020",
+		"01234565": "012345000065",
+	} {
+		assert.Equal(t, upca, expandUPCE(upce))
+		assert.True(t, validGTIN(upca), upca)
+		assert.True(t, validGTIN(upce), upce)
+	}
+	assert.False(t, validGTIN("04252615"))
+	// outside number systems 0 and 1, 8-digit codes can only be EAN-8
+	assert.Equal(t, "96385074", expandUPCE("96385074"))
+	assert.False(t, validGTIN("96385075"))
+}
+
+func TestValidateReceipt_Details(t *testing.T) {
+	assert.True(t, validateReceipt(detailedReceipt()))
+
+	cases := map[string]func(r *Receipt){
+		"subtotal does not add up":     func(r *Receipt) { r.Subtotal = "12.99" },
+		"invalid tax":                  func(r *Receipt) { r.Tax = "1.0.2" },
+		"discount without description": func(r *Receipt) { r.Discounts[0].Description = " " },
+		"negative discount":            func(r *Receipt) { r.Discounts[0].Amount = "-1.00" },
+		"unknown payment method":       func(r *Receipt) { r.PaymentMethod = "barter" },
+		"zero quantity":                func(r *Receipt) { r.Items[0].Quantity = "0" },
+		"quantity too precise":         func(r *Receipt) { r.Items[0].Quantity = "1.2345" },
+		"price is not quantity * unit": func(r *Receipt) { r.Items[0].UnitPrice = "6.00" },
+		"bad upc check digit":          func(r *Receipt) { r.Items[0].UPC = "012000161156" },
+		"blank sku":                    func(r *Receipt) { r.Items[0].SKU = "  " },
+	}
+	for name, modify := range cases {
+		r := detailedReceipt()
+		modify(&r)
+		assert.False(t, validateReceipt(r), name)
+	}
+
+	// tax and discounts are not checked against the total without a subtotal
+	r := detailedReceipt()
+	r.Subtotal = ""
+	assert.True(t, validateReceipt(r))
+}
+
+func TestItemQuantity(t *testing.T) {
+	assert.Equal(t, 1.0, itemQuantity(Item{ShortDescription: "Gatorade", Price: "2.25"}))
+	assert.Equal(t, 1.452, itemQuantity(Item{ShortDescription: "Bananas", Price: "0.87", Quantity: "1.452"}))
+	assert.Equal(t, 0.0, itemQuantity(Item{ShortDescription: "Bananas", Price: "0.87", Quantity: "1.4525"}))
+}
+
+func TestScoringRules_Details(t *testing.T) {
+	// rules can score on the optional fields - e.g. a bonus per unit bought on a store card
+	previous := scoringRules
+	t.Cleanup(func() { scoringRules = previous })
+	base := processPoints(detailedReceipt())
+	scoringRules = append(append([]ScoringRule{}, previous...), ScoringRule{Name: "storeCardUnits", Points: func(r Receipt) int {
+		if r.PaymentMethod != "storeCard" {
+			return 0
+		}
+		units := 0.0
+		for _, item := range r.Items {
+			units += itemQuantity(item)
+		}
+		return int(units)
+	}})
+
+	r := detailedReceipt()
+	assert.Equal(t, base, processPoints(r))
+	r.PaymentMethod = "storeCard"
+	assert.Equal(t, base+2, processPoints(r))
+}
+
+func TestProcessReceipt_Detailed(t *testing.T) {
+	w := doRequest(t, http.MethodPost, "/receipts/process", body_valid_detailed)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"id"`)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// Struct definitions & constructors
+
+// Struct representing a receipt event in the stream, numbered so clients can resume
+type StreamEvent struct {
+	Seq   uint64
+	Event ReceiptEvent
+}
+
+// Struct representing the receipt event stream - recent history plus the connected listeners
+type EventStream struct {
+	mu      sync.Mutex
+	seq     uint64
+	history []StreamEvent
+	// buffered channel per connected client
+	listeners map[chan StreamEvent]bool
+}
+
+// Struct representing the data of a stream.reset event - the client resumed after lastEventId, but the events
+// up to firstEventId were no longer kept (or came from before a restart), so it should resync from the API
+type StreamReset struct {
+	LastEventID  uint64 `json:"lastEventId"`
+	FirstEventID uint64 `json:"firstEventId"`
+}
+
+// Constructor for EventStream
+func NewEventStream() *EventStream {
+	var es EventStream
+	es.listeners = make(map[chan StreamEvent]bool)
+	return &es
+}
+
+// Internal data
+
+// Global event stream
+var receiptEvents = NewEventStream() // pointer to EventStream object
+
+// Most events kept for clients resuming with Last-Event-ID
+const maxEventHistory = 1000
+
+// Events buffered per client - a client that falls further behind is disconnected, and resumes with Last-Event-ID
+const listenerBuffer = 64
+
+// Event sent ahead of the replay to a client that resumed from an event no longer kept
+const EventStreamReset = "stream.reset"
+
+// How often a comment is sent to keep idle connections open
+var heartbeatInterval = 15 * time.Second
+
+// Internal functions - not exported
+
+// Number an event, add it to the history and send it to every listener
+func (es *EventStream) publish(event ReceiptEvent) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.seq++
+	se := StreamEvent{Seq: es.seq, Event: event}
+	es.history = append(es.history, se)
+	if len(es.history) > maxEventHistory {
+		es.history = es.history[len(es.history)-maxEventHistory:]
+	}
+	for ch := range es.listeners {
+		select {
+		case ch <- se:
+		default:
+			// too slow - drop the client rather than block publishing
+			delete(es.listeners, ch)
+			close(ch)
+		}
+	}
+}
+
+// Register a listener - returns the events after lastSeq still in the history (when resuming), and a channel of the events that follow
+// Both are taken under the same lock, so no event is missed or sent twice. When the history no longer reaches back to lastSeq,
+// or lastSeq is ahead of the stream because it was issued before a restart, the whole history is returned along with a reset
+func (es *EventStream) listen(lastSeq uint64, resume bool) ([]StreamEvent, *StreamReset, chan StreamEvent) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	var missed []StreamEvent
+	var reset *StreamReset
+	if resume {
+		first := es.seq + 1
+		if len(es.history) > 0 {
+			first = es.history[0].Seq
+		}
+		if lastSeq > es.seq || lastSeq+1 < first {
+			reset = &StreamReset{LastEventID: lastSeq, FirstEventID: first}
+			lastSeq = 0
+		}
+		for _, se := range es.history {
+			if se.Seq > lastSeq {
+				missed = append(missed, se)
+			}
+		}
+	}
+	ch := make(chan StreamEvent, listenerBuffer)
+	es.listeners[ch] = true
+	return missed, reset, ch
+}
+
+// Remove a listener
+func (es *EventStream) unlisten(ch chan StreamEvent) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if es.listeners[ch] {
+		delete(es.listeners, ch)
+		close(ch)
+	}
+}
+
+// Write an event in SSE format
+func writeStreamEvent(w io.Writer, se StreamEvent) error {
+	return sse.Encode(w, sse.Event{Id: strconv.FormatUint(se.Seq, 10), Event: se.Event.Type, Data: se.Event})
+}
+
+// Write a reset in SSE format - its id is that of the event before the first one replayed, so resuming from it replays the rest
+func writeStreamReset(w io.Writer, reset StreamReset) error {
+	return sse.Encode(w, sse.Event{Id: strconv.FormatUint(reset.FirstEventID-1, 10), Event: EventStreamReset, Data: reset})
+}
+
+// Internal Route Functions
+
+// Path: /receipts/events
+// Method: GET
+// Response: text/event-stream of receipt events - receipt.scored, receipt.rejected and receipt.voided.
+// Description: Streams an event each time a receipt is scored, rejected or voided. Each event's id is a sequence number;
+// reconnect with a Last-Event-ID header (or ?lastEventId=) to receive the events missed in between. If some of them are
+// no longer kept, a stream.reset event is sent first, so the client knows to resync.
+func streamReceiptEvents(c *gin.Context) {
+	lastID := c.GetHeader("Last-Event-ID")
+	if lastID == "" {
+		lastID = c.Query("lastEventId")
+	}
+	// without a valid ID, only new events are streamed
+	lastSeq, err := strconv.ParseUint(lastID, 10, 64)
+	missed, reset, ch := receiptEvents.listen(lastSeq, err == nil)
+	defer receiptEvents.unlisten(ch)
+
+	c.Header("Content-Type", sse.ContentType)
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+	if reset != nil {
+		writeStreamReset(c.Writer, *reset)
+	}
+	for _, se := range missed {
+		writeStreamEvent(c.Writer, se)
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case se, open := <-ch:
+			if !open {
+				return false
+			}
+			return writeStreamEvent(w, se) == nil
+		case <-heartbeat.C:
+			_, err := io.WriteString(w, ": heartbeat\n\n")
+			return err == nil
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// an event read back from the stream
+type streamedEvent struct {
+	id    string
+	event string
+	data  ReceiptEvent
+	raw   string
+}
+
+// use a fresh event stream for the duration of a test
+func withEventStream(t *testing.T) *EventStream {
+	es := NewEventStream()
+	previous := receiptEvents
+	receiptEvents = es
+	t.Cleanup(func() { receiptEvents = previous })
+	return es
+}
+
+// open the event stream, resuming after lastEventID if given
+func openStream(t *testing.T, server *httptest.Server, lastEventID string) *bufio.Reader {
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/receipts/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	return bufio.NewReader(resp.Body)
+}
+
+// read the next event from the stream, skipping heartbeats
+func readEvent(t *testing.T, r *bufio.Reader) streamedEvent {
+	var se streamedEvent
+	done := make(chan error, 1)
+	go func() {
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				done <- err
+				return
+			}
+			line = strings.TrimRight(line, "\n")
+			switch {
+			case strings.HasPrefix(line, "id:"):
+				se.id = strings.TrimPrefix(line, "id:")
+			case strings.HasPrefix(line, "event:"):
+				se.event = strings.TrimPrefix(line, "event:")
+			case strings.HasPrefix(line, "data:"):
+				se.raw = strings.TrimPrefix(line, "data:")
+				done <- json.Unmarshal([]byte(se.raw), &se.data)
+				return
+			}
+		}
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event received")
+	}
+	return se
+}
+
+// submit a receipt through the router and return its ID
+func submitForStream(t *testing.T, body []byte) string {
+	w := doRequest(t, http.MethodPost, "/receipts/process", body)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp map[string]string
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return resp["id"]
+}
+
+func TestStreamReceiptEvents(t *testing.T) {
+	withEventStream(t)
+	server := httptest.NewServer(setupRouter())
+	t.Cleanup(server.Close)
+
+	// only receipts stored after connecting are streamed
+	submitForStream(t, body_valid_1)
+	stream := openStream(t, server, "")
+	id := submitForStream(t, body_valid_2)
+
+	se := readEvent(t, stream)
+	assert.Equal(t, "2", se.id)
+	assert.Equal(t, EventReceiptScored, se.event)
+	assert.Equal(t, id, se.data.Data.ReceiptID)
+	assert.Equal(t, body_valid_2_pts, se.data.Data.Points)
+
+	// voids are streamed too
+	doRequest(t, http.MethodPost, "/receipts/"+id+"/void", nil)
+	se = readEvent(t, stream)
+	assert.Equal(t, "3", se.id)
+	assert.Equal(t, EventReceiptVoided, se.event)
+}
+
+func TestStreamReceiptEvents_Resume(t *testing.T) {
+	withEventStream(t)
+	server := httptest.NewServer(setupRouter())
+	t.Cleanup(server.Close)
+
+	first := submitForStream(t, body_valid_1)
+	second := submitForStream(t, body_valid_2)
+
+	// resuming after the first event replays the second, then continues live
+	stream := openStream(t, server, "1")
+	se := readEvent(t, stream)
+	assert.Equal(t, "2", se.id)
+	assert.Equal(t, second, se.data.Data.ReceiptID)
+
+	third := submitForStream(t, body_valid_1)
+	se = readEvent(t, stream)
+	assert.Equal(t, "3", se.id)
+	assert.Equal(t, third, se.data.Data.ReceiptID)
+
+	// resuming from the start replays everything kept
+	stream = openStream(t, server, "0")
+	assert.Equal(t, first, readEvent(t, stream).data.Data.ReceiptID)
+}
+
+func TestStreamReceiptEvents_Gap(t *testing.T) {
+	es := withEventStream(t)
+	server := httptest.NewServer(setupRouter())
+	t.Cleanup(server.Close)
+	for i := 0; i < maxEventHistory+2; i++ {
+		es.publish(ReceiptEvent{ID: strconv.Itoa(i + 1), Type: EventReceiptScored})
+	}
+
+	// events 1 and 2 are no longer kept, so resuming after the first is told about the gap before the replay
+	stream := openStream(t, server, "1")
+	se := readEvent(t, stream)
+	assert.Equal(t, "2", se.id)
+	assert.Equal(t, EventStreamReset, se.event)
+	assert.JSONEq(t, `{"lastEventId": 1, "firstEventId": 3}`, se.raw)
+	se = readEvent(t, stream)
+	assert.Equal(t, "3", se.id)
+	assert.Equal(t, "3", se.data.ID)
+
+	// an ID from before a restart is ahead of the stream, so everything kept is replayed after a reset
+	stream = openStream(t, server, "5000")
+	se = readEvent(t, stream)
+	assert.Equal(t, EventStreamReset, se.event)
+	assert.JSONEq(t, `{"lastEventId": 5000, "firstEventId": 3}`, se.raw)
+	assert.Equal(t, "3", readEvent(t, stream).id)
+
+	// an ID still in the history is resumed without one
+	stream = openStream(t, server, "2")
+	se = readEvent(t, stream)
+	assert.Equal(t, "3", se.id)
+	assert.Equal(t, EventReceiptScored, se.event)
+}
+
+func TestEventStream_Slow_Listener(t *testing.T) {
+	es := NewEventStream()
+	_, _, ch := es.listen(0, false)
+
+	// a listener that never reads is dropped once its buffer is full
+	for i := 0; i <= listenerBuffer; i++ {
+		es.publish(ReceiptEvent{Type: EventReceiptScored})
+	}
+	count := 0
+	for range ch {
+		count++
+	}
+	assert.Equal(t, listenerBuffer, count)
+	assert.Empty(t, es.listeners)
+
+	// unlistening a dropped listener is safe
+	es.unlisten(ch)
+}
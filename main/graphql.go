@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+// Struct definitions & constructors
+
+// Struct representing a stored receipt as resolved by the GraphQL schema
+type receiptNode struct {
+	ID string
+	ReceiptPoints
+}
+
+// Struct representing a GraphQL request
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Internal data
+
+// Most receipts returned by a single receipts query
+const maxReceiptsPage = 100
+
+// GraphQL types - mirror the JSON schemas in api.yml
+var itemType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Item",
+	Fields: graphql.Fields{
+		"shortDescription": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"price":            &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"quantity":         &graphql.Field{Type: graphql.String},
+		"unitPrice":        &graphql.Field{Type: graphql.String},
+		"sku":              &graphql.Field{Type: graphql.String},
+		"upc":              &graphql.Field{Type: graphql.String},
+	},
+})
+
+var discountType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Discount",
+	Fields: graphql.Fields{
+		"description": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"amount":      &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+	},
+})
+
+var rulePointsType = graphql.NewObject(graphql.ObjectConfig{
+	Name:        "RulePoints",
+	Description: "The points awarded by a single scoring rule",
+	Fields: graphql.Fields{
+		"rule":   &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"points": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+	},
+})
+
+var receiptType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Receipt",
+	Fields: graphql.Fields{
+		"id":     nodeField(graphql.NewNonNull(graphql.ID), func(n receiptNode) interface{} { return n.ID }),
+		"status": nodeField(graphql.NewNonNull(graphql.String), func(n receiptNode) interface{} { return n.Status }),
+		// only set once the receipt is processed
+		"points": nodeField(graphql.Int, func(n receiptNode) interface{} {
+			if n.Status != StatusProcessed {
+				return nil
+			}
+			return n.Points
+		}),
+		"breakdown": nodeField(graphql.NewList(graphql.NewNonNull(rulePointsType)), func(n receiptNode) interface{} { return n.Breakdown }),
+		"purchasedAt": nodeField(graphql.DateTime, func(n receiptNode) interface{} {
+			if n.PurchasedAt.IsZero() {
+				return nil
+			}
+			return n.PurchasedAt
+		}),
+		"imageId":       nodeField(graphql.ID, func(n receiptNode) interface{} { return optional(n.ImageID) }),
+		"merchantId":    nodeField(graphql.ID, func(n receiptNode) interface{} { return optional(n.Receipt.MerchantID) }),
+		"userId":        nodeField(graphql.ID, func(n receiptNode) interface{} { return optional(n.Owner.UserID) }),
+		"clientId":      nodeField(graphql.ID, func(n receiptNode) interface{} { return optional(n.Owner.ClientID) }),
+		"retailer":      nodeField(graphql.String, func(n receiptNode) interface{} { return n.Receipt.Retailer }),
+		"purchaseDate":  nodeField(graphql.String, func(n receiptNode) interface{} { return n.Receipt.PurchaseDate }),
+		"purchaseTime":  nodeField(graphql.String, func(n receiptNode) interface{} { return n.Receipt.PurchaseTime }),
+		"total":         nodeField(graphql.String, func(n receiptNode) interface{} { return n.Receipt.Total }),
+		"timeZone":      nodeField(graphql.String, func(n receiptNode) interface{} { return optional(n.Receipt.TimeZone) }),
+		"currency":      nodeField(graphql.String, func(n receiptNode) interface{} { return receiptCurrency(n.Receipt) }),
+		"subtotal":      nodeField(graphql.String, func(n receiptNode) interface{} { return optional(n.Receipt.Subtotal) }),
+		"tax":           nodeField(graphql.String, func(n receiptNode) interface{} { return optional(n.Receipt.Tax) }),
+		"paymentMethod": nodeField(graphql.String, func(n receiptNode) interface{} { return optional(n.Receipt.PaymentMethod) }),
+		"items":         nodeField(graphql.NewList(graphql.NewNonNull(itemType)), func(n receiptNode) interface{} { return n.Receipt.Items }),
+		"discounts":     nodeField(graphql.NewList(graphql.NewNonNull(discountType)), func(n receiptNode) interface{} { return n.Receipt.Discounts }),
+	},
+})
+
+var userBalanceType = graphql.NewObject(graphql.ObjectConfig{
+	Name:        "UserBalance",
+	Description: "The points an end user has earned",
+	Fields: graphql.Fields{
+		"userId":   &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+		"points":   &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"receipts": &graphql.Field{Type: graphql.NewNonNull(graphql.Int), Description: "Processed receipts counted towards the points"},
+	},
+})
+
+// GraphQL input types - the same fields as a receipt posted to /receipts/process
+var itemInputType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "ItemInput",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"shortDescription": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		"price":            &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		"quantity":         &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"unitPrice":        &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"sku":              &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"upc":              &graphql.InputObjectFieldConfig{Type: graphql.String},
+	},
+})
+
+var discountInputType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "DiscountInput",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"description": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		"amount":      &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+	},
+})
+
+var receiptInputType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "ReceiptInput",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"retailer":      &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		"purchaseDate":  &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		"purchaseTime":  &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		"items":         &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(itemInputType)))},
+		"total":         &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		"timeZone":      &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"currency":      &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"subtotal":      &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"tax":           &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"discounts":     &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.NewNonNull(discountInputType))},
+		"paymentMethod": &graphql.InputObjectFieldConfig{Type: graphql.String},
+	},
+})
+
+var processReceiptPayloadType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "ProcessReceiptPayload",
+	Fields: graphql.Fields{
+		"id":      &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+		"status":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"receipt": &graphql.Field{Type: graphql.NewNonNull(receiptType)},
+	},
+})
+
+// GraphQL schema served at /graphql
+var graphQLSchema = newGraphQLSchema()
+
+// Internal functions - not exported
+
+// Build the GraphQL schema - panics if the schema is invalid, since that is a programming error
+func newGraphQLSchema() graphql.Schema {
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"receipt": &graphql.Field{
+				Type:        receiptType,
+				Description: "The receipt with the given ID, or null",
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: resolveReceipt,
+			},
+			"receipts": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(receiptType))),
+				Description: "Receipts matching every given filter, most recent purchase first",
+				Args: graphql.FieldConfigArgument{
+					"status":          &graphql.ArgumentConfig{Type: graphql.String},
+					"merchantId":      &graphql.ArgumentConfig{Type: graphql.ID},
+					"minPoints":       &graphql.ArgumentConfig{Type: graphql.Int},
+					"purchasedAfter":  &graphql.ArgumentConfig{Type: graphql.DateTime},
+					"purchasedBefore": &graphql.ArgumentConfig{Type: graphql.DateTime},
+					"first":           &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 20},
+					"offset":          &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+				},
+				Resolve: resolveReceipts,
+			},
+			"balance": &graphql.Field{
+				Type:        graphql.NewNonNull(userBalanceType),
+				Description: "An end user's points over their processed receipts - the caller's own if userId is left out",
+				Args: graphql.FieldConfigArgument{
+					"userId": &graphql.ArgumentConfig{Type: graphql.ID},
+				},
+				Resolve: resolveBalance,
+			},
+		},
+	})
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"processReceipt": &graphql.Field{
+				Type:        graphql.NewNonNull(processReceiptPayloadType),
+				Description: "Validates, scores and stores a receipt - the same as POST /receipts/process",
+				Args: graphql.FieldConfigArgument{
+					"receipt": &graphql.ArgumentConfig{Type: graphql.NewNonNull(receiptInputType)},
+					"async":   &graphql.ArgumentConfig{Type: graphql.Boolean, DefaultValue: false},
+				},
+				Resolve: resolveProcessReceipt,
+			},
+		},
+	})
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+// Field resolved from a receipt node
+func nodeField(t graphql.Output, get func(n receiptNode) interface{}) *graphql.Field {
+	return &graphql.Field{Type: t, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+		return get(p.Source.(receiptNode)), nil
+	}}
+}
+
+// Null for empty optional strings
+func optional(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// Resolve receipt(id)
+func resolveReceipt(p graphql.ResolveParams) (interface{}, error) {
+	if !allowed(p.Context, ScopeRead) {
+		return nil, errors.New("The API key lacks the read scope")
+	}
+	id, _ := p.Args["id"].(string)
+	rp, present := rs.get(id)
+	if !present || !canAccess(p.Context, rp.Owner) {
+		return nil, nil
+	}
+	return receiptNode{ID: id, ReceiptPoints: rp}, nil
+}
+
+// Resolve receipts(...) - filter, sort and page a snapshot of the store
+func resolveReceipts(p graphql.ResolveParams) (interface{}, error) {
+	if !allowed(p.Context, ScopeRead) {
+		return nil, errors.New("The API key lacks the read scope")
+	}
+	first, _ := p.Args["first"].(int)
+	offset, _ := p.Args["offset"].(int)
+	if first < 0 || first > maxReceiptsPage || offset < 0 {
+		return nil, errors.New("first must be between 0 and 100, and offset may not be negative")
+	}
+	status, _ := p.Args["status"].(string)
+	merchantID, _ := p.Args["merchantId"].(string)
+	minPoints, hasMinPoints := p.Args["minPoints"].(int)
+	after, hasAfter := p.Args["purchasedAfter"].(time.Time)
+	before, hasBefore := p.Args["purchasedBefore"].(time.Time)
+
+	nodes := []receiptNode{}
+	for id, rp := range rs.snapshot() {
+		switch {
+		case !canAccess(p.Context, rp.Owner):
+		case status != "" && rp.Status != status:
+		case merchantID != "" && rp.Receipt.MerchantID != merchantID:
+		case hasMinPoints && (rp.Status != StatusProcessed || rp.Points < minPoints):
+		case hasAfter && !rp.PurchasedAt.After(after):
+		case hasBefore && !rp.PurchasedAt.Before(before):
+		default:
+			nodes = append(nodes, receiptNode{ID: id, ReceiptPoints: rp})
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if !nodes[i].PurchasedAt.Equal(nodes[j].PurchasedAt) {
+			return nodes[i].PurchasedAt.After(nodes[j].PurchasedAt)
+		}
+		return nodes[i].ID < nodes[j].ID
+	})
+
+	if offset > len(nodes) {
+		offset = len(nodes)
+	}
+	nodes = nodes[offset:]
+	if len(nodes) > first {
+		nodes = nodes[:first]
+	}
+	return nodes, nil
+}
+
+// Resolve balance(userId) - sums the points of the user's processed receipts the caller can see
+// Voided and rejected receipts, and those still pending, earn nothing
+func resolveBalance(p graphql.ResolveParams) (interface{}, error) {
+	if !allowed(p.Context, ScopeRead) {
+		return nil, errors.New("The API key lacks the read scope")
+	}
+	userID, _ := p.Args["userId"].(string)
+	if userID == "" {
+		userID = ownerFrom(p.Context).UserID
+	}
+	if userID == "" {
+		return nil, errors.New("userId is required unless calling with a user's bearer token")
+	}
+	points, receipts := 0, 0
+	for _, rp := range rs.snapshot() {
+		if rp.Owner.UserID == userID && rp.Status == StatusProcessed && canAccess(p.Context, rp.Owner) {
+			points += rp.Points
+			receipts++
+		}
+	}
+	return map[string]interface{}{"userId": userID, "points": points, "receipts": receipts}, nil
+}
+
+// Resolve the processReceipt mutation - the same pipeline as POST /receipts/process
+func resolveProcessReceipt(p graphql.ResolveParams) (interface{}, error) {
+	if !allowed(p.Context, ScopeSubmit) {
+		return nil, errors.New("The API key lacks the submit scope")
+	}
+	// the route accepts unsigned queries, so clients with a signing secret are held to it here
+	if description := unsignedSubmission(p.Context); description != "" {
+		return nil, errors.New(description)
+	}
+	// input fields are named like the JSON receipt, so decode them the same way
+	var r Receipt
+	encoded, _ := json.Marshal(p.Args["receipt"])
+	if err := json.Unmarshal(encoded, &r); err != nil {
+		return nil, errors.New("The receipt is invalid")
+	}
+
+	// asynchronous mode - queue the receipt and return its ID straight away
+	if async, _ := p.Args["async"].(bool); async || asyncByDefault {
+		id, queued := enqueueReceipt(p.Context, ReceiptPoints{Receipt: r}, func(ctx context.Context) (Receipt, error) { return r, nil })
+		if !queued {
+			return nil, errors.New("The processing queue is full")
+		}
+		rp, _ := rs.get(id)
+		return map[string]interface{}{"id": id, "status": StatusPending, "receipt": receiptNode{ID: id, ReceiptPoints: rp}}, nil
+	}
+
+	id, err := submitReceipt(p.Context, r)
+	if err != nil {
+		return nil, errors.New("The receipt is invalid")
+	}
+	rp, _ := rs.get(id)
+	return map[string]interface{}{"id": id, "status": rp.Status, "receipt": receiptNode{ID: id, ReceiptPoints: rp}}, nil
+}
+
+// Check if a request runs a mutation - the named operation, or the only one
+// Unparsable queries are left for graphql.Do to report
+func isMutation(req graphQLRequest) bool {
+	doc, err := parser.Parse(parser.ParseParams{Source: req.Query})
+	if err != nil {
+		return false
+	}
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if req.OperationName == "" || (op.Name != nil && op.Name.Value == req.OperationName) {
+			if op.Operation == ast.OperationTypeMutation {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Internal Route Functions
+
+// Path: /graphql
+// Method: GET, POST
+// Payload: JSON with a query, and optionally variables and an operationName - or the same as query parameters on GET
+// Response: A GraphQL result - data, and errors if any.
+// Description: GraphQL queries over the receipt store, and a processReceipt mutation. See the README for the schema.
+func serveGraphQL(c *gin.Context) {
+	var req graphQLRequest
+	if c.Request.Method == http.MethodGet {
+		req.Query = c.Query("query")
+		req.OperationName = c.Query("operationName")
+		if variables := c.Query("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"description": "The variables are invalid"})
+				return
+			}
+		}
+	} else if err := bindJSON(c, &req); err != nil {
+		if errors.Is(err, errBodyTooLarge) {
+			tooLarge(c)
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"description": "The GraphQL request is invalid"})
+		return
+	}
+	if req.Query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"description": "The query is missing"})
+		return
+	}
+	// mutations change the store, so only accept them over POST
+	if c.Request.Method == http.MethodGet && isMutation(req) {
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"description": "Mutations must be sent with POST"})
+		return
+	}
+
+	result := graphql.Do(graphql.Params{
+		Schema:         graphQLSchema,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        c.Request.Context(),
+	})
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// a GraphQL response, with data decoded into T
+type graphQLResponse[T any] struct {
+	Data   T `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// post a GraphQL request and decode the response
+func postGraphQL[T any](t *testing.T, query string, variables map[string]interface{}) graphQLResponse[T] {
+	body, _ := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	w := doRequest(t, http.MethodPost, "/graphql", body)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp graphQLResponse[T]
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// use an empty receipt store for the duration of a test
+func withReceipts(t *testing.T) *Receipts {
+	store := NewReceipts()
+	previous := rs
+	rs = store
+	t.Cleanup(func() { rs = previous })
+	return store
+}
+
+// body_valid_2 as a Receipt
+func receipt_valid_2() Receipt {
+	var r Receipt
+	json.Unmarshal(body_valid_2, &r)
+	return r
+}
+
+type graphQLReceipt struct {
+	ID         string
+	Status     string
+	Points     *int
+	Retailer   string
+	MerchantID *string
+	Breakdown  []RulePoints
+	Items      []Item
+}
+
+func TestGraphQL_Receipt(t *testing.T) {
+	withReceipts(t)
+	id, err := submitReceipt(context.Background(), receipt_valid_2())
+	assert.NoError(t, err)
+
+	resp := postGraphQL[struct{ Receipt *graphQLReceipt }](t, `query ($id: ID!) {
+		receipt(id: $id) { id status points retailer breakdown { rule points } items { shortDescription price } }
+	}`, map[string]interface{}{"id": id})
+	assert.Empty(t, resp.Errors)
+	if assert.NotNil(t, resp.Data.Receipt) {
+		r := resp.Data.Receipt
+		assert.Equal(t, id, r.ID)
+		assert.Equal(t, StatusProcessed, r.Status)
+		assert.Equal(t, body_valid_2_pts, *r.Points)
+		assert.Len(t, r.Items, 4)
+
+		// the breakdown adds up to the points
+		sum := 0
+		for _, rp := range r.Breakdown {
+			sum += rp.Points
+		}
+		assert.Equal(t, body_valid_2_pts, sum)
+		assert.Equal(t, RulePoints{Rule: "retailerName", Points: 14}, r.Breakdown[0])
+	}
+
+	// unknown receipts resolve to null
+	resp = postGraphQL[struct{ Receipt *graphQLReceipt }](t, `{ receipt(id: "unknown") { id } }`, nil)
+	assert.Empty(t, resp.Errors)
+	assert.Nil(t, resp.Data.Receipt)
+}
+
+func TestGraphQL_Receipts_Filters(t *testing.T) {
+	withReceipts(t)
+	older := receipt_valid_2()
+	older.PurchaseDate = "2022-01-01"
+	olderID, _ := submitReceipt(context.Background(), older)
+	newerID, _ := submitReceipt(context.Background(), receipt_valid_2())
+	target, _ := submitReceipt(context.Background(), timedReceipt("2022-01-02", "13:13", ""))
+
+	type receipts struct{ Receipts []graphQLReceipt }
+
+	// most recent purchase first
+	resp := postGraphQL[receipts](t, `{ receipts { id } }`, nil)
+	assert.Empty(t, resp.Errors)
+	if assert.Len(t, resp.Data.Receipts, 3) {
+		assert.Equal(t, newerID, resp.Data.Receipts[0].ID)
+		assert.Equal(t, target, resp.Data.Receipts[1].ID)
+		assert.Equal(t, olderID, resp.Data.Receipts[2].ID)
+	}
+
+	resp = postGraphQL[receipts](t, `{ receipts(minPoints: 100) { id } }`, nil)
+	assert.Len(t, resp.Data.Receipts, 2)
+
+	resp = postGraphQL[receipts](t, `{ receipts(purchasedAfter: "2022-01-02T00:00:00Z", purchasedBefore: "2022-03-01T00:00:00Z") { id } }`, nil)
+	if assert.Len(t, resp.Data.Receipts, 1) {
+		assert.Equal(t, target, resp.Data.Receipts[0].ID)
+	}
+
+	resp = postGraphQL[receipts](t, `{ receipts(first: 1, offset: 1) { id } }`, nil)
+	if assert.Len(t, resp.Data.Receipts, 1) {
+		assert.Equal(t, target, resp.Data.Receipts[0].ID)
+	}
+
+	resp = postGraphQL[receipts](t, `{ receipts(status: "rejected") { id } }`, nil)
+	assert.Empty(t, resp.Data.Receipts)
+
+	resp = postGraphQL[receipts](t, `{ receipts(first: 1000) { id } }`, nil)
+	assert.NotEmpty(t, resp.Errors)
+}
+
+func TestGraphQL_ProcessReceipt(t *testing.T) {
+	withReceipts(t)
+	mutation := `mutation ($receipt: ReceiptInput!) {
+		processReceipt(receipt: $receipt) { id status receipt { points } }
+	}`
+	var receipt map[string]interface{}
+	json.Unmarshal(body_valid_2, &receipt)
+
+	type payload struct {
+		ProcessReceipt *struct {
+			ID      string
+			Status  string
+			Receipt graphQLReceipt
+		}
+	}
+	resp := postGraphQL[payload](t, mutation, map[string]interface{}{"receipt": receipt})
+	assert.Empty(t, resp.Errors)
+	if assert.NotNil(t, resp.Data.ProcessReceipt) {
+		assert.Equal(t, StatusProcessed, resp.Data.ProcessReceipt.Status)
+		assert.Equal(t, body_valid_2_pts, *resp.Data.ProcessReceipt.Receipt.Points)
+		_, present := rs.get(resp.Data.ProcessReceipt.ID)
+		assert.True(t, present)
+	}
+
+	// invalid receipts are reported as errors
+	receipt["total"] = "-1.00"
+	resp = postGraphQL[payload](t, mutation, map[string]interface{}{"receipt": receipt})
+	if assert.Len(t, resp.Errors, 1) {
+		assert.Equal(t, "The receipt is invalid", resp.Errors[0].Message)
+	}
+
+	// mutations are not allowed over GET
+	w := doRequest(t, http.MethodGet, "/graphql?query="+url.QueryEscape(`mutation { processReceipt(receipt: {}) { id } }`), nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	// queries are
+	w = doRequest(t, http.MethodGet, "/graphql?query="+url.QueryEscape(`{ receipts { id } }`), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestGraphQL_Balance(t *testing.T) {
+	withJWT(t)
+	withAPIKeys(t)
+	store := withReceipts(t)
+	user1 := Owner{ClientID: "partner-a", UserID: "user-1"}
+	store.put("a", ReceiptPoints{Status: StatusProcessed, Points: 100, Owner: user1})
+	store.put("b", ReceiptPoints{Status: StatusProcessed, Points: 25, Owner: user1})
+	// voided, rejected and pending receipts earn nothing
+	store.put("c", ReceiptPoints{Status: StatusVoided, Points: 1000, Owner: user1})
+	store.put("d", ReceiptPoints{Status: StatusRejected, Owner: user1})
+	store.put("e", ReceiptPoints{Status: StatusPending, Owner: user1})
+	store.put("f", ReceiptPoints{Status: StatusProcessed, Points: 50, Owner: Owner{ClientID: "partner-b", UserID: "user-2"}})
+
+	// a user's own balance
+	query, _ := json.Marshal(map[string]string{"query": `{ balance { userId points receipts } }`})
+	token := signToken(t, jwt.SigningMethodRS256, "rsa-1", userClaims("user-1"))
+	w := doBearerRequest(t, http.MethodPost, "/graphql", query, token)
+	assert.JSONEq(t, `{"data": {"balance": {"userId": "user-1", "points": 125, "receipts": 2}}}`, w.Body.String())
+
+	// and no one else's
+	other, _ := json.Marshal(map[string]string{"query": `{ balance(userId: "user-2") { points } }`})
+	w = doBearerRequest(t, http.MethodPost, "/graphql", other, token)
+	assert.JSONEq(t, `{"data": {"balance": {"points": 0}}}`, w.Body.String())
+	w = doKeyRequest(t, http.MethodPost, "/graphql", other, "key-ops")
+	assert.JSONEq(t, `{"data": {"balance": {"points": 50}}}`, w.Body.String())
+
+	// clients name the user, and see the receipts on their key
+	w = doKeyRequest(t, http.MethodPost, "/graphql", query, "key-a")
+	assert.Contains(t, w.Body.String(), "userId is required")
+	named, _ := json.Marshal(map[string]string{"query": `{ balance(userId: "user-1") { points } receipt(id: "a") { userId clientId } }`})
+	w = doKeyRequest(t, http.MethodPost, "/graphql", named, "key-a")
+	assert.JSONEq(t, `{"data": {"balance": {"points": 125}, "receipt": {"userId": "user-1", "clientId": "partner-a"}}}`, w.Body.String())
+	w = doKeyRequest(t, http.MethodPost, "/graphql", named, "key-b")
+	assert.JSONEq(t, `{"data": {"balance": {"points": 0}, "receipt": null}}`, w.Body.String())
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/joelyoshiya/Fetch_Rewards_Backend_Coding_Challenge/receiptpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Struct definitions & constructors
+
+// Struct implementing the gRPC ReceiptService - shares validation, scoring and storage with the gin handlers
+type receiptServer struct {
+	receiptpb.UnimplementedReceiptServiceServer
+}
+
+// Constructor for the gRPC server, with the ReceiptService registered
+func newGRPCServer() *grpc.Server {
+	// messages are capped like HTTP request bodies
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(authenticateRPC, rateLimitRPC, requireSignedRPC), grpc.MaxRecvMsgSize(int(requestLimits.MaxBodyBytes)))
+	receiptpb.RegisterReceiptServiceServer(s, &receiptServer{})
+	return s
+}
+
+// Internal data
+
+// Scope each RPC needs, once API keys are configured - like the REST routes they mirror
+var rpcScopes = map[string]string{
+	receiptpb.ReceiptService_ProcessReceipt_FullMethodName: ScopeSubmit,
+	receiptpb.ReceiptService_GetPoints_FullMethodName:      ScopeRead,
+}
+
+// Route each RPC is rate limited as - it shares the buckets of the REST route it mirrors
+var rpcRoutes = map[string]string{
+	receiptpb.ReceiptService_ProcessReceipt_FullMethodName: "POST /receipts/process",
+	receiptpb.ReceiptService_GetPoints_FullMethodName:      "GET /receipts/:id/points",
+}
+
+// Internal functions - not exported
+
+// Interceptor requiring an API key (x-api-key metadata) or bearer token (authorization metadata) with the scope the RPC needs
+func authenticateRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !authEnabled() {
+		return handler(ctx, req)
+	}
+	var authorization, key string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			authorization = values[0]
+		}
+		if values := md.Get(apiKeyHeader); len(values) > 0 {
+			key = values[0]
+		}
+	}
+	p, code, description := authenticate(authorization, key, rpcScopes[info.FullMethod])
+	if p == nil {
+		if code == http.StatusForbidden {
+			return nil, status.Error(codes.PermissionDenied, description)
+		}
+		return nil, status.Error(codes.Unauthenticated, description)
+	}
+	return handler(withPrincipal(ctx, p), req)
+}
+
+// Convert a protobuf receipt to a Receipt
+func receiptFromProto(pr *receiptpb.Receipt) Receipt {
+	r := Receipt{
+		Retailer:      pr.GetRetailer(),
+		PurchaseDate:  pr.GetPurchaseDate(),
+		PurchaseTime:  pr.GetPurchaseTime(),
+		Total:         pr.GetTotal(),
+		TimeZone:      pr.GetTimeZone(),
+		Currency:      pr.GetCurrency(),
+		Subtotal:      pr.GetSubtotal(),
+		Tax:           pr.GetTax(),
+		PaymentMethod: pr.GetPaymentMethod(),
+	}
+	// proto3 cannot tell a missing list from an empty one - a receipt without items is rejected
+	for _, item := range pr.GetItems() {
+		r.Items = append(r.Items, Item{
+			ShortDescription: item.GetShortDescription(),
+			Price:            item.GetPrice(),
+			Quantity:         item.GetQuantity(),
+			UnitPrice:        item.GetUnitPrice(),
+			SKU:              item.GetSku(),
+			UPC:              item.GetUpc(),
+		})
+	}
+	for _, d := range pr.GetDiscounts() {
+		r.Discounts = append(r.Discounts, Discount{Description: d.GetDescription(), Amount: d.GetAmount()})
+	}
+	return r
+}
+
+// Listen on GRPC_PORT (default 9090) and serve the gRPC API on s
+func serveGRPC(s *grpc.Server) error {
+	port := os.Getenv("GRPC_PORT")
+	if port == "" {
+		port = "9090"
+	}
+	lis, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return err
+	}
+	return s.Serve(lis)
+}
+
+// Stop the gRPC server gracefully - letting RPCs in progress finish - or abruptly once ctx is done
+func stopGRPC(ctx context.Context, s *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.Stop()
+	}
+}
+
+// Internal RPC Functions
+
+// RPC: ProcessReceipt - mirrors POST /receipts/process
+// Errors: InvalidArgument if the receipt is invalid, ResourceExhausted if async and the queue is full
+func (s *receiptServer) ProcessReceipt(ctx context.Context, req *receiptpb.ProcessReceiptRequest) (*receiptpb.ProcessReceiptResponse, error) {
+	if req.GetReceipt() == nil {
+		return nil, status.Error(codes.InvalidArgument, "The receipt is invalid")
+	}
+	r := receiptFromProto(req.GetReceipt())
+
+	// asynchronous mode - queue the receipt and return its ID straight away
+	if req.GetAsync() || asyncByDefault {
+		id, queued := enqueueReceipt(ctx, ReceiptPoints{Receipt: r}, func(ctx context.Context) (Receipt, error) { return r, nil })
+		if !queued {
+			return nil, status.Error(codes.ResourceExhausted, "The processing queue is full")
+		}
+		return &receiptpb.ProcessReceiptResponse{Id: id, Status: StatusPending}, nil
+	}
+
+	// validate, score and store receipt
+	id, err := submitReceipt(ctx, r)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "The receipt is invalid")
+	}
+	return &receiptpb.ProcessReceiptResponse{Id: id, Status: StatusProcessed}, nil
+}
+
+// RPC: GetPoints - mirrors GET /receipts/{id}/points
+// Errors: NotFound if there is no receipt with the ID. Points are only set once the receipt is processed
+func (s *receiptServer) GetPoints(ctx context.Context, req *receiptpb.GetPointsRequest) (*receiptpb.GetPointsResponse, error) {
+	rp, present := rs.get(req.GetId())
+	if !present || !canAccess(ctx, rp.Owner) {
+		return nil, status.Error(codes.NotFound, "No receipt found for that id")
+	}
+	resp := &receiptpb.GetPointsResponse{Status: rp.Status}
+	if rp.Status == StatusProcessed {
+		resp.Points = int64(rp.Points)
+	}
+	return resp, nil
+}
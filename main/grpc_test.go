@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/joelyoshiya/Fetch_Rewards_Backend_Coding_Challenge/receiptpb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// body_valid_2 as a protobuf message
+var proto_valid_2 = &receiptpb.Receipt{
+	Retailer:     "M&M Corner Market",
+	PurchaseDate: "2022-03-20",
+	PurchaseTime: "14:33",
+	Items: []*receiptpb.Item{
+		{ShortDescription: "Gatorade", Price: "2.25"},
+		{ShortDescription: "Gatorade", Price: "2.25"},
+		{ShortDescription: "Gatorade", Price: "2.25"},
+		{ShortDescription: "Gatorade", Price: "2.25"},
+	},
+	Total: "9.00",
+}
+
+// start the gRPC server on an in-memory listener and return a client for it
+func newGRPCClient(t *testing.T) receiptpb.ReceiptServiceClient {
+	lis := bufconn.Listen(1 << 20)
+	server := newGRPCServer()
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return receiptpb.NewReceiptServiceClient(conn)
+}
+
+func TestGRPC_ProcessReceipt(t *testing.T) {
+	client := newGRPCClient(t)
+	ctx := context.Background()
+
+	resp, err := client.ProcessReceipt(ctx, &receiptpb.ProcessReceiptRequest{Receipt: proto_valid_2})
+	assert.NoError(t, err)
+	assert.Equal(t, StatusProcessed, resp.Status)
+
+	// same store as the REST routes
+	points, err := client.GetPoints(ctx, &receiptpb.GetPointsRequest{Id: resp.Id})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(body_valid_2_pts), points.Points)
+	w := doRequest(t, "GET", "/receipts/"+resp.Id+"/points", nil)
+	assert.JSONEq(t, `{"points": 109}`, w.Body.String())
+}
+
+func TestGRPC_ProcessReceipt_Async(t *testing.T) {
+	withQueue(t, 10, 1)
+	client := newGRPCClient(t)
+
+	resp, err := client.ProcessReceipt(context.Background(), &receiptpb.ProcessReceiptRequest{Receipt: proto_valid_2, Async: true})
+	assert.NoError(t, err)
+	assert.Equal(t, StatusPending, resp.Status)
+	assert.Equal(t, body_valid_2_pts, waitForStatus(t, resp.Id).Points)
+}
+
+func TestGRPC_Bad_Requests(t *testing.T) {
+	client := newGRPCClient(t)
+	ctx := context.Background()
+
+	// missing receipt
+	_, err := client.ProcessReceipt(ctx, &receiptpb.ProcessReceiptRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// invalid total
+	_, err = client.ProcessReceipt(ctx, &receiptpb.ProcessReceiptRequest{Receipt: &receiptpb.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Items:        []*receiptpb.Item{{ShortDescription: "Mountain Dew 12PK", Price: "6.49"}},
+		Total:        "-6.49",
+	}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// no items
+	_, err = client.ProcessReceipt(ctx, &receiptpb.ProcessReceiptRequest{Receipt: &receiptpb.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: "2022-01-01",
+		PurchaseTime: "13:01",
+		Total:        "0.00",
+	}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.GetPoints(ctx, &receiptpb.GetPointsRequest{Id: "unknown"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
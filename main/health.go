@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Struct definitions & constructors
+
+// Struct representing the build the service is running
+type BuildInfo struct {
+	Commit         string `json:"commit"`
+	BuildTime      string `json:"buildTime"`
+	GoVersion      string `json:"goVersion"`
+	RulesetVersion string `json:"rulesetVersion"`
+	ScoringMode    string `json:"scoringMode"`
+}
+
+// Internal data
+
+// Commit and build time - set at build time with -ldflags "-X main.gitCommit=... -X main.buildTime=..."
+// Left empty, they fall back to the version control info Go stamps into binaries built in a checkout
+var (
+	gitCommit string
+	buildTime string
+)
+
+// Where the service is in its lifecycle - starting until main has started serving, draining once shutdown begins
+const (
+	lifecycleStarting int32 = iota
+	lifecycleServing
+	lifecycleDraining
+)
+
+// The service's lifecycle state - read by readiness, so accessed atomically
+var lifecycle = lifecycleStarting
+
+// Lifecycle states as reported by readiness
+var lifecycleNames = map[int32]string{
+	lifecycleStarting: "starting",
+	lifecycleServing:  "serving",
+	lifecycleDraining: "draining",
+}
+
+// Internal functions - not exported
+
+// Move the service to a lifecycle state
+func setLifecycle(state int32) {
+	atomic.StoreInt32(&lifecycle, state)
+}
+
+// The service's current lifecycle state
+func currentLifecycle() int32 {
+	return atomic.LoadInt32(&lifecycle)
+}
+
+// The build the service is running - unknown fields are reported as "unknown"
+func buildInfo() BuildInfo {
+	info := BuildInfo{Commit: gitCommit, BuildTime: buildTime, GoVersion: runtime.Version(), RulesetVersion: rulesetVersion, ScoringMode: scoringMode}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range bi.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildTime == "":
+				info.BuildTime = setting.Value
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildTime == "" {
+		info.BuildTime = "unknown"
+	}
+	return info
+}
+
+// Internal Route Functions
+
+// Path: /healthz
+// Method: GET
+// Payload: None
+// Response: A JSON object with the status ok.
+// Description: Liveness probe - answers as long as the process is serving requests.
+func getHealth(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Path: /readyz
+// Method: GET
+// Payload: None
+// Response: A JSON object with the status ready or unavailable, and the result of each check.
+// Description: Readiness probe - checks the service has finished starting and is not draining, and the scoring rules are loaded. Responds 503 if either fails.
+func getReadiness(c *gin.Context) {
+	state := currentLifecycle()
+	checks := gin.H{"lifecycle": lifecycleNames[state], "rules": "ok"}
+	ready := state == lifecycleServing
+	if len(scoringRules) == 0 || !validScoringMode(scoringMode) {
+		checks["rules"], ready = "not loaded", false
+	}
+	if len(scoringRules) == 0 || !validScoringMode(scoringMode) {
+		checks["rules"], ready = "not loaded", false
+	}
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "checks": checks})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready", "checks": checks})
+}
+
+// Path: /version
+// Method: GET
+// Payload: None
+// Response: A JSON object with the git commit, build time, Go version, ruleset version and scoring mode.
+// Description: Identifies the build serving requests, and the rules it scores receipts with.
+func getVersion(c *gin.Context) {
+	c.JSON(http.StatusOK, buildInfo())
+}
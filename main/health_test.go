@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealth(t *testing.T) {
+	w := doRequest(t, http.MethodGet, "/healthz", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status": "ok"}`, w.Body.String())
+}
+
+// put the service in a lifecycle state for the duration of a test
+func withLifecycle(t *testing.T, state int32) {
+	previous := currentLifecycle()
+	setLifecycle(state)
+	t.Cleanup(func() { setLifecycle(previous) })
+}
+
+func TestReadiness(t *testing.T) {
+	withLifecycle(t, lifecycleStarting)
+
+	// not ready until startup is complete
+	w := doRequest(t, http.MethodGet, "/readyz", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.JSONEq(t, `{"status": "unavailable", "checks": {"lifecycle": "starting", "rules": "ok"}}`, w.Body.String())
+
+	setLifecycle(lifecycleServing)
+	w = doRequest(t, http.MethodGet, "/readyz", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status": "ready", "checks": {"lifecycle": "serving", "rules": "ok"}}`, w.Body.String())
+
+	// nor once draining
+	setLifecycle(lifecycleDraining)
+	w = doRequest(t, http.MethodGet, "/readyz", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.JSONEq(t, `{"status": "unavailable", "checks": {"lifecycle": "draining", "rules": "ok"}}`, w.Body.String())
+
+	// nor with missing rules
+	setLifecycle(lifecycleServing)
+	rules := scoringRules
+	scoringRules = nil
+	t.Cleanup(func() { scoringRules = rules })
+	w = doRequest(t, http.MethodGet, "/readyz", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.JSONEq(t, `{"status": "unavailable", "checks": {"lifecycle": "serving", "rules": "not loaded"}}`, w.Body.String())
+}
+
+func TestVersion(t *testing.T) {
+	withScoringMode(t, ScoringModeUnicode)
+	commit, built := gitCommit, buildTime
+	t.Cleanup(func() { gitCommit, buildTime = commit, built })
+
+	// test binaries carry no version control info
+	gitCommit, buildTime = "", ""
+	assert.Equal(t, BuildInfo{Commit: "unknown", BuildTime: "unknown", GoVersion: runtime.Version(), RulesetVersion: rulesetVersion, ScoringMode: ScoringModeUnicode}, buildInfo())
+
+	gitCommit, buildTime = "542cdd2", "2024-01-01T12:00:00Z"
+	w := doRequest(t, http.MethodGet, "/version", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"commit": "542cdd2", "buildTime": "2024-01-01T12:00:00Z", "goVersion": "`+runtime.Version()+`", "rulesetVersion": "1", "scoringMode": "unicode"}`, w.Body.String())
+}
+
+func TestProbes_Not_Rate_Limited(t *testing.T) {
+	withRateLimits(t, `{"default": {"requests": 1, "per": "1h"}}`)
+	withNow(t, time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	withLifecycle(t, lifecycleServing)
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, doRequest(t, http.MethodGet, "/healthz", nil).Code)
+		assert.Equal(t, http.StatusOK, doRequest(t, http.MethodGet, "/readyz", nil).Code)
+	}
+	assert.Equal(t, http.StatusOK, doRequest(t, http.MethodGet, "/version", nil).Code)
+	assert.Equal(t, http.StatusTooManyRequests, doRequest(t, http.MethodGet, "/version", nil).Code)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Struct definitions & constructors
+
+// Struct representing an uploaded receipt image
+type ReceiptImage struct {
+	ID          string    `json:"id"`
+	ContentType string    `json:"contentType"`
+	Data        []byte    `json:"-"`
+	UploadedAt  time.Time `json:"uploadedAt"`
+	// who uploaded the image
+	Owner
+}
+
+// Struct representing Images - internal storage of uploaded receipt images
+// Images are dropped once older than maxAge, and the oldest first once they take up more than maxBytes
+type Images struct {
+	mu sync.RWMutex
+	// store a map of images accessed via ID
+	ImagesMap map[string]ReceiptImage `json:"images"`
+	// IDs in upload order, and the bytes stored
+	order []string
+	bytes int64
+	// limits on the bytes stored and how long images are kept
+	maxBytes int64
+	maxAge   time.Duration
+}
+
+// Constructor for Images
+func NewImages() *Images {
+	var is Images
+	is.ImagesMap = make(map[string]ReceiptImage)
+	is.maxBytes = defaultImageStoreBytes
+	is.maxAge = defaultImageRetention
+	return &is
+}
+
+// Internal data
+
+// Global images object - in place of persisting data
+var images = NewImages() // pointer to Images object
+
+// Largest image accepted by the upload route
+const maxImageSize = 10 << 20 // 10 MB
+
+// Default limits on the image store - 1 GB, kept for 30 days
+const (
+	defaultImageStoreBytes = 1 << 30
+	defaultImageRetention  = 30 * 24 * time.Hour
+)
+
+// Image types accepted by the upload route, as sniffed from the uploaded bytes
+var imageContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// Internal functions - not exported
+
+// Store an image uploaded by a client and return its ID
+// Expired images are dropped first, then the oldest until the new one fits
+func (is *Images) add(contentType string, data []byte, owner Owner) string {
+	id := uuid.New().String()
+	at := now()
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	for len(is.order) > 0 {
+		oldest := is.ImagesMap[is.order[0]]
+		if at.Sub(oldest.UploadedAt) < is.maxAge && is.bytes+int64(len(data)) <= is.maxBytes {
+			break
+		}
+		delete(is.ImagesMap, oldest.ID)
+		is.order = is.order[1:]
+		is.bytes -= int64(len(oldest.Data))
+	}
+	is.ImagesMap[id] = ReceiptImage{ID: id, ContentType: contentType, Data: data, UploadedAt: at, Owner: owner}
+	is.order = append(is.order, id)
+	is.bytes += int64(len(data))
+	return id
+}
+
+// Count the images stored
+func (is *Images) size() int {
+	is.mu.RLock()
+	defer is.mu.RUnlock()
+	return len(is.ImagesMap)
+}
+
+// Get the image with the given ID - expired images are not returned, even before they are dropped
+func (is *Images) get(id string) (ReceiptImage, bool) {
+	is.mu.RLock()
+	defer is.mu.RUnlock()
+	img, present := is.ImagesMap[id]
+	if present && now().Sub(img.UploadedAt) >= is.maxAge {
+		return ReceiptImage{}, false
+	}
+	return img, present
+}
+
+// Configure the image store's limits from the environment
+// IMAGE_STORE_BYTES caps the bytes stored, IMAGE_RETENTION how long images are kept
+func imagesFromEnv() (*Images, error) {
+	is := NewImages()
+	if raw := os.Getenv("IMAGE_STORE_BYTES"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n < maxImageSize {
+			return nil, fmt.Errorf("invalid IMAGE_STORE_BYTES %q - needs at least %d bytes, the largest image", raw, maxImageSize)
+		}
+		is.maxBytes = n
+	}
+	if raw := os.Getenv("IMAGE_RETENTION"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid IMAGE_RETENTION %q - needs a positive duration like 720h", raw)
+		}
+		is.maxAge = d
+	}
+	return is, nil
+}
+
+// Internal Route Functions
+
+// Path: /receipts/process/image
+// Method: POST
+// Payload: multipart/form-data with the receipt photo in the "image" field
+// Response: JSON containing an id for the receipt, the id of the stored image, the extracted receipt and per-field confidence.
+// Description: Stores the photo, extracts a receipt with the configured OCR provider and processes it like a JSON receipt.
+// With ?async=true (or "Prefer: respond-async") OCR runs on a worker and 202 is returned with the IDs and a pending status.
+func processImageReceipt(c *gin.Context) {
+	if ocr == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"description": "No OCR provider is configured"})
+		return
+	}
+
+	// read uploaded image - reject anything too large or that is not an image
+	header, err := c.FormFile("image")
+	if bodyTooLarge(c) {
+		tooLarge(c)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"description": "The image is missing"})
+		return
+	}
+	if header.Size > maxImageSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"description": "The image is too large"})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"description": "The image is invalid"})
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxImageSize))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"description": "The image is invalid"})
+		return
+	}
+	contentType := http.DetectContentType(data)
+	if !imageContentTypes[contentType] {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"description": "The image must be a JPEG, PNG, GIF or WebP"})
+		return
+	}
+
+	// store image before extraction, so rejected receipts can still be reviewed
+	imageID := images.add(contentType, data, ownerFrom(c.Request.Context()))
+
+	// asynchronous mode - run OCR on a worker and return the receipt ID straight away
+	if wantsAsync(c) {
+		id, queued := enqueueReceipt(c.Request.Context(), ReceiptPoints{ImageID: imageID}, func(ctx context.Context) (Receipt, error) {
+			parsed, err := ocr.ExtractReceipt(ctx, data, contentType)
+			return parsed.Receipt, err
+		})
+		if !queued {
+			queueFull(c)
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"id": id, "imageId": imageID, "status": StatusPending})
+		return
+	}
+
+	// extract receipt
+	parsed, err := ocr.ExtractReceipt(c.Request.Context(), data, contentType)
+	if errors.Is(err, ErrNoReceiptFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"description": "The receipt is invalid", "imageId": imageID})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"description": "The receipt could not be read", "imageId": imageID})
+		return
+	}
+
+	// validate, score and store receipt - same pipeline as JSON receipts, linked back to the image
+	id, err := submitReceiptWithImage(c.Request.Context(), parsed.Receipt, imageID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"description": "The receipt is invalid", "imageId": imageID, "receipt": parsed.Receipt, "confidence": parsed.Confidence})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": id, "imageId": imageID, "receipt": parsed.Receipt, "confidence": parsed.Confidence})
+}
+
+// Path: /images/{id}
+// Method: GET
+// Response: The uploaded image.
+// Description: Returns a receipt image uploaded to /receipts/process/image.
+func getImage(c *gin.Context) {
+	img, present := images.get(c.Param("id"))
+	if !present || !canAccess(c.Request.Context(), img.Owner) {
+		c.JSON(http.StatusNotFound, gin.H{"description": "No image found for that id"})
+		return
+	}
+	c.Data(http.StatusOK, img.ContentType, img.Data)
+}
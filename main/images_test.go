@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fake photos - the PNG signature is enough for content type sniffing, the rest tells them apart
+var image_valid_2 = append([]byte("\x89PNG\r\n\x1a\n"), []byte("m&m corner market receipt")...)
+var image_blurry = append([]byte("\x89PNG\r\n\x1a\n"), []byte("too blurry to read")...)
+var image_not_a_receipt = append([]byte("\x89PNG\r\n\x1a\n"), []byte("a photo of a cat")...)
+
+// use a fake OCR provider for the duration of a test
+func withFakeOCR(t *testing.T) *FakeOCRProvider {
+	fake := NewFakeOCRProvider()
+	previous := ocr
+	ocr = fake
+	t.Cleanup(func() { ocr = previous })
+	return fake
+}
+
+// upload an image to the image route and decode the response
+func postImage(t *testing.T, field string, data []byte) (int, map[string]interface{}) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile(field, "receipt.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	form.Close()
+
+	router := setupRouter()
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodPost, "/receipts/process/image", &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	router.ServeHTTP(w, req)
+	var resp map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, resp
+}
+
+func TestFakeOCRProvider(t *testing.T) {
+	fake := NewFakeOCRProvider()
+	fake.Register(image_valid_2, text_valid_2)
+
+	parsed, err := fake.ExtractReceipt(context.Background(), image_valid_2, "image/png")
+	assert.NoError(t, err)
+	assert.Equal(t, "M&M Corner Market", parsed.Receipt.Retailer)
+
+	// deterministic - the same image always reads the same
+	again, _ := fake.ExtractReceipt(context.Background(), image_valid_2, "image/png")
+	assert.Equal(t, parsed, again)
+
+	_, err = fake.ExtractReceipt(context.Background(), image_not_a_receipt, "image/png")
+	assert.ErrorIs(t, err, ErrNoReceiptFound)
+}
+
+func TestProcessImageReceipt(t *testing.T) {
+	fake := withFakeOCR(t)
+	fake.Register(image_valid_2, text_valid_2)
+	fake.Register(image_blurry, "M&M CORNER\n?? ?? ??\n")
+
+	code, resp := postImage(t, "image", image_valid_2)
+	assert.Equal(t, http.StatusOK, code)
+	id := resp["id"].(string)
+	imageID := resp["imageId"].(string)
+
+	// receipt is scored and linked back to the image
+	assert.Equal(t, body_valid_2_pts, rs.ReceiptsMap[id].Points)
+	assert.Equal(t, imageID, rs.ReceiptsMap[id].ImageID)
+
+	// image can be fetched
+	w := doRequest(t, http.MethodGet, "/images/"+imageID, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	assert.Equal(t, image_valid_2, w.Body.Bytes())
+
+	// unreadable receipt is rejected, but the image is kept
+	code, resp = postImage(t, "image", image_blurry)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "The receipt is invalid", resp["description"])
+	_, present := images.get(resp["imageId"].(string))
+	assert.True(t, present)
+
+	// no receipt in the image
+	code, _ = postImage(t, "image", image_not_a_receipt)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestSubmitReceiptWithImage(t *testing.T) {
+	withReceipts(t)
+	// the image is linked by the time the receipt is stored and its event published
+	_, _, events := receiptEvents.listen(0, false)
+	defer receiptEvents.unlisten(events)
+	id, err := submitReceiptWithImage(context.Background(), receipt_valid_2(), "image-1")
+	assert.NoError(t, err)
+	se := <-events
+	assert.Equal(t, id, se.Event.Data.ReceiptID)
+	rp, _ := rs.get(id)
+	assert.Equal(t, "image-1", rp.ImageID)
+}
+
+func TestImages_Limits(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	withNow(t, start)
+	is := NewImages()
+	is.maxBytes = 10
+	is.maxAge = time.Hour
+
+	// the oldest images are dropped to make room
+	first := is.add("image/png", []byte("12345"), Owner{})
+	second := is.add("image/png", []byte("12345"), Owner{})
+	third := is.add("image/png", []byte("123"), Owner{})
+	_, present := is.get(first)
+	assert.False(t, present)
+	assert.Equal(t, 2, is.size())
+	assert.Equal(t, int64(8), is.bytes)
+
+	// and expired ones are neither returned nor kept
+	withNow(t, start.Add(time.Hour))
+	_, present = is.get(second)
+	assert.False(t, present)
+	fourth := is.add("image/png", []byte("1"), Owner{})
+	assert.Equal(t, []string{fourth}, is.order)
+	_, present = is.get(third)
+	assert.False(t, present)
+}
+
+func TestImagesFromEnv(t *testing.T) {
+	is, err := imagesFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(defaultImageStoreBytes), is.maxBytes)
+
+	t.Setenv("IMAGE_STORE_BYTES", "104857600")
+	t.Setenv("IMAGE_RETENTION", "24h")
+	is, err = imagesFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100<<20), is.maxBytes)
+	assert.Equal(t, 24*time.Hour, is.maxAge)
+
+	for name, value := range map[string]string{"IMAGE_STORE_BYTES": "1024", "IMAGE_RETENTION": "forever"} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			_, err := imagesFromEnv()
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestProcessImageReceipt_Bad_Upload(t *testing.T) {
+	withFakeOCR(t)
+
+	// wrong form field
+	code, resp := postImage(t, "photo", image_valid_2)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "The image is missing", resp["description"])
+
+	// not an image
+	code, _ = postImage(t, "image", []byte("%PDF-1.4 not an image"))
+	assert.Equal(t, http.StatusUnsupportedMediaType, code)
+
+	// unknown image
+	w := doRequest(t, http.MethodGet, "/images/unknown", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestProcessImageReceipt_No_Provider(t *testing.T) {
+	previous := ocr
+	ocr = nil
+	t.Cleanup(func() { ocr = previous })
+
+	code, _ := postImage(t, "image", image_valid_2)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+}
+
+func TestOCRProviderFromEnv(t *testing.T) {
+	t.Setenv("OCR_PROVIDER", "")
+	provider, err := ocrProviderFromEnv()
+	assert.NoError(t, err)
+	assert.Nil(t, provider)
+
+	t.Setenv("OCR_PROVIDER", "fake")
+	provider, err = ocrProviderFromEnv()
+	assert.NoError(t, err)
+	assert.IsType(t, &FakeOCRProvider{}, provider)
+
+	t.Setenv("OCR_PROVIDER", "tesseract")
+	t.Setenv("TESSERACT_PATH", "/nonexistent/tesseract")
+	_, err = ocrProviderFromEnv()
+	assert.Error(t, err)
+
+	t.Setenv("OCR_PROVIDER", "magic")
+	_, err = ocrProviderFromEnv()
+	assert.Error(t, err)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Struct definitions & constructors
+
+// Struct representing a key in a JWKS file - RSA keys use n and e, EC keys crv, x and y
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// Struct representing a JWT verifier - the identity provider's public keys and the claims tokens must carry
+type JWTVerifier struct {
+	// public keys by key ID
+	keys map[string]crypto.PublicKey
+	// expected iss and aud claims - not checked if empty
+	issuer   string
+	audience string
+	// claim holding the user ID
+	userClaim string
+}
+
+// Constructor for JWTVerifier - loads the keys from a local JWKS file
+func NewJWTVerifier(jwksPath string, issuer string, audience string, userClaim string) (*JWTVerifier, error) {
+	keys, err := loadJWKS(jwksPath)
+	if err != nil {
+		return nil, err
+	}
+	if userClaim == "" {
+		userClaim = "sub"
+	}
+	return &JWTVerifier{keys: keys, issuer: issuer, audience: audience, userClaim: userClaim}, nil
+}
+
+// Internal data
+
+// Global JWT verifier - nil until JWKS_FILE is configured, when bearer tokens are not accepted
+var jwtVerifier *JWTVerifier
+
+// Signing algorithms accepted - RS256 for RSA keys, ES256 for P-256 keys
+var jwtAlgorithms = []string{"RS256", "ES256"}
+
+// Scopes end users get - they can submit receipts and read their own
+var userScopes = []string{ScopeSubmit, ScopeRead}
+
+// Clock skew allowed when checking exp and nbf
+const jwtLeeway = 30 * time.Second
+
+// Internal functions - not exported
+
+// Load the public keys from a JWKS file - {"keys": [...]}
+func loadJWKS(path string) (map[string]crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []JSONWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys found")
+	}
+	return keys, nil
+}
+
+// Decode a base64url number
+func jwkNumber(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid base64url number")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// Build the public key a JWK describes
+func (jwk JSONWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := jwkNumber(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := jwkNumber(jwk.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := jwkNumber(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := jwkNumber(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+}
+
+// Verify a token's signature and claims, returning the user ID it carries
+func (v *JWTVerifier) verify(token string) (string, error) {
+	opts := []jwt.ParserOption{jwt.WithValidMethods(jwtAlgorithms), jwt.WithLeeway(jwtLeeway)}
+	if v.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(v.issuer))
+	}
+	if v.audience != "" {
+		opts = append(opts, jwt.WithAudience(v.audience))
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, present := v.keys[kid]
+		if !present {
+			return nil, fmt.Errorf("unknown key %q", kid)
+		}
+		// the key type must match the algorithm, so an RSA key is never used to check an EC signature or the other way round
+		if _, isRSA := key.(*rsa.PublicKey); isRSA != (t.Method.Alg() == "RS256") {
+			return nil, errors.New("key does not match the algorithm")
+		}
+		return key, nil
+	}, opts...)
+	if err != nil {
+		return "", err
+	}
+	// tokens must expire
+	if exp, err := claims.GetExpirationTime(); err != nil || exp == nil {
+		return "", errors.New("token has no expiry")
+	}
+	userID, _ := claims[v.userClaim].(string)
+	if userID == "" {
+		return "", fmt.Errorf("token has no %s claim", v.userClaim)
+	}
+	return userID, nil
+}
+
+// The token in an "Authorization: Bearer <token>" header, if there is one
+func bearerToken(authorization string) (string, bool) {
+	const prefix = "bearer "
+	if len(authorization) <= len(prefix) || !strings.EqualFold(authorization[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(authorization[len(prefix):]), true
+}
+
+// Configure the JWT verifier from the environment
+// JWKS_FILE turns bearer tokens on, JWT_ISSUER and JWT_AUDIENCE are checked if set, and JWT_USER_CLAIM names the user ID claim (default sub)
+func jwtVerifierFromEnv() (*JWTVerifier, error) {
+	path := os.Getenv("JWKS_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	return NewJWTVerifier(path, os.Getenv("JWT_ISSUER"), os.Getenv("JWT_AUDIENCE"), os.Getenv("JWT_USER_CLAIM"))
+}
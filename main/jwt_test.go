@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// signing keys standing in for the identity provider's
+var (
+	testRSAKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+	testECKey, _  = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+)
+
+const testIssuer = "https://id.example.com"
+
+// write the test keys' JWKS file and return its path
+func writeJWKS(t *testing.T) string {
+	b64 := func(n *big.Int) string { return base64.RawURLEncoding.EncodeToString(n.Bytes()) }
+	set := map[string][]JSONWebKey{"keys": {
+		{Kty: "RSA", Kid: "rsa-1", Use: "sig", N: b64(testRSAKey.N), E: b64(big.NewInt(int64(testRSAKey.E)))},
+		{Kty: "EC", Kid: "ec-1", Crv: "P-256", X: b64(testECKey.X), Y: b64(testECKey.Y)},
+	}}
+	data, _ := json.Marshal(set)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// accept bearer tokens signed with the test keys for the duration of a test
+func withJWT(t *testing.T) *JWTVerifier {
+	v, err := NewJWTVerifier(writeJWKS(t), testIssuer, "receipts", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := jwtVerifier
+	jwtVerifier = v
+	t.Cleanup(func() { jwtVerifier = previous })
+	return v
+}
+
+// a token for a user, valid for an hour
+func userClaims(sub string) jwt.MapClaims {
+	return jwt.MapClaims{"sub": sub, "iss": testIssuer, "aud": "receipts", "exp": time.Now().Add(time.Hour).Unix()}
+}
+
+// sign claims with a test key
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	var key interface{} = testRSAKey
+	if method == jwt.SigningMethodES256 {
+		key = testECKey
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// perform a request with a bearer token against a fresh router
+func doBearerRequest(t *testing.T, method string, path string, body []byte, token string) *httptest.ResponseRecorder {
+	router := setupRouter()
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest(method, path, bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestJWTVerifier(t *testing.T) {
+	v := withJWT(t)
+
+	userID, err := v.verify(signToken(t, jwt.SigningMethodRS256, "rsa-1", userClaims("user-1")))
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", userID)
+	userID, err = v.verify(signToken(t, jwt.SigningMethodES256, "ec-1", userClaims("user-2")))
+	assert.NoError(t, err)
+	assert.Equal(t, "user-2", userID)
+
+	expired := userClaims("user-1")
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	noExpiry := userClaims("user-1")
+	delete(noExpiry, "exp")
+	wrongIssuer := userClaims("user-1")
+	wrongIssuer["iss"] = "https://evil.example.com"
+	wrongAudience := userClaims("user-1")
+	wrongAudience["aud"] = "other-service"
+	noUser := userClaims("")
+	hs256, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, userClaims("user-1")).SignedString([]byte("secret"))
+
+	rejected := map[string]string{
+		"expired":        signToken(t, jwt.SigningMethodRS256, "rsa-1", expired),
+		"no expiry":      signToken(t, jwt.SigningMethodRS256, "rsa-1", noExpiry),
+		"wrong issuer":   signToken(t, jwt.SigningMethodRS256, "rsa-1", wrongIssuer),
+		"wrong audience": signToken(t, jwt.SigningMethodRS256, "rsa-1", wrongAudience),
+		"no user":        signToken(t, jwt.SigningMethodRS256, "rsa-1", noUser),
+		"unknown key":    signToken(t, jwt.SigningMethodRS256, "rsa-2", userClaims("user-1")),
+		"wrong key type": signToken(t, jwt.SigningMethodES256, "rsa-1", userClaims("user-1")),
+		"HS256":          hs256,
+		"garbage":        "not.a.token",
+	}
+	for name, token := range rejected {
+		_, err := v.verify(token)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestLoadJWKS_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	os.WriteFile(path, []byte(`{"keys": [{"kty": "EC", "kid": "ec-1", "crv": "P-384", "x": "AA", "y": "AA"}]}`), 0o600)
+	_, err := loadJWKS(path)
+	assert.Error(t, err)
+	os.WriteFile(path, []byte(`{"keys": []}`), 0o600)
+	_, err = loadJWKS(path)
+	assert.Error(t, err)
+}
+
+func TestJWT_Receipts_Scoped_To_User(t *testing.T) {
+	withJWT(t)
+	withReceipts(t)
+	alice := signToken(t, jwt.SigningMethodRS256, "rsa-1", userClaims("alice"))
+	bob := signToken(t, jwt.SigningMethodES256, "ec-1", userClaims("bob"))
+
+	w := doBearerRequest(t, http.MethodPost, "/receipts/process", body_valid_2, alice)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var created map[string]string
+	json.Unmarshal(w.Body.Bytes(), &created)
+	rp, _ := rs.get(created["id"])
+	assert.Equal(t, Owner{UserID: "alice"}, rp.Owner)
+
+	assert.Equal(t, http.StatusOK, doBearerRequest(t, http.MethodGet, "/receipts/"+created["id"]+"/points", nil, alice).Code)
+	assert.Equal(t, http.StatusNotFound, doBearerRequest(t, http.MethodGet, "/receipts/"+created["id"]+"/points", nil, bob).Code)
+
+	// end users cannot use the admin routes
+	w = doBearerRequest(t, http.MethodGet, "/merchants", nil, alice)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// 401s say why, and how to authenticate
+	w = doBearerRequest(t, http.MethodGet, "/receipts/"+created["id"]+"/points", nil, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.JSONEq(t, `{"description": "An API key or bearer token is required"}`, w.Body.String())
+	w = doBearerRequest(t, http.MethodGet, "/receipts/"+created["id"]+"/points", nil, "not.a.token")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Bearer error="invalid_token"`, w.Header().Get("WWW-Authenticate"))
+
+	// API keys keep working alongside bearer tokens
+	withAPIKeys(t)
+	assert.Equal(t, http.StatusOK, doKeyRequest(t, http.MethodGet, "/receipts/"+created["id"]+"/points", nil, "key-ops").Code)
+	assert.Equal(t, http.StatusNotFound, doKeyRequest(t, http.MethodGet, "/receipts/"+created["id"]+"/points", nil, "key-a").Code)
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Struct definitions & constructors
+
+// Struct representing the limits on what a request may contain
+type RequestLimits struct {
+	// largest request body - image uploads may be up to maxImageSize on top
+	MaxBodyBytes int64
+	// most elements in a JSON array, and most items on a receipt
+	MaxItems int
+	// longest JSON string or object key, and longest retailer and item description, in characters
+	MaxStringLength int
+	// deepest nesting of JSON objects and arrays
+	MaxDepth int
+}
+
+// Constructor for RequestLimits - the defaults
+func NewRequestLimits() *RequestLimits {
+	return &RequestLimits{MaxBodyBytes: 1 << 20, MaxItems: 1000, MaxStringLength: 1000, MaxDepth: 20}
+}
+
+// Struct representing a request body that stops reading past the limit
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	exceeded  bool
+}
+
+// Struct representing a JSON body that breaks a limit - where, and which
+type limitError struct {
+	ValidationError
+}
+
+func (e *limitError) Error() string {
+	return e.Field + " " + e.Reason
+}
+
+// Internal data
+
+// Global request limits object
+var requestLimits = NewRequestLimits() // pointer to RequestLimits object
+
+// Error reading a body past the limit
+var errBodyTooLarge = errors.New("request body too large")
+
+// Room for the multipart headers around an uploaded image
+const multipartOverhead = 1 << 20
+
+// gin context key for the request's limitedBody
+const limitedBodyKey = "limitedBody"
+
+// Internal functions - not exported
+
+// Read up to the limit - a read past it fails with errBodyTooLarge
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.exceeded {
+		return 0, errBodyTooLarge
+	}
+	// read one byte more than allowed, to tell a body at the limit from one over it
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) <= b.remaining {
+		b.remaining -= int64(n)
+		return n, err
+	}
+	n, b.remaining, b.exceeded = int(b.remaining), 0, true
+	return n, errBodyTooLarge
+}
+
+// Largest body accepted on a route
+func (l *RequestLimits) bodyLimit(route string) int64 {
+	if route == "/receipts/process/image" && l.MaxBodyBytes < maxImageSize+multipartOverhead {
+		return maxImageSize + multipartOverhead
+	}
+	return l.MaxBodyBytes
+}
+
+// Check a JSON document against the depth, array length and string length limits, without decoding it
+// Syntax errors are left for binding to report
+func (l *RequestLimits) checkJSON(data []byte) *ValidationError {
+	// one frame per open object or array - the key or index being read
+	type frame struct {
+		array     bool
+		key       string
+		count     int
+		expectKey bool
+	}
+	var stack []*frame
+	path := func(frames []*frame) string {
+		var b strings.Builder
+		for _, f := range frames {
+			switch {
+			case f.array && f.count > 0:
+				b.WriteString("[" + strconv.Itoa(f.count-1) + "]")
+			case !f.array && f.key != "":
+				if b.Len() > 0 {
+					b.WriteString(".")
+				}
+				b.WriteString(f.key)
+			}
+		}
+		return b.String()
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+		var top *frame
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+		if top != nil && !top.array && top.expectKey {
+			if key, ok := tok.(string); ok {
+				if utf8.RuneCountInString(key) > l.MaxStringLength {
+					return &ValidationError{Field: path(stack), Reason: fmt.Sprintf("has a key longer than %d characters", l.MaxStringLength)}
+				}
+				top.key, top.expectKey = key, false
+				continue
+			}
+		}
+		// a value - count it in its array
+		if delim, ok := tok.(json.Delim); !ok || delim == '{' || delim == '[' {
+			if top != nil && top.array {
+				top.count++
+				if top.count > l.MaxItems {
+					return &ValidationError{Field: path(stack[:len(stack)-1]), Reason: fmt.Sprintf("must have at most %d elements", l.MaxItems)}
+				}
+			}
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			stack = append(stack, &frame{array: tok == json.Delim('['), expectKey: true})
+			if len(stack) > l.MaxDepth {
+				return &ValidationError{Field: path(stack[:len(stack)-1]), Reason: fmt.Sprintf("is nested more than %d levels deep", l.MaxDepth)}
+			}
+			continue
+		case json.Delim('}'), json.Delim(']'):
+			stack = stack[:len(stack)-1]
+		default:
+			if s, ok := tok.(string); ok && utf8.RuneCountInString(s) > l.MaxStringLength {
+				return &ValidationError{Field: path(stack), Reason: fmt.Sprintf("must be at most %d characters", l.MaxStringLength)}
+			}
+		}
+		// the value is done - an object reads a key next
+		if len(stack) > 0 && !stack[len(stack)-1].array {
+			stack[len(stack)-1].expectKey = true
+		}
+	}
+}
+
+// Check a receipt against the item count and string length limits
+func (l *RequestLimits) checkReceipt(r Receipt) *ValidationError {
+	if utf8.RuneCountInString(r.Retailer) > l.MaxStringLength {
+		return &ValidationError{Field: "retailer", Reason: fmt.Sprintf("must be at most %d characters", l.MaxStringLength)}
+	}
+	if len(r.Items) > l.MaxItems {
+		return &ValidationError{Field: "items", Reason: fmt.Sprintf("must have at most %d items", l.MaxItems)}
+	}
+	for i, item := range r.Items {
+		if utf8.RuneCountInString(item.ShortDescription) > l.MaxStringLength {
+			return &ValidationError{Field: fmt.Sprintf("items[%d].shortDescription", i), Reason: fmt.Sprintf("must be at most %d characters", l.MaxStringLength)}
+		}
+	}
+	return nil
+}
+
+// Bind a JSON request body, checking it against the limits first
+// Fails with errBodyTooLarge if the body is over the size limit, or a *limitError if it breaks another limit
+func bindJSON(c *gin.Context, v interface{}) (err error) {
+	_, span := startSpan(c.Request.Context(), "bind")
+	defer func() {
+		if err != nil {
+			spanError(span, err)
+		}
+		span.End()
+	}()
+	data, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return err
+	}
+	if invalid := requestLimits.checkJSON(data); invalid != nil {
+		return &limitError{*invalid}
+	}
+	return binding.JSON.BindBody(data, v)
+}
+
+// Check if the request body was cut off at the size limit
+func bodyTooLarge(c *gin.Context) bool {
+	body, present := c.Get(limitedBodyKey)
+	return present && body.(*limitedBody).exceeded
+}
+
+// Respond that the request body is too large
+func tooLarge(c *gin.Context) {
+	description := "The request body is too large"
+	// the v2 routes keep their structured errors
+	if strings.HasPrefix(c.FullPath(), "/v2/") {
+		respondErrorV2(c, http.StatusRequestEntityTooLarge, ErrCodeTooLarge, description)
+		return
+	}
+	c.JSON(http.StatusRequestEntityTooLarge, gin.H{"description": description})
+}
+
+// Middleware limiting the size of request bodies
+// Bodies declaring a length over the limit are turned away before they are read; others are cut off at the limit
+func limitBody() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := requestLimits.bodyLimit(c.FullPath())
+		if c.Request.ContentLength > limit {
+			tooLarge(c)
+			c.Abort()
+			return
+		}
+		if c.Request.Body != nil {
+			body := &limitedBody{ReadCloser: c.Request.Body, remaining: limit}
+			c.Request.Body = body
+			c.Set(limitedBodyKey, body)
+		}
+		c.Next()
+	}
+}
+
+// Configure the request limits from the environment
+// MAX_BODY_BYTES, MAX_ITEMS, MAX_STRING_LENGTH and MAX_JSON_DEPTH override the defaults
+func requestLimitsFromEnv() (*RequestLimits, error) {
+	l := NewRequestLimits()
+	settings := []struct {
+		name  string
+		value func(int)
+	}{
+		{"MAX_BODY_BYTES", func(n int) { l.MaxBodyBytes = int64(n) }},
+		{"MAX_ITEMS", func(n int) { l.MaxItems = n }},
+		{"MAX_STRING_LENGTH", func(n int) { l.MaxStringLength = n }},
+		{"MAX_JSON_DEPTH", func(n int) { l.MaxDepth = n }},
+	}
+	for _, setting := range settings {
+		raw := os.Getenv(setting.name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid %s %q - needs a positive number", setting.name, raw)
+		}
+		setting.value(n)
+	}
+	return l, nil
+}
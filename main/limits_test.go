@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// apply request limits for the duration of a test
+func withRequestLimits(t *testing.T, l *RequestLimits) {
+	previous := requestLimits
+	requestLimits = l
+	t.Cleanup(func() { requestLimits = previous })
+}
+
+// perform a request whose body has no declared length, as when it is sent chunked
+func doChunkedRequest(t *testing.T, method string, path string, body []byte) *httptest.ResponseRecorder {
+	router := setupRouter()
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest(method, path, io.MultiReader(bytes.NewReader(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.ContentLength = -1
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// a v2 receipt with the given items
+func receiptWithItems(items ...string) []byte {
+	return []byte(`{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "total": 1.25, "items": [` + strings.Join(items, ", ") + `]}`)
+}
+
+func TestLimits_Body_Size(t *testing.T) {
+	withRequestLimits(t, &RequestLimits{MaxBodyBytes: int64(len(body_valid_2)), MaxItems: 1000, MaxStringLength: 1000, MaxDepth: 20})
+	withReceipts(t)
+
+	// exactly at the limit is fine
+	assert.Equal(t, http.StatusOK, doRequest(t, http.MethodPost, "/receipts/process", body_valid_2).Code)
+	assert.Equal(t, http.StatusOK, doChunkedRequest(t, http.MethodPost, "/receipts/process", body_valid_2).Code)
+
+	// turned away on its declared length, before it is read
+	over := append(append([]byte{}, body_valid_2...), ' ')
+	w := doRequest(t, http.MethodPost, "/receipts/process", over)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.JSONEq(t, `{"description": "The request body is too large"}`, w.Body.String())
+
+	// or cut off while it is read, when it has no declared length
+	for _, path := range []string{"/receipts/process", "/receipts/process/text", "/merchants", "/webhooks", "/graphql"} {
+		w = doChunkedRequest(t, http.MethodPost, path, over)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code, path)
+	}
+
+	// v2 keeps its structured errors
+	w = doRequest(t, http.MethodPost, "/v2/receipts/process", append(append([]byte{}, body_valid_2...), body_valid_2...))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, ErrCodeTooLarge, decodeErrorV2(t, w.Body.Bytes()).Code)
+	w = doChunkedRequest(t, http.MethodPost, "/v2/receipts/process", append(append([]byte{}, body_valid_2...), body_valid_2...))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, ErrCodeTooLarge, decodeErrorV2(t, w.Body.Bytes()).Code)
+}
+
+func TestLimits_Image_Upload_Size(t *testing.T) {
+	withRequestLimits(t, &RequestLimits{MaxBodyBytes: 1024, MaxItems: 1000, MaxStringLength: 1000, MaxDepth: 20})
+	withFakeOCR(t)
+
+	// image uploads get room for the image on top of the body limit
+	assert.Equal(t, int64(maxImageSize+multipartOverhead), requestLimits.bodyLimit("/receipts/process/image"))
+	assert.Equal(t, int64(1024), requestLimits.bodyLimit("/receipts/process"))
+
+	body, contentType := imageUpload(t, bytes.Repeat([]byte{0}, maxImageSize+multipartOverhead))
+	router := setupRouter()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/receipts/process/image", io.MultiReader(bytes.NewReader(body)))
+	req.ContentLength = -1
+	req.Header.Set("Content-Type", contentType)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestLimits_Item_Count(t *testing.T) {
+	withRequestLimits(t, &RequestLimits{MaxBodyBytes: 1 << 20, MaxItems: 3, MaxStringLength: 1000, MaxDepth: 20})
+	withReceipts(t)
+	item := `{"description": "Pepsi", "price": 1.25}`
+
+	w := doRequest(t, http.MethodPost, "/v2/receipts/process", receiptWithItems(item, item, item, item))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, APIError{
+		Code:    ErrCodeLimitExceeded,
+		Message: "The request body exceeds a limit",
+		Details: []ValidationError{{Field: "items", Reason: "must have at most 3 elements"}},
+	}, decodeErrorV2(t, w.Body.Bytes()))
+	assert.Equal(t, http.StatusBadRequest, doRequest(t, http.MethodPost, "/receipts/process", body_valid_2).Code)
+
+	// receipts read from text are held to the same limit once parsed
+	code, _ := postTextReceipt(t, "/receipts/process/text", text_valid_2)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, &ValidationError{Field: "items", Reason: "must have at most 3 items"}, checkReceipt(receipt_valid_2()))
+}
+
+func TestLimits_String_Length(t *testing.T) {
+	withRequestLimits(t, &RequestLimits{MaxBodyBytes: 1 << 20, MaxItems: 1000, MaxStringLength: 20, MaxDepth: 20})
+	withReceipts(t)
+
+	long := strings.Repeat("é", 21)
+	w := doRequest(t, http.MethodPost, "/v2/receipts/process", receiptWithItems(`{"description": "Pepsi", "price": 0.25}`, `{"description": "`+long+`", "price": 1}`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, []ValidationError{{Field: "items[1].description", Reason: "must be at most 20 characters"}}, decodeErrorV2(t, w.Body.Bytes()).Details)
+
+	// 20 characters is fine, though it is 40 bytes
+	w = doRequest(t, http.MethodPost, "/v2/receipts/process", receiptWithItems(`{"description": "`+long[2:]+`", "price": 1.25}`))
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// keys are limited too
+	w = doRequest(t, http.MethodPost, "/v2/receipts/process", []byte(`{"`+long+`": 1}`))
+	assert.Equal(t, ErrCodeLimitExceeded, decodeErrorV2(t, w.Body.Bytes()).Code)
+
+	r := receipt_valid_2()
+	r.Retailer = long
+	assert.Equal(t, &ValidationError{Field: "retailer", Reason: "must be at most 20 characters"}, checkReceipt(r))
+}
+
+func TestLimits_Nesting_Depth(t *testing.T) {
+	withRequestLimits(t, &RequestLimits{MaxBodyBytes: 1 << 20, MaxItems: 1000, MaxStringLength: 1000, MaxDepth: 4})
+	withReceipts(t)
+
+	// a receipt nests three deep
+	assert.Equal(t, http.StatusCreated, doRequest(t, http.MethodPost, "/v2/receipts/process", body_v2_valid_2).Code)
+
+	w := doRequest(t, http.MethodPost, "/v2/receipts/process", []byte(`{"retailer": [{"name": [[1]]}]}`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, []ValidationError{{Field: "retailer[0].name[0]", Reason: "is nested more than 4 levels deep"}}, decodeErrorV2(t, w.Body.Bytes()).Details)
+	w = doRequest(t, http.MethodPost, "/graphql", []byte(`{"query": "{ receipts { id } }", "variables": {"a": {"b": {"c": {"d": 1}}}}}`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestCheckJSON(t *testing.T) {
+	l := &RequestLimits{MaxItems: 2, MaxStringLength: 3, MaxDepth: 3}
+	valid := []string{
+		`{}`, `[]`, `"abc"`, `[1, 2]`, `{"a": {"b": [1]}}`, `{"abc": "def", "x": [{}, {}]}`,
+		// syntax errors are left for binding
+		`{"a": `, `not json`,
+	}
+	for _, doc := range valid {
+		assert.Nil(t, l.checkJSON([]byte(doc)), doc)
+	}
+	invalid := map[string]ValidationError{
+		`[1, 2, 3]`:               {Field: "", Reason: "must have at most 2 elements"},
+		`{"a": [{}, [1, 2, 3]]}`:  {Field: "a[1]", Reason: "must have at most 2 elements"},
+		`{"a": {"b": "abcd"}}`:    {Field: "a.b", Reason: "must be at most 3 characters"},
+		`{"a": ["x", "abcd"]}`:    {Field: "a[1]", Reason: "must be at most 3 characters"},
+		`{"a": {"abcd": 1}}`:      {Field: "a", Reason: "has a key longer than 3 characters"},
+		`{"a": {"b": {"c": {}}}}`: {Field: "a.b.c", Reason: "is nested more than 3 levels deep"},
+		`[[[[]]]]`:                {Field: "[0][0][0]", Reason: "is nested more than 3 levels deep"},
+	}
+	for doc, expected := range invalid {
+		assert.Equal(t, &expected, l.checkJSON([]byte(doc)), doc)
+	}
+}
+
+func TestRequestLimitsFromEnv(t *testing.T) {
+	t.Setenv("MAX_BODY_BYTES", "2048")
+	t.Setenv("MAX_ITEMS", "50")
+	l, err := requestLimitsFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, &RequestLimits{MaxBodyBytes: 2048, MaxItems: 50, MaxStringLength: 1000, MaxDepth: 20}, l)
+
+	t.Setenv("MAX_JSON_DEPTH", "0")
+	_, err = requestLimitsFromEnv()
+	assert.Error(t, err)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Struct definitions & constructors
+
+// Struct representing the service's logger and what it may write
+type Logging struct {
+	logger zerolog.Logger
+	// replace personal data - values quoted from receipts, end users' IDs and client IPs - with a placeholder
+	redactPII bool
+}
+
+// Constructor for Logging - JSON lines at info level and above, with personal data redacted
+func NewLogging(out io.Writer) *Logging {
+	return &Logging{logger: zerolog.New(out).Level(zerolog.InfoLevel).With().Timestamp().Logger(), redactPII: true}
+}
+
+// Internal data
+
+// Global logging object
+var logging = NewLogging(os.Stdout) // pointer to Logging object
+
+// Header carrying the request's ID - taken from the caller if valid, otherwise generated
+const requestIDHeader = "X-Request-ID"
+
+// Request IDs accepted from callers - anything else is replaced, so IDs cannot inject into logs
+var requestIDRegex = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// Placeholder for redacted personal data
+const redacted = "[REDACTED]"
+
+// context key for the request's ID
+type requestIDKey struct{}
+
+// Internal functions - not exported
+
+// Attach the request's ID to a context
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// The request ID attached to a context, or an empty string
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// A logger for the work done under ctx - tagged with its request ID, trace ID and caller, if any
+func (l *Logging) from(ctx context.Context) *zerolog.Logger {
+	with := l.logger.With()
+	if id := requestIDFrom(ctx); id != "" {
+		with = with.Str("request_id", id)
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		with = with.Str("trace_id", sc.TraceID().String())
+	}
+	if p := principalFrom(ctx); p != nil {
+		if p.ClientID != "" {
+			with = with.Str("client_id", p.ClientID)
+		}
+		if p.UserID != "" {
+			with = with.Str("user_id", l.redact(p.UserID))
+		}
+	}
+	logger := with.Logger()
+	return &logger
+}
+
+// Redact a piece of personal data, if redaction is on
+func (l *Logging) redact(value string) string {
+	if l.redactPII {
+		return redacted
+	}
+	return value
+}
+
+// Redact the values a validation reason quotes from the receipt, like invalid amount "-1.00", if redaction is on
+func (l *Logging) redactReason(reason string) string {
+	if l.redactPII {
+		return quotedValueRegex.ReplaceAllString(reason, ` "`+redacted+`"`)
+	}
+	return reason
+}
+
+// Log a rejected receipt with why it was rejected
+func (l *Logging) logRejection(ctx context.Context, rp ReceiptPoints) {
+	l.from(ctx).Info().
+		Str("field", rp.Rejection.Field).
+		Str("reason", l.redactReason(rp.Rejection.Reason)).
+		Int("items", len(rp.Receipt.Items)).
+		Msg("receipt rejected")
+}
+
+// Middleware giving every request an ID - the caller's X-Request-ID if valid, otherwise a new one
+// The ID is returned in the X-Request-ID response header and tags the request's log lines
+func assignRequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !requestIDRegex.MatchString(id) {
+			id = uuid.New().String()
+		}
+		c.Header(requestIDHeader, id)
+		c.Request = c.Request.WithContext(withRequestID(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// Middleware writing an access log line for every request, in place of gin's text logger
+// Server errors are logged at error level, client errors at warn level and the rest at info level
+func logRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		// the request's context now carries its caller, once authenticated
+		l := logging
+		logger := l.from(c.Request.Context())
+		status := c.Writer.Status()
+		event := logger.Info()
+		switch {
+		case status >= http.StatusInternalServerError:
+			event = logger.Error()
+		case status >= http.StatusBadRequest:
+			event = logger.Warn()
+		}
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		event = event.
+			Str("method", c.Request.Method).
+			Str("route", route).
+			Str("path", c.Request.URL.Path).
+			Int("status", status).
+			Float64("latency_ms", float64(time.Since(start).Microseconds())/1000).
+			Int("bytes", c.Writer.Size()).
+			Str("client_ip", l.redact(c.ClientIP()))
+		if errs := c.Errors.ByType(gin.ErrorTypePrivate); len(errs) > 0 {
+			event = event.Str("error", errs.String())
+		}
+		event.Msg("request")
+	}
+}
+
+// Middleware recovering from panics in handlers - logs the panic and its stack, and responds 500
+func recoverPanic() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, err interface{}) {
+		logging.from(c.Request.Context()).Error().
+			Str("panic", fmt.Sprint(err)).
+			Str("stack", string(debug.Stack())).
+			Msg("handler panicked")
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
+
+// Configure logging from the environment
+// LOG_LEVEL sets the lowest level written (debug, info, warn or error), LOG_FORMAT chooses json or text lines,
+// and LOG_REDACT_PII=false turns off redaction of personal data
+func loggingFromEnv(out io.Writer) (*Logging, error) {
+	l := NewLogging(out)
+	if raw := os.Getenv("LOG_LEVEL"); raw != "" {
+		level, err := zerolog.ParseLevel(strings.ToLower(raw))
+		if err != nil || level < zerolog.DebugLevel || level > zerolog.ErrorLevel {
+			return nil, fmt.Errorf("invalid LOG_LEVEL %q - expected debug, info, warn or error", raw)
+		}
+		l.logger = l.logger.Level(level)
+	}
+	switch format := strings.ToLower(os.Getenv("LOG_FORMAT")); format {
+	case "", "json":
+	case "text":
+		l.logger = l.logger.Output(zerolog.ConsoleWriter{Out: out, NoColor: true, TimeFormat: time.RFC3339})
+	default:
+		return nil, fmt.Errorf("invalid LOG_FORMAT %q - expected json or text", format)
+	}
+	if raw := os.Getenv("LOG_REDACT_PII"); raw != "" {
+		redact, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LOG_REDACT_PII %q - expected true or false", raw)
+		}
+		l.redactPII = redact
+	}
+	return l, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// capture log lines for the duration of a test
+func withLogging(t *testing.T, redactPII bool) *bytes.Buffer {
+	var out bytes.Buffer
+	l := NewLogging(&out)
+	l.redactPII = redactPII
+	previous := logging
+	logging = l
+	t.Cleanup(func() { logging = previous })
+	return &out
+}
+
+// decode the captured log lines with the given message
+func logLines(t *testing.T, out *bytes.Buffer, message string) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, raw := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if raw == "" {
+			continue
+		}
+		var line map[string]interface{}
+		if err := json.Unmarshal([]byte(raw), &line); err != nil {
+			t.Fatalf("log line is not JSON: %s", raw)
+		}
+		if line["message"] == message {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// perform a request with an X-Request-ID header
+func doRequestWithID(t *testing.T, method string, path string, body []byte, requestID string) *httptest.ResponseRecorder {
+	router := setupRouter()
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest(method, path, bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(requestIDHeader, requestID)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestLogging_Request_ID(t *testing.T) {
+	out := withLogging(t, true)
+	withReceipts(t)
+
+	// the caller's ID is kept
+	w := doRequestWithID(t, http.MethodPost, "/receipts/process", body_valid_2, "checkout-7f3a:1")
+	assert.Equal(t, "checkout-7f3a:1", w.Header().Get(requestIDHeader))
+	lines := logLines(t, out, "request")
+	if assert.Len(t, lines, 1) {
+		assert.Equal(t, "checkout-7f3a:1", lines[0]["request_id"])
+	}
+
+	// one is made up when there is none, or it could inject into the logs
+	for _, id := range []string{"", "line\nbreak", strings.Repeat("a", 129)} {
+		w = doRequestWithID(t, http.MethodGet, "/receipts/unknown/points", nil, id)
+		generated := w.Header().Get(requestIDHeader)
+		assert.Len(t, generated, 36, id)
+		assert.NotEqual(t, id, generated)
+	}
+}
+
+func TestLogging_Access_Log(t *testing.T) {
+	out := withLogging(t, true)
+	withAPIKeys(t)
+	withReceipts(t)
+
+	doKeyRequest(t, http.MethodPost, "/receipts/process", body_valid_2, "key-a")
+	doRequest(t, http.MethodGet, "/no/such/route", nil)
+	lines := logLines(t, out, "request")
+	if !assert.Len(t, lines, 2) {
+		return
+	}
+	assert.Equal(t, "info", lines[0]["level"])
+	assert.Equal(t, "POST", lines[0]["method"])
+	assert.Equal(t, "/receipts/process", lines[0]["route"])
+	assert.Equal(t, 200.0, lines[0]["status"])
+	assert.Equal(t, "partner-a", lines[0]["client_id"])
+	assert.Equal(t, redacted, lines[0]["client_ip"])
+	assert.Contains(t, lines[0], "latency_ms")
+	assert.Contains(t, lines[0], "time")
+	assert.Equal(t, "warn", lines[1]["level"])
+	assert.Equal(t, "unmatched", lines[1]["route"])
+	assert.Equal(t, "/no/such/route", lines[1]["path"])
+}
+
+func TestLogging_Rejected_Receipt(t *testing.T) {
+	r := receipt_valid_2()
+	r.Items[1].Price = "-1.00"
+	ctx := withPrincipal(withRequestID(context.Background(), "req-1"), &Principal{ClientID: "partner", UserID: "user-42"})
+
+	// personal data is redacted by default
+	out := withLogging(t, true)
+	evaluateReceipt(ctx, r)
+	lines := logLines(t, out, "receipt rejected")
+	if assert.Len(t, lines, 1) {
+		assert.Equal(t, map[string]interface{}{
+			"level":      "info",
+			"message":    "receipt rejected",
+			"time":       lines[0]["time"],
+			"request_id": "req-1",
+			"client_id":  "partner",
+			"user_id":    redacted,
+			"field":      "items[1].price",
+			"reason":     `invalid amount "[REDACTED]"`,
+			"items":      float64(len(r.Items)),
+		}, lines[0])
+	}
+
+	// and logged as is once redaction is off
+	out = withLogging(t, false)
+	evaluateReceipt(ctx, r)
+	lines = logLines(t, out, "receipt rejected")
+	if assert.Len(t, lines, 1) {
+		assert.Equal(t, "user-42", lines[0]["user_id"])
+		assert.Equal(t, `invalid amount "-1.00"`, lines[0]["reason"])
+	}
+
+	// valid receipts are not logged
+	evaluateReceipt(ctx, receipt_valid_2())
+	assert.Len(t, logLines(t, out, "receipt rejected"), 1)
+}
+
+func TestLogging_Queued_Rejection(t *testing.T) {
+	out := withLogging(t, true)
+	withReceipts(t)
+	q := withQueue(t, 1, 0)
+
+	doRequestWithID(t, http.MethodPost, "/receipts/process?async=true", body_bad_negative_total, "req-async")
+	q.process(<-q.jobs)
+	lines := logLines(t, out, "receipt rejected")
+	if assert.Len(t, lines, 1) {
+		assert.Equal(t, "req-async", lines[0]["request_id"])
+		assert.Equal(t, "total", lines[0]["field"])
+	}
+}
+
+func TestLogging_Panic(t *testing.T) {
+	out := withLogging(t, true)
+	router := setupRouter()
+	router.GET("/panic", func(c *gin.Context) { panic("boom") })
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/panic", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	panics := logLines(t, out, "handler panicked")
+	if assert.Len(t, panics, 1) {
+		assert.Equal(t, "boom", panics[0]["panic"])
+		assert.Contains(t, panics[0]["stack"], "logging_test.go")
+	}
+	requests := logLines(t, out, "request")
+	if assert.Len(t, requests, 1) {
+		assert.Equal(t, "error", requests[0]["level"])
+		assert.Equal(t, panics[0]["request_id"], requests[0]["request_id"])
+	}
+}
+
+func TestLoggingFromEnv(t *testing.T) {
+	var out bytes.Buffer
+	t.Setenv("LOG_LEVEL", "warn")
+	t.Setenv("LOG_REDACT_PII", "false")
+	l, err := loggingFromEnv(&out)
+	assert.NoError(t, err)
+	assert.False(t, l.redactPII)
+	l.logger.Info().Msg("dropped")
+	l.logger.Warn().Msg("kept")
+	assert.NotContains(t, out.String(), "dropped")
+	assert.Contains(t, out.String(), `"message":"kept"`)
+
+	out.Reset()
+	t.Setenv("LOG_FORMAT", "text")
+	l, err = loggingFromEnv(&out)
+	assert.NoError(t, err)
+	l.logger.Warn().Str("field", "total").Msg("kept")
+	assert.Contains(t, out.String(), "WRN kept field=total")
+
+	for name, value := range map[string]string{"LOG_LEVEL": "trace", "LOG_FORMAT": "xml", "LOG_REDACT_PII": "sometimes"} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			_, err := loggingFromEnv(&out)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Struct definitions & constructors
+
+// Struct representing a canonical merchant - every retailer string that maps to it shares its ID
+type Merchant struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases"`
+	// optional time zone of the merchant's stores - used for receipts that do not carry their own
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// Struct representing the merchant catalog - canonical merchants plus a normalized alias index
+type MerchantCatalog struct {
+	mu sync.RWMutex
+	// store a map of merchants accessed via ID
+	MerchantsMap map[string]*Merchant `json:"merchants"`
+	// store a map of normalized alias -> merchant ID, used for exact lookups
+	aliasIndex map[string]string
+	// the same normalized aliases grouped by length in runes, so fuzzy matching only compares aliases long enough to match
+	aliasesByLength map[int]map[string]struct{}
+	// minimum similarity (0-1) for a fuzzy match to be accepted
+	threshold float64
+	// bumped on every change - stored on each receipt, so its resolution can be traced back to the catalog that made it
+	version int
+	// fuzzy matches already made, by normalized retailer - entries from an older version are stale
+	matchMu sync.Mutex
+	matches map[string]fuzzyMatch
+}
+
+// Struct representing a cached fuzzy match - an empty ID if nothing matched
+type fuzzyMatch struct {
+	id      string
+	version int
+}
+
+// Constructor for MerchantCatalog
+func NewMerchantCatalog() *MerchantCatalog {
+	var mc MerchantCatalog
+	mc.MerchantsMap = make(map[string]*Merchant)
+	mc.aliasIndex = make(map[string]string)
+	mc.aliasesByLength = make(map[int]map[string]struct{})
+	mc.threshold = 0.85
+	mc.matches = make(map[string]fuzzyMatch)
+	return &mc
+}
+
+// Internal data
+
+// Global merchant catalog - in place of persisting data
+var merchants = NewMerchantCatalog() // pointer to MerchantCatalog object
+
+// Most fuzzy matches cached - the cache starts over once full
+const maxCachedMatches = 10000
+
+// common receipt abbreviations, expanded during normalization so "MKT" and "Market" match
+// short ones that are also common words or names, like "st" (street, saint) and "co", are left out
+var merchantAbbreviations = map[string]string{
+	"mkt":  "market",
+	"mkts": "markets",
+	"str":  "store",
+	"ctr":  "center",
+	"sq":   "square",
+	"intl": "international",
+}
+
+// Internal functions - not exported
+
+// Normalize a retailer string - lowercase, drop punctuation, expand abbreviations, remove whitespace
+// "M&M Corner Market", "M & M CORNER MKT" and "m&m corner market " all normalize to "mmcornermarket"
+// Letters and digits in any script are kept, so "Café Müller" normalizes to "cafémüller"
+func normalizeRetailer(retailer string) string {
+	tokens := strings.FieldsFunc(strings.ToLower(retailer), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	})
+	for i, token := range tokens {
+		if expanded, ok := merchantAbbreviations[token]; ok {
+			tokens[i] = expanded
+		}
+	}
+	return strings.Join(tokens, "")
+}
+
+// Levenshtein edit distance between two strings, counted in runes
+func editDistance(sa, sb string) int {
+	a, b := []rune(sa), []rune(sb)
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, minInt(curr[j-1]+1, prev[j-1]+cost))
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// Similarity between two normalized strings - 1 is identical, 0 is nothing in common
+func similarity(a, b string) float64 {
+	longest := utf8.RuneCountInString(a)
+	if n := utf8.RuneCountInString(b); n > longest {
+		longest = n
+	}
+	if longest == 0 {
+		return 0
+	}
+	return 1 - float64(editDistance(a, b))/float64(longest)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Add a merchant to the catalog - its name and aliases are indexed for lookup
+// Returns false if the ID is taken or any alias already belongs to another merchant
+func (mc *MerchantCatalog) add(m Merchant) bool {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if _, present := mc.MerchantsMap[m.ID]; present {
+		return false
+	}
+	keys := append([]string{m.Name}, m.Aliases...)
+	for _, key := range keys {
+		if owner, taken := mc.aliasIndex[normalizeRetailer(key)]; taken && owner != m.ID {
+			return false
+		}
+	}
+	stored := Merchant{ID: m.ID, Name: m.Name, Aliases: []string{}, TimeZone: m.TimeZone}
+	mc.MerchantsMap[m.ID] = &stored
+	mc.indexLocked(normalizeRetailer(m.Name), m.ID)
+	for _, alias := range m.Aliases {
+		mc.addAliasLocked(&stored, alias)
+	}
+	mc.version++
+	return true
+}
+
+// Index a normalized alias under a merchant ID - caller must hold the write lock
+func (mc *MerchantCatalog) indexLocked(key string, id string) {
+	mc.aliasIndex[key] = id
+	length := utf8.RuneCountInString(key)
+	if mc.aliasesByLength[length] == nil {
+		mc.aliasesByLength[length] = make(map[string]struct{})
+	}
+	mc.aliasesByLength[length][key] = struct{}{}
+}
+
+// Drop a normalized alias from the index - caller must hold the write lock
+func (mc *MerchantCatalog) unindexLocked(key string) {
+	delete(mc.aliasIndex, key)
+	delete(mc.aliasesByLength[utf8.RuneCountInString(key)], key)
+}
+
+// Add an alias to an existing merchant - caller must hold the write lock
+func (mc *MerchantCatalog) addAliasLocked(m *Merchant, alias string) {
+	key := normalizeRetailer(alias)
+	if _, taken := mc.aliasIndex[key]; !taken || mc.aliasIndex[key] == m.ID {
+		mc.indexLocked(key, m.ID)
+	}
+	for _, existing := range m.Aliases {
+		if existing == alias {
+			return
+		}
+	}
+	m.Aliases = append(m.Aliases, alias)
+}
+
+// Add an alias to the merchant with the given ID
+// Returns false if the merchant does not exist or the alias already belongs to another merchant
+func (mc *MerchantCatalog) addAlias(id string, alias string) bool {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	m, present := mc.MerchantsMap[id]
+	if !present {
+		return false
+	}
+	if owner, taken := mc.aliasIndex[normalizeRetailer(alias)]; taken && owner != id {
+		return false
+	}
+	mc.addAliasLocked(m, alias)
+	mc.version++
+	return true
+}
+
+// Remove an alias from the merchant with the given ID - the merchant's own name cannot be removed
+// Returns false if the merchant or alias does not exist
+func (mc *MerchantCatalog) removeAlias(id string, alias string) bool {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	m, present := mc.MerchantsMap[id]
+	if !present {
+		return false
+	}
+	for i, existing := range m.Aliases {
+		if existing == alias {
+			m.Aliases = append(m.Aliases[:i], m.Aliases[i+1:]...)
+			// only drop the index entry if no other spelling of this merchant normalizes the same way
+			key := normalizeRetailer(alias)
+			stillUsed := normalizeRetailer(m.Name) == key
+			for _, other := range m.Aliases {
+				if normalizeRetailer(other) == key {
+					stillUsed = true
+				}
+			}
+			if !stillUsed {
+				mc.unindexLocked(key)
+			}
+			mc.version++
+			return true
+		}
+	}
+	return false
+}
+
+// Get a copy of the merchant with the given ID
+func (mc *MerchantCatalog) get(id string) (Merchant, bool) {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	m, present := mc.MerchantsMap[id]
+	if !present {
+		return Merchant{}, false
+	}
+	return Merchant{ID: m.ID, Name: m.Name, Aliases: append([]string{}, m.Aliases...), TimeZone: m.TimeZone}, true
+}
+
+// List copies of all merchants, ordered by ID, along with the catalog version they were listed at
+func (mc *MerchantCatalog) list() ([]Merchant, int) {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	list := make([]Merchant, 0, len(mc.MerchantsMap))
+	for _, m := range mc.MerchantsMap {
+		list = append(list, Merchant{ID: m.ID, Name: m.Name, Aliases: append([]string{}, m.Aliases...), TimeZone: m.TimeZone})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, mc.version
+}
+
+// Resolve a retailer string to a canonical merchant, along with the catalog version that resolved it
+// Tries an exact lookup on the normalized string first, then falls back to the closest fuzzy match above the threshold
+func (mc *MerchantCatalog) resolve(retailer string) (Merchant, int, bool) {
+	key := normalizeRetailer(retailer)
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	if key == "" {
+		return Merchant{}, mc.version, false
+	}
+	id, present := mc.aliasIndex[key]
+	if !present {
+		id, present = mc.fuzzyMatchLocked(key)
+	}
+	if !present {
+		return Merchant{}, mc.version, false
+	}
+	m := mc.MerchantsMap[id]
+	return Merchant{ID: m.ID, Name: m.Name, Aliases: append([]string{}, m.Aliases...), TimeZone: m.TimeZone}, mc.version, true
+}
+
+// Find the closest alias to a normalized retailer, if any is above the threshold - caller must hold the read lock
+// The result is cached until the catalog changes, so a retailer spelled the same way is only matched once
+func (mc *MerchantCatalog) fuzzyMatchLocked(key string) (string, bool) {
+	mc.matchMu.Lock()
+	cached, hit := mc.matches[key]
+	mc.matchMu.Unlock()
+	if hit && cached.version == mc.version {
+		return cached.id, cached.id != ""
+	}
+
+	// similarity is at most the ratio of the shorter length to the longer, so only aliases within the threshold's lengths can match
+	length := float64(utf8.RuneCountInString(key))
+	shortest := int(math.Ceil(length*mc.threshold - 1e-9))
+	longest := int(math.Floor(length/mc.threshold + 1e-9))
+	id, best := "", 0.0
+	for n := shortest; n <= longest; n++ {
+		for alias := range mc.aliasesByLength[n] {
+			owner := mc.aliasIndex[alias]
+			score := similarity(key, alias)
+			// ties are broken on merchant ID so resolution does not depend on map ordering
+			if score >= mc.threshold && (score > best || (score == best && owner < id)) {
+				best, id = score, owner
+			}
+		}
+	}
+
+	mc.matchMu.Lock()
+	if len(mc.matches) >= maxCachedMatches {
+		mc.matches = make(map[string]fuzzyMatch)
+	}
+	mc.matches[key] = fuzzyMatch{id: id, version: mc.version}
+	mc.matchMu.Unlock()
+	return id, id != ""
+}
+
+// Internal Route Functions
+
+// Path: /merchants
+// Method: GET
+// Response: JSON array of all merchants in the catalog, and the catalog's version.
+// Description: Lists the canonical merchants and their aliases.
+func listMerchants(c *gin.Context) {
+	list, version := merchants.list()
+	c.JSON(http.StatusOK, gin.H{"merchants": list, "version": version})
+}
+
+// Path: /merchants
+// Method: POST
+// Payload: Merchant JSON
+// Response: The created merchant.
+// Description: Adds a canonical merchant to the catalog, along with any initial aliases.
+func createMerchant(c *gin.Context) {
+	var m Merchant
+	err := bindJSON(c, &m)
+	if errors.Is(err, errBodyTooLarge) {
+		tooLarge(c)
+		return
+	}
+	if err != nil || m.ID == "" || normalizeRetailer(m.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"description": "The merchant is invalid"})
+		return
+	}
+	for _, alias := range m.Aliases {
+		if normalizeRetailer(alias) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"description": "The merchant is invalid"})
+			return
+		}
+	}
+	if m.TimeZone != "" {
+		if _, err := parseTimeZone(m.TimeZone); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"description": "The merchant is invalid"})
+			return
+		}
+	}
+	if !merchants.add(m) {
+		c.JSON(http.StatusConflict, gin.H{"description": "The merchant ID or one of its aliases is already in use"})
+		return
+	}
+	created, _ := merchants.get(m.ID)
+	c.JSON(http.StatusCreated, created)
+}
+
+// Path: /merchants/{id}
+// Method: GET
+// Response: The merchant with the given ID.
+func getMerchant(c *gin.Context) {
+	m, present := merchants.get(c.Param("id"))
+	if !present {
+		c.JSON(http.StatusNotFound, gin.H{"description": "No merchant found for that id"})
+		return
+	}
+	c.JSON(http.StatusOK, m)
+}
+
+// Path: /merchants/{id}/aliases
+// Method: POST
+// Payload: JSON object with an alias field
+// Response: The updated merchant.
+// Description: Adds an alias that will resolve to the merchant on future receipts.
+func addMerchantAlias(c *gin.Context) {
+	var body struct {
+		Alias string `json:"alias"`
+	}
+	err := bindJSON(c, &body)
+	if errors.Is(err, errBodyTooLarge) {
+		tooLarge(c)
+		return
+	}
+	if err != nil || normalizeRetailer(body.Alias) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"description": "The alias is invalid"})
+		return
+	}
+	id := c.Param("id")
+	if _, present := merchants.get(id); !present {
+		c.JSON(http.StatusNotFound, gin.H{"description": "No merchant found for that id"})
+		return
+	}
+	if !merchants.addAlias(id, body.Alias) {
+		c.JSON(http.StatusConflict, gin.H{"description": "The alias already belongs to another merchant"})
+		return
+	}
+	m, _ := merchants.get(id)
+	c.JSON(http.StatusOK, m)
+}
+
+// Path: /merchants/{id}/aliases/{alias}
+// Method: DELETE
+// Response: The updated merchant.
+// Description: Removes an alias from the merchant. Receipts already processed keep their merchant ID.
+func removeMerchantAlias(c *gin.Context) {
+	id := c.Param("id")
+	if !merchants.removeAlias(id, c.Param("alias")) {
+		c.JSON(http.StatusNotFound, gin.H{"description": "No alias found for that merchant"})
+		return
+	}
+	m, _ := merchants.get(id)
+	c.JSON(http.StatusOK, m)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var body_merchant_mm = []byte(`{
+	"id": "mm-corner-market",
+	"name": "M&M Corner Market",
+	"aliases": ["M and M Corner Mart"]
+}`)
+
+// same receipt as body_valid_2, but with a differently spelled retailer
+var body_valid_2_alias = []byte(`{
+	"retailer": "M & M CORNER MKT",
+	"purchaseDate": "2022-03-20",
+	"purchaseTime": "14:33",
+	"items": [
+	  {
+		"shortDescription": "Gatorade",
+		"price": "2.25"
+	  },{
+		"shortDescription": "Gatorade",
+		"price": "2.25"
+	  },{
+		"shortDescription": "Gatorade",
+		"price": "2.25"
+	  },{
+		"shortDescription": "Gatorade",
+		"price": "2.25"
+	  }
+	],
+	"total": "9.00"
+  }`)
+
+// perform a request against a fresh router and return the recorder
+func doRequest(t *testing.T, method string, path string, body []byte) *httptest.ResponseRecorder {
+	router := setupRouter()
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest(method, path, bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestNormalizeRetailer(t *testing.T) {
+	assert.Equal(t, "mmcornermarket", normalizeRetailer("M&M Corner Market"))
+	assert.Equal(t, "mmcornermarket", normalizeRetailer("M & M CORNER MKT"))
+	assert.Equal(t, "mmcornermarket", normalizeRetailer("m&m corner market "))
+	assert.Equal(t, "", normalizeRetailer(" & "))
+}
+
+func TestMerchantCatalog_Resolve(t *testing.T) {
+	mc := NewMerchantCatalog()
+	assert.True(t, mc.add(Merchant{ID: "target", Name: "Target"}))
+	assert.True(t, mc.add(Merchant{ID: "walgreens", Name: "Walgreens", Aliases: []string{"Walgreen Drug Store"}}))
+
+	// exact match on normalized name and alias
+	m, _, present := mc.resolve("TARGET ")
+	assert.True(t, present)
+	assert.Equal(t, "target", m.ID)
+	m, _, present = mc.resolve("walgreen drug str")
+	assert.True(t, present)
+	assert.Equal(t, "walgreens", m.ID)
+
+	// fuzzy match on a typo
+	m, _, present = mc.resolve("Walgrens")
+	assert.True(t, present)
+	assert.Equal(t, "walgreens", m.ID)
+
+	// no match
+	_, _, present = mc.resolve("Costco")
+	assert.False(t, present)
+
+	// aliases cannot be claimed by two merchants
+	assert.False(t, mc.add(Merchant{ID: "target-2", Name: "TARGET"}))
+	assert.False(t, mc.addAlias("target", "Walgreens"))
+}
+
+func TestMerchantCatalog_Resolve_Ambiguous_Abbreviations(t *testing.T) {
+	mc := NewMerchantCatalog()
+	assert.True(t, mc.add(Merchant{ID: "main-store-deli", Name: "Main Store Deli"}))
+	assert.True(t, mc.add(Merchant{ID: "coffee-company", Name: "Coffee Company"}))
+
+	// "St" is as likely to be a street or a saint, and "Co" part of a name, so neither is read as an abbreviation
+	_, _, present := mc.resolve("Main St Deli")
+	assert.False(t, present)
+	_, _, present = mc.resolve("Coffee Co")
+	assert.False(t, present)
+	assert.NotEqual(t, normalizeRetailer("St Louis Bread Co"), normalizeRetailer("Store Louis Bread Company"))
+}
+
+func TestMerchantCatalog_Fuzzy_Matches(t *testing.T) {
+	mc := NewMerchantCatalog()
+	assert.True(t, mc.add(Merchant{ID: "walgreens", Name: "Walgreens"}))
+	_, version, _ := mc.resolve("Walgrens")
+	assert.Equal(t, 1, version)
+
+	// the match is cached for the catalog's version
+	assert.Equal(t, fuzzyMatch{id: "walgreens", version: 1}, mc.matches["walgrens"])
+
+	// and made again once the catalog changes
+	assert.True(t, mc.add(Merchant{ID: "walgrens-bakery", Name: "Walgrens"}))
+	m, version, present := mc.resolve("Walgrens")
+	assert.True(t, present)
+	assert.Equal(t, "walgrens-bakery", m.ID)
+	assert.Equal(t, 2, version)
+
+	// misses are cached too
+	_, _, present = mc.resolve("Costco")
+	assert.False(t, present)
+	assert.Equal(t, fuzzyMatch{version: 2}, mc.matches["costco"])
+
+	// aliases are grouped by length, so those too short or too long to reach the threshold are not compared
+	assert.Contains(t, mc.aliasesByLength[9], "walgreens")
+	assert.Contains(t, mc.aliasesByLength[8], "walgrens")
+	assert.True(t, mc.addAlias("walgreens", "Walgreens Pharmacy"))
+	assert.Contains(t, mc.aliasesByLength[17], "walgreenspharmacy")
+	assert.True(t, mc.removeAlias("walgreens", "Walgreens Pharmacy"))
+	assert.NotContains(t, mc.aliasesByLength[17], "walgreenspharmacy")
+	assert.Equal(t, 4, mc.version)
+}
+
+func TestMerchantCatalog_RemoveAlias(t *testing.T) {
+	mc := NewMerchantCatalog()
+	assert.True(t, mc.add(Merchant{ID: "target", Name: "Target", Aliases: []string{"Tarjay Superstore"}}))
+	assert.True(t, mc.removeAlias("target", "Tarjay Superstore"))
+	_, _, present := mc.resolve("Tarjay Superstore")
+	assert.False(t, present)
+	// the merchant name itself is not an alias
+	assert.False(t, mc.removeAlias("target", "Target"))
+}
+
+func TestMerchantRoutes(t *testing.T) {
+	withMerchants(t)
+	// create merchant
+	w := doRequest(t, http.MethodPost, "/merchants", body_merchant_mm)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// duplicate merchant
+	w = doRequest(t, http.MethodPost, "/merchants", body_merchant_mm)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// add and remove an alias
+	w = doRequest(t, http.MethodPost, "/merchants/mm-corner-market/aliases", []byte(`{"alias": "MM Corner"}`))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"MM Corner"`)
+	w = doRequest(t, http.MethodDelete, "/merchants/mm-corner-market/aliases/MM%20Corner", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), `"MM Corner"`)
+
+	// unknown merchant
+	w = doRequest(t, http.MethodGet, "/merchants/unknown", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), `"No merchant found for that id"`)
+
+	// list
+	w = doRequest(t, http.MethodGet, "/merchants", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"mm-corner-market"`)
+
+	// receipts with different spellings resolve to the same merchant
+	w = doRequest(t, http.MethodPost, "/receipts/process", body_valid_2_alias)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	id := resp["id"].(string)
+	assert.Equal(t, "mm-corner-market", rs.ReceiptsMap[id].Receipt.MerchantID)
+	// along with the catalog version that resolved it - one merchant added, and one alias added and removed
+	assert.Equal(t, 3, rs.ReceiptsMap[id].CatalogVersion)
+	w = doRequest(t, http.MethodGet, "/v2/receipts/"+id+"/points", nil)
+	assert.Contains(t, w.Body.String(), `"catalogVersion":3`)
+
+	w = doRequest(t, http.MethodGet, "/receipts/"+id+"/points", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	// the retailer is still scored as written - "M & M CORNER MKT" has 3 fewer alphanumeric characters
+	assert.Equal(t, body_valid_2_pts-3, int(resp["points"].(float64)))
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Struct definitions & constructors
+
+// Struct representing the service's Prometheus metrics
+type Metrics struct {
+	registry *prometheus.Registry
+	// HTTP requests by method, route and status, and their latency by method and route
+	requests *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+	// receipts accepted, and rejected by the field and reason validation gave
+	processed prometheus.Counter
+	rejected  *prometheus.CounterVec
+	// points awarded to each accepted receipt
+	points prometheus.Histogram
+}
+
+// Constructor for Metrics - registers the collectors, along with the Go runtime and process collectors
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests handled, by method, route and status code.",
+		}, []string{"method", "route", "status"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Time taken to handle HTTP requests, by method and route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		processed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "receipts_processed_total",
+			Help: "Receipts that passed validation and were scored.",
+		}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "receipts_rejected_total",
+			Help: "Receipts that failed validation, by the field at fault and the reason.",
+		}, []string{"field", "reason"}),
+		points: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "receipt_points",
+			Help:    "Points awarded to processed receipts.",
+			Buckets: []float64{0, 10, 25, 50, 75, 100, 150, 200, 300, 500, 1000},
+		}),
+	}
+	m.registry.MustRegister(
+		m.requests, m.latency, m.processed, m.rejected, m.points,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "receipts_stored",
+			Help: "Receipts in the store, in any status.",
+		}, func() float64 { return float64(rs.size()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "images_stored",
+			Help: "Receipt images in the store.",
+		}, func() float64 { return float64(images.size()) }),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Internal data
+
+// Global metrics object
+var metrics = NewMetrics() // pointer to Metrics object
+
+// Item indexes in validation fields, like items[3].price - dropped so each field is one label value
+var fieldIndexRegex = regexp.MustCompile(`\[\d+\]`)
+
+// Values quoted in validation reasons, like invalid amount "-1.00" - dropped so clients cannot add label values
+var quotedValueRegex = regexp.MustCompile(` ?"[^"]*"`)
+
+// Internal functions - not exported
+
+// Count an evaluated receipt - the points it was awarded, or why it was rejected
+func (m *Metrics) observeReceipt(rp ReceiptPoints) {
+	if rp.Rejection != nil {
+		field := fieldIndexRegex.ReplaceAllString(rp.Rejection.Field, "[]")
+		m.rejected.WithLabelValues(field, quotedValueRegex.ReplaceAllString(rp.Rejection.Reason, "")).Inc()
+		return
+	}
+	m.processed.Inc()
+	m.points.Observe(float64(rp.Points))
+}
+
+// Middleware counting requests and timing them, by route
+// Requests matching no route are counted under one label, so probing random paths cannot add series
+func observeRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		m := metrics
+		m.requests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		m.latency.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Internal Route Functions
+
+// Path: /metrics
+// Method: GET
+// Payload: None
+// Response: The service's metrics, in the Prometheus text format.
+// Description: Request counts and latency by route, receipts processed and rejected, points awarded and store size.
+func getMetrics(c *gin.Context) {
+	promhttp.HandlerFor(metrics.registry, promhttp.HandlerOpts{}).ServeHTTP(c.Writer, c.Request)
+}
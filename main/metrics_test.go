@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// start the metrics from zero for the duration of a test
+func withMetrics(t *testing.T) *Metrics {
+	m := NewMetrics()
+	previous := metrics
+	metrics = m
+	t.Cleanup(func() { metrics = previous })
+	return m
+}
+
+func TestMetrics_Receipts(t *testing.T) {
+	m := withMetrics(t)
+	withReceipts(t)
+
+	assert.Equal(t, http.StatusOK, doRequest(t, http.MethodPost, "/receipts/process", body_valid_1).Code)
+	assert.Equal(t, http.StatusOK, doRequest(t, http.MethodPost, "/receipts/process", body_valid_2).Code)
+	assert.Equal(t, http.StatusBadRequest, doRequest(t, http.MethodPost, "/receipts/process", body_bad_negative_total).Code)
+	r := receipt_valid_2()
+	r.Items[1].Price = "-1.00"
+	r.Items[2].Price = "-1.00"
+	evaluateReceipt(context.Background(), r)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.processed))
+	// the values rejected are dropped from the reason, and item indexes from the field
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.rejected.WithLabelValues("total", "invalid amount")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.rejected.WithLabelValues("items[].price", "invalid amount")))
+	assert.Equal(t, 2, testutil.CollectAndCount(m.rejected))
+	assert.Equal(t, 3.0, testutil.ToFloat64(m.requests.WithLabelValues(http.MethodPost, "/receipts/process", "200"))+testutil.ToFloat64(m.requests.WithLabelValues(http.MethodPost, "/receipts/process", "400")))
+}
+
+func TestMetrics_Endpoint(t *testing.T) {
+	withMetrics(t)
+	withReceipts(t)
+	doRequest(t, http.MethodPost, "/receipts/process", body_valid_2)
+	doRequest(t, http.MethodGet, "/receipts/unknown/points", nil)
+	doRequest(t, http.MethodGet, "/no/such/route", nil)
+
+	w := doRequest(t, http.MethodGet, "/metrics", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain"))
+	body := w.Body.String()
+	for _, line := range []string{
+		`http_requests_total{method="POST",route="/receipts/process",status="200"} 1`,
+		`http_requests_total{method="GET",route="/receipts/:id/points",status="404"} 1`,
+		`http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`http_request_duration_seconds_count{method="POST",route="/receipts/process"} 1`,
+		`receipts_processed_total 1`,
+		`receipt_points_count 1`,
+		`receipts_stored 1`,
+		`# TYPE images_stored gauge`,
+		`# TYPE go_goroutines gauge`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// Struct definitions & constructors
+
+// Interface for extracting a receipt from an image
+// Implementations return the receipt they read with a per-field confidence - validation happens afterwards
+type OCRProvider interface {
+	ExtractReceipt(ctx context.Context, image []byte, contentType string) (ParsedReceipt, error)
+}
+
+// Error returned when a provider cannot read any receipt from an image
+var ErrNoReceiptFound = errors.New("no receipt found in image")
+
+// Struct representing a deterministic OCR provider for tests and local development
+// Images are looked up by their SHA-256, and the registered text is parsed with parseReceiptText
+type FakeOCRProvider struct {
+	mu    sync.RWMutex
+	texts map[string]string
+}
+
+// Constructor for FakeOCRProvider
+func NewFakeOCRProvider() *FakeOCRProvider {
+	var f FakeOCRProvider
+	f.texts = make(map[string]string)
+	return &f
+}
+
+// Register the receipt text the fake should "read" from an image
+func (f *FakeOCRProvider) Register(image []byte, text string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.texts[imageDigest(image)] = text
+}
+
+// Extract a receipt from a registered image - unregistered images contain no receipt
+func (f *FakeOCRProvider) ExtractReceipt(ctx context.Context, image []byte, contentType string) (ParsedReceipt, error) {
+	f.mu.RLock()
+	text, present := f.texts[imageDigest(image)]
+	f.mu.RUnlock()
+	if !present {
+		return ParsedReceipt{}, ErrNoReceiptFound
+	}
+	return parseReceiptText(text), nil
+}
+
+// Struct representing an OCR provider backed by the tesseract command line tool
+type TesseractOCRProvider struct {
+	// path to the tesseract binary
+	Path string
+}
+
+// Extract a receipt by running tesseract over the image and parsing its text output
+func (p TesseractOCRProvider) ExtractReceipt(ctx context.Context, image []byte, contentType string) (ParsedReceipt, error) {
+	var stdout, stderr bytes.Buffer
+	// read the image from stdin and write text to stdout, treating the page as a single column of text
+	cmd := exec.CommandContext(ctx, p.Path, "stdin", "stdout", "--psm", "4")
+	cmd.Stdin = bytes.NewReader(image)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return ParsedReceipt{}, fmt.Errorf("tesseract failed: %v: %s", err, stderr.String())
+	}
+	if len(bytes.TrimSpace(stdout.Bytes())) == 0 {
+		return ParsedReceipt{}, ErrNoReceiptFound
+	}
+	return parseReceiptText(stdout.String()), nil
+}
+
+// Internal data
+
+// OCR provider used by the image upload route - set from the OCR_PROVIDER env variable on startup, nil if none
+var ocr OCRProvider
+
+// Internal functions - not exported
+
+// Hex SHA-256 of an image
+func imageDigest(image []byte) string {
+	sum := sha256.Sum256(image)
+	return hex.EncodeToString(sum[:])
+}
+
+// Select the OCR provider from the environment
+// OCR_PROVIDER=tesseract uses the tesseract binary (TESSERACT_PATH, default "tesseract"), OCR_PROVIDER=fake a fake with no images
+func ocrProviderFromEnv() (OCRProvider, error) {
+	switch os.Getenv("OCR_PROVIDER") {
+	case "":
+		return nil, nil
+	case "fake":
+		return NewFakeOCRProvider(), nil
+	case "tesseract":
+		path := os.Getenv("TESSERACT_PATH")
+		if path == "" {
+			path = "tesseract"
+		}
+		resolved, err := exec.LookPath(path)
+		if err != nil {
+			return nil, err
+		}
+		return TesseractOCRProvider{Path: resolved}, nil
+	default:
+		return nil, fmt.Errorf("unknown OCR provider %q", os.Getenv("OCR_PROVIDER"))
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	swaggerFiles "github.com/swaggo/files/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// openapi.yaml is a copy of api.yml, kept next to the code so it can be embedded - the Docker build only copies main/
+//go:generate cp ../api.yml openapi.yaml
+
+// Internal data
+
+// The API spec, as written in api.yml
+//
+//go:embed openapi.yaml
+var openAPIYAML []byte
+
+// The docs page - renders /openapi.json with Swagger UI
+//
+//go:embed docs.html
+var docsHTML []byte
+
+// Swagger UI files the docs page loads, and their content types - embedded from the swagger-ui-dist release
+// pinned in go.mod (through github.com/swaggo/files/v2), so the page loads nothing from third parties
+var docsAssets = map[string]string{
+	"swagger-ui.css":       "text/css; charset=utf-8",
+	"swagger-ui-bundle.js": "application/javascript; charset=utf-8",
+}
+
+// The API spec converted to JSON, built on first request
+var (
+	openAPIOnce sync.Once
+	openAPIJSON []byte
+	openAPIErr  error
+)
+
+// Internal functions - not exported
+
+// Convert the embedded YAML spec to JSON
+func loadOpenAPIJSON() ([]byte, error) {
+	openAPIOnce.Do(func() {
+		var spec interface{}
+		if openAPIErr = yaml.Unmarshal(openAPIYAML, &spec); openAPIErr != nil {
+			return
+		}
+		openAPIJSON, openAPIErr = json.Marshal(jsonValue(spec))
+	})
+	return openAPIJSON, openAPIErr
+}
+
+// Make a decoded YAML value encodable as JSON - YAML allows non-string map keys, like the status codes under responses
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = jsonValue(e)
+		}
+		return v
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = jsonValue(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range v {
+			v[i] = jsonValue(e)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// Internal Route Functions
+
+// Path: /openapi.yaml
+// Method: GET
+// Response: The API spec, as YAML.
+// Description: Returns the OpenAPI spec the service was built with.
+func getOpenAPIYAML(c *gin.Context) {
+	c.Data(http.StatusOK, "application/yaml", openAPIYAML)
+}
+
+// Path: /openapi.json
+// Method: GET
+// Response: The API spec, as JSON.
+// Description: Returns the OpenAPI spec the service was built with.
+func getOpenAPIJSON(c *gin.Context) {
+	spec, err := loadOpenAPIJSON()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"description": "The API spec could not be loaded"})
+		return
+	}
+	c.Data(http.StatusOK, "application/json", spec)
+}
+
+// Path: /docs
+// Method: GET
+// Response: An HTML page.
+// Description: Interactive API docs, rendered from /openapi.json.
+func getDocs(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", docsHTML)
+}
+
+// Path: /docs/{asset}
+// Method: GET
+// Response: A Swagger UI stylesheet or script.
+// Description: Serves the embedded Swagger UI files the docs page loads.
+func getDocsAsset(c *gin.Context) {
+	asset := c.Param("asset")
+	contentType, present := docsAssets[asset]
+	if !present {
+		c.JSON(http.StatusNotFound, gin.H{"description": "No docs asset found for that name"})
+		return
+	}
+	data, err := fs.ReadFile(swaggerFiles.FS, asset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"description": "The docs asset could not be loaded"})
+		return
+	}
+	c.Data(http.StatusOK, contentType, data)
+}
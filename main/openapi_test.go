@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// gin path parameters, as written in the spec
+var ginParam = regexp.MustCompile(`:(\w+)`)
+
+func TestOpenAPI_Embedded_Copy(t *testing.T) {
+	spec, err := os.ReadFile("../api.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, string(spec), string(openAPIYAML), "main/openapi.yaml is out of date - run go generate ./main")
+}
+
+func TestOpenAPI_Routes(t *testing.T) {
+	w := doRequest(t, http.MethodGet, "/openapi.yaml", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, openAPIYAML, w.Body.Bytes())
+
+	w = doRequest(t, http.MethodGet, "/docs", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "/openapi.json")
+	assert.NotContains(t, w.Body.String(), "unpkg")
+
+	w = doRequest(t, http.MethodGet, "/docs/swagger-ui.css", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/css; charset=utf-8", w.Header().Get("Content-Type"))
+	w = doRequest(t, http.MethodGet, "/docs/swagger-ui-bundle.js", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "SwaggerUIBundle")
+	w = doRequest(t, http.MethodGet, "/docs/openapi.go", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = doRequest(t, http.MethodGet, "/openapi.json", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var spec struct {
+		OpenAPI string                            `json:"openapi"`
+		Paths   map[string]map[string]interface{} `json:"paths"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &spec); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "3.0.3", spec.OpenAPI)
+
+	// every route in the spec is served, and every route served is in the spec
+	var documented, served []string
+	for path, ops := range spec.Paths {
+		for method := range ops {
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+		}
+	}
+	for _, route := range setupRouter().Routes() {
+		served = append(served, route.Method+" "+ginParam.ReplaceAllString(route.Path, "{$1}"))
+	}
+	assert.ElementsMatch(t, documented, served)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Struct definitions & constructors
+
+// Struct representing a queued receipt
+type receiptJob struct {
+	id string
+	// the ID and span of the request that queued the job - the job's logs carry the ID, and its span links to the request's
+	requestID string
+	queuedBy  trace.SpanContext
+	// produces the receipt to evaluate - expensive steps (OCR, enrichment) run here, on a worker
+	extract func(ctx context.Context) (Receipt, error)
+}
+
+// Struct representing the asynchronous processing queue - a bounded channel drained by a pool of workers
+type ReceiptQueue struct {
+	mu      sync.RWMutex
+	jobs    chan receiptJob
+	wg      sync.WaitGroup
+	workers int
+	// set once draining - no more jobs are accepted
+	closed bool
+	// how long a single job may take before it is rejected
+	timeout time.Duration
+}
+
+// Constructor for ReceiptQueue - jobs wait until the workers are started
+func NewReceiptQueue(size int, workers int) *ReceiptQueue {
+	var q ReceiptQueue
+	q.jobs = make(chan receiptJob, size)
+	q.workers = workers
+	q.timeout = 30 * time.Second
+	return &q
+}
+
+// Internal data
+
+// Global processing queue - its workers are started by main
+var receiptQueue = NewReceiptQueue(100, 4) // pointer to ReceiptQueue object
+
+// Process every receipt asynchronously, not only those that ask for it - set from the ASYNC_PROCESSING env variable on startup
+var asyncByDefault = false
+
+// Internal functions - not exported
+
+// Add a job to the queue - returns false if the queue is full or draining
+func (q *ReceiptQueue) enqueue(job receiptJob) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return false
+	}
+	select {
+	case q.jobs <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+// Start the workers
+func (q *ReceiptQueue) start() {
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+}
+
+// Stop accepting jobs and wait for the workers to finish the jobs already queued, or for ctx to be done
+// Returns ctx's error if jobs were left unfinished
+func (q *ReceiptQueue) drain(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		close(q.jobs)
+		q.closed = true
+	}
+	q.mu.Unlock()
+	return waitFor(ctx, &q.wg)
+}
+
+// Wait for a wait group, or for ctx to be done - whichever comes first
+func waitFor(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Worker loop - process jobs until the queue is stopped
+func (q *ReceiptQueue) work() {
+	defer q.wg.Done()
+	for job := range q.jobs {
+		q.process(job)
+	}
+}
+
+// Process a single job - extract, evaluate and store the receipt under the job's ID
+func (q *ReceiptQueue) process(job receiptJob) {
+	ctx, cancel := context.WithTimeout(withRequestID(context.Background(), job.requestID), q.timeout)
+	defer cancel()
+	// a trace of its own, linked to the request's - the request is answered before the job runs
+	ctx, span := tracerProvider.Tracer(tracerName).Start(ctx, "processQueuedReceipt",
+		trace.WithAttributes(attribute.String("receipt.id", job.id)),
+		trace.WithLinks(trace.Link{SpanContext: job.queuedBy}))
+	defer span.End()
+
+	var stored ReceiptPoints
+	r, err := job.extract(ctx)
+	if err != nil {
+		spanError(span, err)
+		rs.update(job.id, func(rp *ReceiptPoints) {
+			rp.Status = StatusRejected
+			stored = *rp
+		})
+		publishReceiptEvent(EventReceiptRejected, job.id, stored)
+		return
+	}
+	evaluated, valid := evaluateReceipt(ctx, r)
+	_, storeSpan := startSpan(ctx, "store", attribute.String("receipt.id", job.id))
+	rs.update(job.id, func(rp *ReceiptPoints) {
+		// keep the link to the uploaded image, if any, and who submitted it
+		evaluated.ImageID = rp.ImageID
+		evaluated.Owner = rp.Owner
+		*rp = evaluated
+	})
+	storeSpan.End()
+	if valid {
+		publishReceiptEvent(EventReceiptScored, job.id, evaluated)
+	} else {
+		publishReceiptEvent(EventReceiptRejected, job.id, evaluated)
+	}
+}
+
+// Store a pending receipt and queue it for processing
+// Returns the new receipt ID, or false if the queue is full. The receipt is tagged with the caller attached to ctx, if any
+func enqueueReceipt(ctx context.Context, pending ReceiptPoints, extract func(ctx context.Context) (Receipt, error)) (string, bool) {
+	// generate ID
+	id := uuid.New().String()
+	pending.Owner = ownerFrom(ctx)
+
+	// store as pending first, so the ID can be polled as soon as it is returned
+	pending.Status = StatusPending
+	rs.put(id, pending)
+	if !receiptQueue.enqueue(receiptJob{id: id, requestID: requestIDFrom(ctx), queuedBy: trace.SpanContextFromContext(ctx), extract: extract}) {
+		rs.remove(id)
+		return "", false
+	}
+	return id, true
+}
+
+// Check if a request asked to be processed asynchronously - ?async=true or a "Prefer: respond-async" header
+func wantsAsync(c *gin.Context) bool {
+	if async, err := strconv.ParseBool(c.Query("async")); err == nil {
+		return async
+	}
+	return asyncByDefault || strings.Contains(c.GetHeader("Prefer"), "respond-async")
+}
+
+// Respond that the queue is full
+func queueFull(c *gin.Context) {
+	c.Header("Retry-After", "1")
+	c.JSON(http.StatusServiceUnavailable, gin.H{"description": "The processing queue is full"})
+}
+
+// Configure the processing queue from the environment
+// QUEUE_SIZE and QUEUE_WORKERS size the queue, ASYNC_PROCESSING=true processes every receipt asynchronously
+// Unset variables keep their defaults - set but invalid ones are an error
+func configureQueueFromEnv() error {
+	if raw := os.Getenv("ASYNC_PROCESSING"); raw != "" {
+		async, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid ASYNC_PROCESSING %q - expected true or false", raw)
+		}
+		asyncByDefault = async
+	}
+	size, workers := 100, 4
+	if raw := os.Getenv("QUEUE_SIZE"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid QUEUE_SIZE %q - expected a positive number", raw)
+		}
+		size = n
+	}
+	if raw := os.Getenv("QUEUE_WORKERS"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid QUEUE_WORKERS %q - expected a positive number", raw)
+		}
+		workers = n
+	}
+	receiptQueue = NewReceiptQueue(size, workers)
+	return nil
+}
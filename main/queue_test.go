@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// use a queue of the given size and workers for the duration of a test
+func withQueue(t *testing.T, size int, workers int) *ReceiptQueue {
+	q := NewReceiptQueue(size, workers)
+	q.start()
+	previous := receiptQueue
+	receiptQueue = q
+	t.Cleanup(func() {
+		receiptQueue = previous
+		q.drain(context.Background())
+	})
+	return q
+}
+
+// poll a receipt until it leaves the pending status
+func waitForStatus(t *testing.T, id string) ReceiptPoints {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if rp, _ := rs.get(id); rp.Status != StatusPending {
+			return rp
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("receipt %s still pending", id)
+	return ReceiptPoints{}
+}
+
+func TestProcessReceipt_Async(t *testing.T) {
+	withQueue(t, 10, 2)
+
+	w := doRequest(t, http.MethodPost, "/receipts/process?async=true", body_valid_2)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var resp map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, StatusPending, resp["status"])
+	id := resp["id"].(string)
+
+	rp := waitForStatus(t, id)
+	assert.Equal(t, StatusProcessed, rp.Status)
+	assert.Equal(t, body_valid_2_pts, rp.Points)
+
+	w = doRequest(t, http.MethodGet, "/receipts/"+id+"/points", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"points": 109}`, w.Body.String())
+}
+
+func TestProcessReceipt_Async_Rejected(t *testing.T) {
+	withMerchants(t)
+	withQueue(t, 10, 2)
+
+	// an invalid receipt is still accepted, then rejected by the worker
+	router := setupRouter()
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodPost, "/receipts/process", bytes.NewBuffer(body_bad_negative_total))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Prefer", "respond-async")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var resp map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	id := resp["id"].(string)
+
+	assert.Equal(t, StatusRejected, waitForStatus(t, id).Status)
+	// v1 reports the rejection as an error, v2 with the reason
+	w = doRequest(t, http.MethodGet, "/receipts/"+id+"/points", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"description": "The receipt is invalid"}`, w.Body.String())
+	w = doRequest(t, http.MethodGet, "/v2/receipts/"+id+"/points", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"id": "`+id+`", "status": "rejected", "catalogVersion": 0, "rejection": {"field": "total", "reason": "invalid amount \"-7.75\""}}`, w.Body.String())
+
+	// bad JSON is still rejected synchronously
+	w = doRequest(t, http.MethodPost, "/receipts/process?async=true", body_bad_empty)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGetPoints_Pending(t *testing.T) {
+	withQueue(t, 10, 1)
+
+	// hold the job on the worker until the pending status has been checked
+	release := make(chan struct{})
+	id, queued := enqueueReceipt(context.Background(), ReceiptPoints{}, func(ctx context.Context) (Receipt, error) {
+		<-release
+		return Receipt{}, errors.New("extraction failed")
+	})
+	assert.True(t, queued)
+
+	// v1 has no points to return yet, v2 returns the status
+	w := doRequest(t, http.MethodGet, "/receipts/"+id+"/points", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.JSONEq(t, `{"description": "The receipt is still being processed"}`, w.Body.String())
+	w = doRequest(t, http.MethodGet, "/v2/receipts/"+id+"/points", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"id": "`+id+`", "status": "pending"}`, w.Body.String())
+
+	close(release)
+	assert.Equal(t, StatusRejected, waitForStatus(t, id).Status)
+}
+
+func TestReceiptQueue_Drain(t *testing.T) {
+	q := withQueue(t, 10, 1)
+
+	// one job holds the only worker, so the one behind it waits
+	release := make(chan struct{})
+	held, _ := enqueueReceipt(context.Background(), ReceiptPoints{}, func(ctx context.Context) (Receipt, error) {
+		<-release
+		return receipt_valid_2(), nil
+	})
+	waiting, _ := enqueueReceipt(context.Background(), ReceiptPoints{}, func(ctx context.Context) (Receipt, error) {
+		return receipt_valid_2(), nil
+	})
+
+	// draining gives up at the deadline, and refuses new jobs
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, q.drain(ctx), context.DeadlineExceeded)
+	_, queued := enqueueReceipt(context.Background(), ReceiptPoints{}, nil)
+	assert.False(t, queued)
+
+	// the jobs already queued are still finished
+	close(release)
+	assert.NoError(t, q.drain(context.Background()))
+	for _, id := range []string{held, waiting} {
+		rp, _ := rs.get(id)
+		assert.Equal(t, StatusProcessed, rp.Status)
+	}
+}
+
+func TestProcessReceipt_Async_Queue_Full(t *testing.T) {
+	// no workers, so the single slot stays taken
+	withQueue(t, 1, 0)
+
+	w := doRequest(t, http.MethodPost, "/receipts/process?async=true", body_valid_1)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	w = doRequest(t, http.MethodPost, "/receipts/process?async=true", body_valid_1)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	// synchronous processing is unaffected
+	w = doRequest(t, http.MethodPost, "/receipts/process?async=false", body_valid_1)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestProcessImageReceipt_Async(t *testing.T) {
+	withQueue(t, 10, 2)
+	fake := withFakeOCR(t)
+	fake.Register(image_valid_2, text_valid_2)
+
+	previous := asyncByDefault
+	asyncByDefault = true
+	t.Cleanup(func() { asyncByDefault = previous })
+
+	code, resp := postImage(t, "image", image_valid_2)
+	assert.Equal(t, http.StatusAccepted, code)
+	rp := waitForStatus(t, resp["id"].(string))
+	assert.Equal(t, StatusProcessed, rp.Status)
+	assert.Equal(t, body_valid_2_pts, rp.Points)
+	assert.Equal(t, resp["imageId"], rp.ImageID)
+}
+
+func TestConfigureQueueFromEnv(t *testing.T) {
+	previous, async := receiptQueue, asyncByDefault
+	t.Cleanup(func() { receiptQueue, asyncByDefault = previous, async })
+
+	// unset variables keep their defaults
+	assert.NoError(t, configureQueueFromEnv())
+	assert.Equal(t, 100, cap(receiptQueue.jobs))
+	assert.Equal(t, 4, receiptQueue.workers)
+	assert.False(t, asyncByDefault)
+
+	t.Setenv("QUEUE_SIZE", "10")
+	t.Setenv("ASYNC_PROCESSING", "true")
+	assert.NoError(t, configureQueueFromEnv())
+	assert.Equal(t, 10, cap(receiptQueue.jobs))
+	assert.Equal(t, 4, receiptQueue.workers)
+	assert.True(t, asyncByDefault)
+
+	for name, value := range map[string]string{"ASYNC_PROCESSING": "sometimes", "QUEUE_SIZE": "abc", "QUEUE_WORKERS": "0"} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			assert.Error(t, configureQueueFromEnv())
+		})
+	}
+}
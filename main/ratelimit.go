@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Struct definitions & constructors
+
+// Struct representing a rate limit - a token bucket refilling requests tokens every per, holding up to burst
+type RateLimit struct {
+	Requests int `json:"requests"`
+	// a duration like 1s or 1m
+	Per string `json:"per"`
+	// defaults to requests
+	Burst int `json:"burst,omitempty"`
+	// per, parsed
+	interval time.Duration
+}
+
+// Struct representing a caller's token bucket on one route
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Struct representing RateLimits - the limits for each route and the callers' buckets
+type RateLimits struct {
+	mu sync.Mutex
+	// limit for routes not listed - none if nil
+	Default *RateLimit `json:"default"`
+	// store a map of limits accessed via "<method> <route>", like "POST /receipts/process"
+	Routes map[string]RateLimit `json:"routes"`
+	// store a map of buckets accessed via "<method> <route>|<caller>"
+	buckets map[string]*tokenBucket
+	// when full buckets were last dropped
+	swept time.Time
+}
+
+// Constructor for RateLimits
+func NewRateLimits() *RateLimits {
+	var ls RateLimits
+	ls.Routes = make(map[string]RateLimit)
+	ls.buckets = make(map[string]*tokenBucket)
+	return &ls
+}
+
+// Internal data
+
+// Global rate limits object - requests are not limited until limits are configured
+var rateLimits = NewRateLimits() // pointer to RateLimits object
+
+// Proxies trusted to set X-Forwarded-For - none by default, so callers cannot pick the IP they are limited by
+var trustedProxies []string
+
+// How often buckets that have refilled are dropped
+const bucketSweepInterval = time.Minute
+
+// Internal functions - not exported
+
+// Check a limit and fill in its defaults
+func (l *RateLimit) parse() error {
+	interval, err := time.ParseDuration(l.Per)
+	if err != nil || interval <= 0 {
+		return fmt.Errorf("invalid per %q - needs a duration like 1s or 1m", l.Per)
+	}
+	if l.Requests <= 0 || l.Burst < 0 {
+		return fmt.Errorf("invalid rate limit %d per %s - needs a positive number of requests", l.Requests, l.Per)
+	}
+	if l.Burst == 0 {
+		l.Burst = l.Requests
+	}
+	l.interval = interval
+	return nil
+}
+
+// Tokens added per second
+func (l RateLimit) rate() float64 {
+	return float64(l.Requests) / l.interval.Seconds()
+}
+
+// Load rate limits from a JSON file - {"default": {requests, per, burst}, "routes": {"<method> <route>": {...}}}
+func loadRateLimits(path string) (*RateLimits, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ls := NewRateLimits()
+	if err := json.Unmarshal(data, ls); err != nil {
+		return nil, err
+	}
+	if ls.Default != nil {
+		if err := ls.Default.parse(); err != nil {
+			return nil, fmt.Errorf("default: %w", err)
+		}
+	}
+	for route, l := range ls.Routes {
+		if parts := strings.Fields(route); len(parts) != 2 || !strings.HasPrefix(parts[1], "/") {
+			return nil, fmt.Errorf("invalid route %q - needs a method and a route, like POST /receipts/process", route)
+		}
+		if err := l.parse(); err != nil {
+			return nil, fmt.Errorf("%s: %w", route, err)
+		}
+		ls.Routes[route] = l
+	}
+	return ls, nil
+}
+
+// The limit for a route - false if it is not limited
+func (ls *RateLimits) limit(route string) (RateLimit, bool) {
+	if l, present := ls.Routes[route]; present {
+		return l, true
+	}
+	if ls.Default != nil {
+		return *ls.Default, true
+	}
+	return RateLimit{}, false
+}
+
+// Take a token from a caller's bucket for a route
+// Returns whether the request is allowed, the tokens left, and how long until the next token and until the bucket is full
+func (ls *RateLimits) take(route string, caller string, l RateLimit, at time.Time) (bool, int, time.Duration, time.Duration) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.sweep(at)
+	rate, burst := l.rate(), float64(l.Burst)
+	k := route + "|" + caller
+	b, present := ls.buckets[k]
+	if !present {
+		b = &tokenBucket{tokens: burst, last: at}
+		ls.buckets[k] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+at.Sub(b.last).Seconds()*rate)
+	b.last = at
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	next := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	full := time.Duration((burst - b.tokens) / rate * float64(time.Second))
+	return allowed, int(b.tokens), next, full
+}
+
+// Drop the buckets that have refilled - a full bucket is the same as none
+// Callers must hold the lock
+func (ls *RateLimits) sweep(at time.Time) {
+	if at.Sub(ls.swept) < bucketSweepInterval {
+		return
+	}
+	ls.swept = at
+	for k, b := range ls.buckets {
+		route := k[:strings.LastIndex(k, "|")]
+		l, limited := ls.limit(route)
+		if !limited || b.tokens+at.Sub(b.last).Seconds()*l.rate() >= float64(l.Burst) {
+			delete(ls.buckets, k)
+		}
+	}
+}
+
+// The caller a request is limited as - its API key's client, its end user, or else its IP
+func callerKey(c *gin.Context) string {
+	return callerKeyFrom(c.Request.Context(), c.ClientIP())
+}
+
+// The caller work under ctx is limited as - its principal, or else the IP it connects from
+// Shared by HTTP and gRPC, so a caller using both draws on the same buckets
+func callerKeyFrom(ctx context.Context, ip string) string {
+	if p := principalFrom(ctx); p != nil {
+		if p.UserID != "" {
+			return "user:" + p.UserID
+		}
+		return "client:" + p.ClientID
+	}
+	return "ip:" + ip
+}
+
+// Whole seconds, rounded up
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+// Middleware limiting each caller's request rate on a route, once limits are configured
+// Runs after requireScope, so callers with credentials are limited by who they are rather than where they connect from
+func rateLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.Request.Method + " " + c.FullPath()
+		l, limited := rateLimits.limit(route)
+		if !limited {
+			c.Next()
+			return
+		}
+		allowed, remaining, next, full := rateLimits.take(route, callerKey(c), l, now())
+		c.Header("RateLimit-Limit", strconv.Itoa(l.Burst))
+		c.Header("RateLimit-Remaining", strconv.Itoa(remaining))
+		c.Header("RateLimit-Reset", ceilSeconds(full))
+		if !allowed {
+			c.Header("Retry-After", ceilSeconds(next))
+			description := "Too many requests - retry after " + ceilSeconds(next) + " seconds"
+			// the v2 routes keep their structured errors
+			if strings.HasPrefix(c.FullPath(), "/v2/") {
+				respondErrorV2(c, http.StatusTooManyRequests, ErrCodeRateLimited, description)
+				c.Abort()
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"description": description})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Interceptor limiting each caller's RPC rate, once limits are configured
+// RPCs are limited as the REST routes they mirror, and run after authenticateRPC like rateLimit runs after requireScope
+func rateLimitRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	route, present := rpcRoutes[info.FullMethod]
+	if !present {
+		return handler(ctx, req)
+	}
+	l, limited := rateLimits.limit(route)
+	if !limited {
+		return handler(ctx, req)
+	}
+	var ip string
+	if p, ok := peer.FromContext(ctx); ok {
+		ip = p.Addr.String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+	}
+	allowed, remaining, next, full := rateLimits.take(route, callerKeyFrom(ctx, ip), l, now())
+	md := metadata.Pairs("ratelimit-limit", strconv.Itoa(l.Burst), "ratelimit-remaining", strconv.Itoa(remaining), "ratelimit-reset", ceilSeconds(full))
+	if !allowed {
+		md.Set("retry-after", ceilSeconds(next))
+		grpc.SetHeader(ctx, md)
+		return nil, status.Error(codes.ResourceExhausted, "Too many requests - retry after "+ceilSeconds(next)+" seconds")
+	}
+	grpc.SetHeader(ctx, md)
+	return handler(ctx, req)
+}
+
+// Configure the proxies trusted to set X-Forwarded-For from the environment - TRUSTED_PROXIES, a comma-separated list of IPs and CIDRs
+func trustedProxiesFromEnv() ([]string, error) {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q - needs an IP or CIDR", proxy)
+		}
+		proxies = append(proxies, proxy)
+	}
+	return proxies, nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/joelyoshiya/Fetch_Rewards_Backend_Coding_Challenge/receiptclient"
+	"github.com/joelyoshiya/Fetch_Rewards_Backend_Coding_Challenge/receiptpb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// load rate limits from JSON for the duration of a test
+func withRateLimits(t *testing.T, limits string) *RateLimits {
+	path := filepath.Join(t.TempDir(), "limits.json")
+	if err := os.WriteFile(path, []byte(limits), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ls, err := loadRateLimits(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := rateLimits
+	rateLimits = ls
+	t.Cleanup(func() { rateLimits = previous })
+	return ls
+}
+
+// perform a request from an IP against a fresh router
+func doIPRequest(t *testing.T, method string, path string, body []byte, ip string, forwardedFor string) *httptest.ResponseRecorder {
+	router := setupRouter()
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest(method, path, bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = ip + ":51234"
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimit_Per_Client(t *testing.T) {
+	withRateLimits(t, `{"routes": {"POST /receipts/process": {"requests": 2, "per": "1m"}}}`)
+	withAPIKeys(t)
+	withReceipts(t)
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	withNow(t, start)
+
+	w := doKeyRequest(t, http.MethodPost, "/receipts/process", body_valid_2, "key-a")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", w.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, http.StatusOK, doKeyRequest(t, http.MethodPost, "/receipts/process", body_valid_2, "key-a").Code)
+
+	w = doKeyRequest(t, http.MethodPost, "/receipts/process", body_valid_2, "key-a")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "60", w.Header().Get("RateLimit-Reset"))
+	assert.JSONEq(t, `{"description": "Too many requests - retry after 30 seconds"}`, w.Body.String())
+
+	// other clients have their own buckets, and other routes are not limited
+	assert.Equal(t, http.StatusOK, doKeyRequest(t, http.MethodPost, "/receipts/process", body_valid_2, "key-b").Code)
+	w = doKeyRequest(t, http.MethodGet, "/merchants", nil, "key-ops")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+
+	// a token is back after 30 seconds
+	withNow(t, start.Add(30*time.Second))
+	assert.Equal(t, http.StatusOK, doKeyRequest(t, http.MethodPost, "/receipts/process", body_valid_2, "key-a").Code)
+	assert.Equal(t, http.StatusTooManyRequests, doKeyRequest(t, http.MethodPost, "/receipts/process", body_valid_2, "key-a").Code)
+}
+
+func TestRateLimit_Per_IP(t *testing.T) {
+	withRateLimits(t, `{"default": {"requests": 1, "per": "1s"}}`)
+	withReceipts(t)
+	withNow(t, time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+
+	assert.Equal(t, http.StatusOK, doIPRequest(t, http.MethodPost, "/receipts/process", body_valid_2, "192.0.2.1", "").Code)
+	w := doIPRequest(t, http.MethodPost, "/receipts/process", body_valid_2, "192.0.2.1", "")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, doIPRequest(t, http.MethodPost, "/receipts/process", body_valid_2, "192.0.2.2", "").Code)
+
+	// the default applies to each route separately
+	assert.Equal(t, http.StatusOK, doIPRequest(t, http.MethodGet, "/openapi.yaml", nil, "192.0.2.1", "").Code)
+
+	// X-Forwarded-For is ignored unless it comes from a trusted proxy
+	w = doIPRequest(t, http.MethodPost, "/receipts/process", body_valid_2, "192.0.2.1", "198.51.100.7")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	previous := trustedProxies
+	trustedProxies = []string{"192.0.2.1"}
+	t.Cleanup(func() { trustedProxies = previous })
+	w = doIPRequest(t, http.MethodPost, "/receipts/process", body_valid_2, "192.0.2.1", "198.51.100.7")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// v2 keeps its structured errors
+	assert.Equal(t, http.StatusCreated, doIPRequest(t, http.MethodPost, "/v2/receipts/process", body_v2_valid_2, "192.0.2.1", "").Code)
+	w = doIPRequest(t, http.MethodPost, "/v2/receipts/process", body_v2_valid_2, "192.0.2.1", "")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, ErrCodeRateLimited, decodeErrorV2(t, w.Body.Bytes()).Code)
+}
+
+func TestClient_Rate_Limited(t *testing.T) {
+	withRateLimits(t, `{"routes": {"POST /receipts/process": {"requests": 1, "per": "1h"}}}`)
+	withReceipts(t)
+	withNow(t, time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	client := newClient(t, setupRouter(), receiptclient.WithRetries(0, 0))
+	ctx := context.Background()
+
+	_, err := client.ProcessReceipt(ctx, clientValue[receiptclient.Receipt](t, body_valid_2))
+	assert.NoError(t, err)
+	_, err = client.ProcessReceipt(ctx, clientValue[receiptclient.Receipt](t, body_valid_2))
+	assert.ErrorIs(t, err, receiptclient.ErrRateLimited)
+}
+
+func TestRateLimit_GRPC(t *testing.T) {
+	withRateLimits(t, `{"routes": {"POST /receipts/process": {"requests": 2, "per": "1m"}}}`)
+	withAPIKeys(t)
+	withReceipts(t)
+	withNow(t, time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	client := newGRPCClient(t)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "key-a")
+	req := &receiptpb.ProcessReceiptRequest{Receipt: proto_valid_2}
+
+	// ProcessReceipt draws on the same bucket as POST /receipts/process
+	var header metadata.MD
+	_, err := client.ProcessReceipt(ctx, req, grpc.Header(&header))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1"}, header.Get("ratelimit-remaining"))
+	assert.Equal(t, http.StatusOK, doKeyRequest(t, http.MethodPost, "/receipts/process", body_valid_2, "key-a").Code)
+	_, err = client.ProcessReceipt(ctx, req, grpc.Header(&header))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, []string{"30"}, header.Get("retry-after"))
+
+	// other clients, and unlimited RPCs, are not held up
+	_, err = client.ProcessReceipt(metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "key-b"), req)
+	assert.NoError(t, err)
+	_, err = client.GetPoints(ctx, &receiptpb.GetPointsRequest{Id: "unknown"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestRateLimit_Buckets_Swept(t *testing.T) {
+	ls := withRateLimits(t, `{"default": {"requests": 1, "per": "1s", "burst": 20}}`)
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	l, _ := ls.limit("GET /merchants")
+	for i := 0; i < 20; i++ {
+		allowed, _, _, _ := ls.take("GET /merchants", "ip:192.0.2.1", l, start)
+		assert.True(t, allowed)
+	}
+	allowed, remaining, next, full := ls.take("GET /merchants", "ip:192.0.2.1", l, start)
+	assert.False(t, allowed)
+	assert.Equal(t, 0, remaining)
+	assert.Equal(t, time.Second, next)
+	assert.Equal(t, 20*time.Second, full)
+	ls.take("GET /merchants", "ip:192.0.2.2", l, start.Add(59500*time.Millisecond))
+	assert.Len(t, ls.buckets, 2)
+
+	// refilled buckets are dropped once a minute
+	ls.take("GET /merchants", "ip:192.0.2.3", l, start.Add(time.Minute))
+	assert.Len(t, ls.buckets, 2)
+	assert.NotContains(t, ls.buckets, "GET /merchants|ip:192.0.2.1")
+}
+
+func TestLoadRateLimits(t *testing.T) {
+	ls := withRateLimits(t, `{"routes": {"GET /receipts/:id/points": {"requests": 100, "per": "1m", "burst": 10}}}`)
+	l, limited := ls.limit("GET /receipts/:id/points")
+	assert.True(t, limited)
+	assert.Equal(t, 10, l.Burst)
+	assert.InDelta(t, 100.0/60, l.rate(), 1e-9)
+	_, limited = ls.limit("GET /merchants")
+	assert.False(t, limited)
+
+	path := filepath.Join(t.TempDir(), "limits.json")
+	for _, invalid := range []string{
+		`{"default": {"requests": 0, "per": "1s"}}`,
+		`{"default": {"requests": 1, "per": "soon"}}`,
+		`{"routes": {"/receipts/process": {"requests": 1, "per": "1s"}}}`,
+	} {
+		os.WriteFile(path, []byte(invalid), 0o600)
+		_, err := loadRateLimits(path)
+		assert.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+)
+
+// Struct definitions & constructors
+
+// Struct representing a single scoring rule
+// Rules see the whole receipt, including the optional fields (quantities, tax, discounts, payment method)
+type ScoringRule struct {
+	Name   string
+	Points func(r Receipt) int
+}
+
+// Struct representing the points awarded by a single rule
+type RulePoints struct {
+	Rule   string `json:"rule"`
+	Points int    `json:"points"`
+}
+
+// Scoring modes - control how the retailer and item description rules count characters
+const (
+	// legacy behavior - ASCII letters and digits only, description length in bytes
+	ScoringModeASCII = "ascii"
+	// letters and digits in any script, description length in runes
+	ScoringModeUnicode = "unicode"
+	// letters and digits in any script, description length in user-perceived characters (grapheme clusters)
+	ScoringModeGrapheme = "grapheme"
+)
+
+// Internal data
+
+// Scoring mode used by processPoints - set from the SCORING_MODE env variable on startup
+var scoringMode = ScoringModeASCII
+
+// Version of the scoring rules, reported by /version - bump it whenever a rule is added, removed or changes how it scores
+const rulesetVersion = "1"
+
+// Rules summed by processPoints, in the order they are listed in the README
+var scoringRules = []ScoringRule{
+	{Name: "retailerName", Points: retailerNamePoints},
+	{Name: "roundTotal", Points: roundTotalPoints},
+	{Name: "quarterTotal", Points: quarterTotalPoints},
+	{Name: "itemPairs", Points: itemPairsPoints},
+	{Name: "itemDescription", Points: itemDescriptionPoints},
+	{Name: "oddDay", Points: oddDayPoints},
+	{Name: "afternoonPurchase", Points: afternoonPurchasePoints},
+}
+
+// Internal functions - not exported
+
+// Check if a scoring mode is one of the supported modes
+func validScoringMode(mode string) bool {
+	return mode == ScoringModeASCII || mode == ScoringModeUnicode || mode == ScoringModeGrapheme
+}
+
+// Read the scoring mode from the environment - the legacy ASCII mode if SCORING_MODE is unset
+func scoringModeFromEnv() (string, error) {
+	raw := os.Getenv("SCORING_MODE")
+	if raw == "" {
+		return ScoringModeASCII, nil
+	}
+	mode := strings.ToLower(raw)
+	if !validScoringMode(mode) {
+		return "", fmt.Errorf("invalid SCORING_MODE %q - expected ascii, unicode or grapheme", raw)
+	}
+	return mode, nil
+}
+
+// Count the alphanumeric characters in the retailer name
+func countAlphanumeric(s string, mode string) int {
+	if mode == ScoringModeASCII {
+		// define regex for alphanumeric characters - referring to: https://gosamples.dev/remove-non-alphanumeric/
+		nonAlphaNumericRegex := regexp.MustCompile("[^a-zA-Z0-9]+")
+		// replace non alphanumeric characters with empty string and count what is left
+		return len(nonAlphaNumericRegex.ReplaceAllString(s, ""))
+	}
+	count := 0
+	for _, c := range s {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) {
+			count++
+		}
+	}
+	return count
+}
+
+// Length of the trimmed item description
+func descriptionLength(s string, mode string) int {
+	switch mode {
+	case ScoringModeUnicode:
+		return utf8.RuneCountInString(strings.TrimSpace(s))
+	case ScoringModeGrapheme:
+		return uniseg.GraphemeClusterCount(strings.TrimSpace(s))
+	default:
+		return len(strings.Trim(s, " "))
+	}
+}
+
+// Scoring rules - each assumes a valid receipt is passed in
+
+// 1 point for every alphanumeric character in the retailer name.
+func retailerNamePoints(r Receipt) int {
+	// count alphanumeric characters - which characters count depends on the scoring mode
+	return countAlphanumeric(r.Retailer, scoringMode)
+}
+
+// 50 points if the total is a round dollar amount with no cents.
+// what counts as round is configured per currency, compared in minor units to avoid float error
+func roundTotalPoints(r Receipt) int {
+	currency, _ := currencyRules(receiptCurrency(r))
+	total, _ := parseMinorUnits(r.Total, currency)
+	if isMultipleOf(total, currency.RoundStep) {
+		return 50
+	}
+	return 0
+}
+
+// 25 points if the total is a multiple of 0.25.
+// what counts as a quarter is configured per currency, compared in minor units to avoid float error
+func quarterTotalPoints(r Receipt) int {
+	currency, _ := currencyRules(receiptCurrency(r))
+	total, _ := parseMinorUnits(r.Total, currency)
+	if isMultipleOf(total, currency.QuarterStep) {
+		return 25
+	}
+	return 0
+}
+
+// 5 points for every two items on the receipt.
+// counts item lines, not quantities - a line with a quantity of 3 is one item
+func itemPairsPoints(r Receipt) int {
+	return (len(r.Items) / 2) * 5
+}
+
+// If the trimmed length of the item description is a multiple of 3, multiply the price by 0.2 and round up to the nearest integer. The result is the number of points earned.
+func itemDescriptionPoints(r Receipt) int {
+	currency, _ := currencyRules(receiptCurrency(r))
+	points := 0
+	for _, item := range r.Items {
+		// check if trimmmed length of item description is a multiple of 3 - bytes, runes or graphemes depending on the scoring mode
+		if descriptionLength(item.ShortDescription, scoringMode)%3 == 0 {
+			price, _ := strconv.ParseFloat(item.Price, 64)
+			// calculate points - on the price in USD, so points do not depend on the currency's magnitude
+			points += int(math.Ceil(toUSD(price, currency) * 0.2))
+		}
+	}
+	return points
+}
+
+// 6 points if the day in the purchase date is odd.
+// evaluated in the store's local time
+func oddDayPoints(r Receipt) int {
+	if localPurchaseTime(r).Day()%2 != 0 {
+		return 6
+	}
+	return 0
+}
+
+// 10 points if the time of purchase is after 2:00pm and before 4:00pm.
+// evaluated in the store's local time
+func afternoonPurchasePoints(r Receipt) int {
+	purchasedAt := localPurchaseTime(r)
+	minuteOfDay := purchasedAt.Hour()*60 + purchasedAt.Minute()
+	if minuteOfDay > 14*60 && minuteOfDay < 16*60 {
+		return 10
+	}
+	return 0
+}
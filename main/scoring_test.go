@@ -0,0 +1,110 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// receipt with a multibyte retailer and item descriptions
+var receipt_multibyte = Receipt{
+	Retailer:     "Café Müller",
+	PurchaseDate: "2022-03-20",
+	PurchaseTime: "10:00",
+	Items: []Item{
+		{ShortDescription: "Crème brûlée", Price: "5.00"}, // 12 runes, 15 bytes
+		{ShortDescription: "抹茶ラテ", Price: "20.00"},        // 4 runes, 12 bytes
+	},
+	Total: "25.10",
+}
+
+// set the scoring mode for the duration of a test
+func withScoringMode(t *testing.T, mode string) {
+	previous := scoringMode
+	scoringMode = mode
+	t.Cleanup(func() { scoringMode = previous })
+}
+
+func TestCountAlphanumeric(t *testing.T) {
+	assert.Equal(t, 8, countAlphanumeric("Café Müller", ScoringModeASCII))
+	assert.Equal(t, 10, countAlphanumeric("Café Müller", ScoringModeUnicode))
+	assert.Equal(t, 0, countAlphanumeric("セブンイレブン", ScoringModeASCII))
+	assert.Equal(t, 7, countAlphanumeric("セブンイレブン", ScoringModeUnicode))
+	assert.Equal(t, 14, countAlphanumeric("M&M Corner Market", ScoringModeUnicode))
+	// arabic-indic digits count as digits
+	assert.Equal(t, 5, countAlphanumeric("متجر ٧", ScoringModeGrapheme))
+}
+
+func TestDescriptionLength(t *testing.T) {
+	assert.Equal(t, 15, descriptionLength("  Crème brûlée ", ScoringModeASCII))
+	assert.Equal(t, 12, descriptionLength("  Crème brûlée ", ScoringModeUnicode))
+	assert.Equal(t, 12, descriptionLength("  Crème brûlée ", ScoringModeGrapheme))
+	// decomposed accents - e followed by a combining acute accent
+	assert.Equal(t, 5, descriptionLength("Café", ScoringModeUnicode))
+	assert.Equal(t, 4, descriptionLength("Café", ScoringModeGrapheme))
+	// ideographic space is trimmed in unicode modes
+	assert.Equal(t, 4, descriptionLength("　抹茶ラテ　", ScoringModeUnicode))
+}
+
+func TestDescriptionLength_Graphemes(t *testing.T) {
+	length := func(s string) int { return descriptionLength(s, ScoringModeGrapheme) }
+	assert.Equal(t, 0, length(""))
+	assert.Equal(t, 3, length("abc"))
+	// thumbs up with a skin tone modifier
+	assert.Equal(t, 1, length("\U0001F44D\U0001F3FD"))
+	// family emoji joined with zero width joiners
+	assert.Equal(t, 1, length("\U0001F468\u200d\U0001F469\u200d\U0001F467"))
+	// two flags made of regional indicator pairs
+	assert.Equal(t, 2, length("\U0001F1EF\U0001F1F5\U0001F1FA\U0001F1F8"))
+	// precomposed hangul syllables, and one spelled out in conjoining jamo
+	assert.Equal(t, 2, length("한국"))
+	assert.Equal(t, 1, length("\u1100\u1161"))
+	// a line break is a single character
+	assert.Equal(t, 3, length("a\r\nb"))
+}
+
+func TestProcessPoints_ScoringModes(t *testing.T) {
+	// ascii: 8 retailer + 5 item count + both descriptions (15 and 12 bytes) are multiples of 3
+	withScoringMode(t, ScoringModeASCII)
+	assert.Equal(t, 8+5+1+4, processPoints(receipt_multibyte))
+
+	// unicode: 10 retailer + 5 item count + only the 12 rune description is a multiple of 3
+	withScoringMode(t, ScoringModeUnicode)
+	assert.Equal(t, 10+5+1, processPoints(receipt_multibyte))
+
+	withScoringMode(t, ScoringModeGrapheme)
+	assert.Equal(t, 10+5+1, processPoints(receipt_multibyte))
+
+	// the example receipts score the same in every mode
+	for _, mode := range []string{ScoringModeASCII, ScoringModeUnicode, ScoringModeGrapheme} {
+		withScoringMode(t, mode)
+		assert.Equal(t, body_valid_2_pts, processPoints(Receipt{
+			Retailer:     "M&M Corner Market",
+			PurchaseDate: "2022-03-20",
+			PurchaseTime: "14:33",
+			Items: []Item{
+				{ShortDescription: "Gatorade", Price: "2.25"},
+				{ShortDescription: "Gatorade", Price: "2.25"},
+				{ShortDescription: "Gatorade", Price: "2.25"},
+				{ShortDescription: "Gatorade", Price: "2.25"},
+			},
+			Total: "9.00",
+		}))
+	}
+}
+
+func TestScoringModeFromEnv(t *testing.T) {
+	mode, err := scoringModeFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, ScoringModeASCII, mode)
+
+	t.Setenv("SCORING_MODE", "Unicode")
+	mode, err = scoringModeFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, ScoringModeUnicode, mode)
+
+	// a typo fails startup, rather than silently scoring in another mode
+	t.Setenv("SCORING_MODE", "graphemes")
+	_, err = scoringModeFromEnv()
+	assert.Error(t, err)
+}
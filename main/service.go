@@ -1,15 +1,24 @@
 package main
 
 import (
-	"math"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
 	"net/http"
-	"regexp"
-	"strconv"
-	"strings"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc"
 )
 
 // Struct definitions & constructors
@@ -19,21 +28,85 @@ type Receipt struct {
 	Retailer     string `json:"retailer"`
 	PurchaseDate string `json:"purchaseDate"`
 	PurchaseTime string `json:"purchaseTime"`
-	Items        []struct {
-		ShortDescription string `json:"shortDescription"`
-		Price            string `json:"price"`
-	} `json:"items"`
-	Total string `json:"total"`
+	Items        []Item `json:"items"`
+	Total        string `json:"total"`
+	// optional time zone the receipt was printed in - IANA name or UTC offset, defaults to the merchant's time zone
+	TimeZone string `json:"timeZone,omitempty"`
+	// optional ISO 4217 currency code of the total and prices - defaults to USD
+	Currency string `json:"currency,omitempty"`
+	// optional breakdown of the total - when subtotal is given, subtotal + tax - discounts must equal total
+	Subtotal  string     `json:"subtotal,omitempty"`
+	Tax       string     `json:"tax,omitempty"`
+	Discounts []Discount `json:"discounts,omitempty"`
+	// optional payment method - one of the values in paymentMethods
+	PaymentMethod string `json:"paymentMethod,omitempty"`
+	// canonical merchant the retailer resolved to - set during processing, empty if no match in the catalog
+	MerchantID string `json:"merchantId,omitempty"`
+}
+
+// Struct representing a single item on an inbound receipt
+type Item struct {
+	ShortDescription string `json:"shortDescription"`
+	// total price paid for the item line
+	Price string `json:"price"`
+	// optional quantity (may be fractional for weighed items) and unit price - price must equal quantity * unit price
+	Quantity  string `json:"quantity,omitempty"`
+	UnitPrice string `json:"unitPrice,omitempty"`
+	// optional product identifiers - the UPC must have a valid check digit
+	SKU string `json:"sku,omitempty"`
+	UPC string `json:"upc,omitempty"`
+	// product category, as given by the retailer - only v2 receipts carry one, so it is kept out of the v1 JSON
+	category string
+}
+
+// Struct representing a discount or coupon applied to the receipt total
+type Discount struct {
+	Description string `json:"description"`
+	// amount taken off the total, as a positive amount
+	Amount string `json:"amount"`
+}
+
+// Struct representing why a receipt is invalid - the field (as a JSON path, like items[0].price) and the reason
+type ValidationError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+func (e *ValidationError) Error() string {
+	return e.Field + " " + e.Reason
 }
 
 // Struct representing Receipt Points pair - used for storing receipts/points pairs
 type ReceiptPoints struct {
 	Receipt Receipt `json:"receipt"`
 	Points  int     `json:"points"`
+	// points awarded by each scoring rule, as scored
+	Breakdown []RulePoints `json:"breakdown,omitempty"`
+	// instant of purchase, resolved from the receipt's date, time and time zone
+	PurchasedAt time.Time `json:"purchasedAt"`
+	// uploaded image the receipt was extracted from, if any
+	ImageID string `json:"imageId,omitempty"`
+	// version of the merchant catalog its retailer was resolved against
+	CatalogVersion int `json:"catalogVersion"`
+	// why the receipt was rejected, if it was rejected by validation
+	Rejection *ValidationError `json:"rejection,omitempty"`
+	// processing status - pending until an asynchronous receipt has been validated and scored, voided once cancelled
+	Status string `json:"status"`
+	// who submitted the receipt
+	Owner
 }
 
+// Receipt statuses
+const (
+	StatusPending   = "pending"
+	StatusProcessed = "processed"
+	StatusRejected  = "rejected"
+	StatusVoided    = "voided"
+)
+
 // Struct representing Receipts - internal storage of receipts/points
 type Receipts struct {
+	mu sync.RWMutex
 	// store a map of receipts, points pairs accessed via ID
 	ReceiptsMap map[string]ReceiptPoints `json:"receipts"`
 }
@@ -45,133 +118,303 @@ func NewReceipts() *Receipts {
 	return &rs
 }
 
+// Get the receipt/points pair with the given ID
+func (rs *Receipts) get(id string) (ReceiptPoints, bool) {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	rp, present := rs.ReceiptsMap[id]
+	return rp, present
+}
+
+// Store a receipt/points pair under the given ID
+func (rs *Receipts) put(id string, rp ReceiptPoints) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.ReceiptsMap[id] = rp
+}
+
+// Update the receipt/points pair with the given ID in place - returns false if there is none
+func (rs *Receipts) update(id string, modify func(rp *ReceiptPoints)) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rp, present := rs.ReceiptsMap[id]
+	if !present {
+		return false
+	}
+	modify(&rp)
+	rs.ReceiptsMap[id] = rp
+	return true
+}
+
+// Remove the receipt/points pair with the given ID
+func (rs *Receipts) remove(id string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	delete(rs.ReceiptsMap, id)
+}
+
+// Count the receipts stored
+func (rs *Receipts) size() int {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return len(rs.ReceiptsMap)
+}
+
+// Copy every receipt/points pair - for listing without holding the lock
+func (rs *Receipts) snapshot() map[string]ReceiptPoints {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	copied := make(map[string]ReceiptPoints, len(rs.ReceiptsMap))
+	for id, rp := range rs.ReceiptsMap {
+		copied[id] = rp
+	}
+	return copied
+}
+
 // Internal data
 
 // Global receipts object - in place of persisting data
 var rs = NewReceipts() // pointer to Receipts object
 
+// How long shutdown waits for requests, queued receipts and webhook deliveries to finish
+const shutdownTimeout = 25 * time.Second
+
 // Internal functions - not exported
 
 // Setup router
 func setupRouter() *gin.Engine {
-	r := gin.Default()
-	// define routes
-	r.POST("/receipts/process", processReceipt)
-	r.GET("/receipts/:id/points", getPoints)
+	r := gin.New()
+	// validated by trustedProxiesFromEnv on startup
+	r.SetTrustedProxies(trustedProxies)
+	// identify, trace and log every request, recover from panics, count and time requests, and cap request bodies
+	r.Use(assignRequestID(), traceRequest(), logRequest(), recoverPanic(), observeRequest(), limitBody())
+	// define routes - each guarded by the scope it needs, once API keys are configured
+	submit, read, admin := requireScope(ScopeSubmit), requireScope(ScopeRead), requireScope(ScopeAdmin)
+	// and rate limited per caller, once limits are configured
+	limit := rateLimit()
+	// v1 - the original receipt contract in api.yml, kept compatible
+	v1 := r.Group("/receipts")
+	v1.POST("/process", submit, limit, requireSignature(), processReceipt)
+	v1.POST("/process/text", submit, limit, requireSignature(), processTextReceipt)
+	v1.POST("/process/image", submit, limit, requireSignature(), processImageReceipt)
+	v1.GET("/events", admin, limit, streamReceiptEvents)
+	v1.GET("/:id/points", read, limit, getPoints)
+	v1.POST("/:id/void", submit, limit, voidReceipt)
+	// v2 - numeric money, richer items and structured errors, sharing storage with v1
+	v2 := r.Group("/v2/receipts")
+	v2.POST("/process", submit, limit, requireSignature(), processReceiptV2)
+	v2.GET("/:id", read, limit, getReceiptV2)
+	v2.GET("/:id/points", read, limit, getPointsV2)
+	r.GET("/images/:id", read, limit, getImage)
+	// GraphQL takes either scope - queries check for read, and the processReceipt mutation for submit
+	graph := requireScope(ScopeRead, ScopeSubmit)
+	r.GET("/graphql", graph, limit, serveGraphQL)
+	r.POST("/graphql", graph, limit, acceptSignature(), serveGraphQL)
+	// merchant catalog admin routes
+	r.GET("/merchants", admin, limit, listMerchants)
+	r.POST("/merchants", admin, limit, createMerchant)
+	r.GET("/merchants/:id", admin, limit, getMerchant)
+	r.POST("/merchants/:id/aliases", admin, limit, addMerchantAlias)
+	r.DELETE("/merchants/:id/aliases/:alias", admin, limit, removeMerchantAlias)
+	// webhook subscription routes - callers see their own subscriptions, admins every one
+	r.GET("/webhooks", submit, limit, listSubscriptions)
+	r.POST("/webhooks", submit, limit, createSubscription)
+	r.DELETE("/webhooks/:id", submit, limit, deleteSubscription)
+	r.GET("/webhooks/:id/deliveries", submit, limit, listDeliveries)
+	r.GET("/webhooks/dead-letters", submit, limit, listDeadLetters)
+	// orchestrator probes - public, and not rate limited so probing cannot fail a healthy instance
+	r.GET("/healthz", getHealth)
+	r.GET("/readyz", getReadiness)
+	// build info - public
+	r.GET("/version", limit, getVersion)
+	// Prometheus metrics - public, for scrapers
+	r.GET("/metrics", limit, getMetrics)
+	// the API spec and docs - public
+	r.GET("/openapi.yaml", limit, getOpenAPIYAML)
+	r.GET("/openapi.json", limit, getOpenAPIJSON)
+	r.GET("/docs", limit, getDocs)
+	r.GET("/docs/:asset", limit, getDocsAsset)
 	return r
 }
 
 // Validate receipt - make sure all fields are populated and valid
 // Any invalid fields will result in an invalid receipt
 func validateReceipt(r Receipt) bool {
+	return checkReceipt(r) == nil
+}
+
+// Check receipt - the checks behind validateReceipt
+// Returns why the receipt is invalid - the first invalid field found - or nil
+func checkReceipt(r Receipt) *ValidationError {
 	// check if all fields populated
-	if r.Retailer == "" || r.Total == "" || r.PurchaseDate == "" || r.PurchaseTime == "" || r.Items == nil {
-		return false
+	switch {
+	case r.Retailer == "":
+		return &ValidationError{Field: "retailer", Reason: "is required"}
+	case r.PurchaseDate == "":
+		return &ValidationError{Field: "purchaseDate", Reason: "is required"}
+	case r.PurchaseTime == "":
+		return &ValidationError{Field: "purchaseTime", Reason: "is required"}
+	case r.Items == nil:
+		return &ValidationError{Field: "items", Reason: "is required"}
+	case r.Total == "":
+		return &ValidationError{Field: "total", Reason: "is required"}
 	}
 	// check if purchase date is valid
 	_, err := time.Parse("2006-01-02", r.PurchaseDate)
 	if err != nil {
-		return false
+		return &ValidationError{Field: "purchaseDate", Reason: "must be a date formatted YYYY-MM-DD"}
 	}
 	// check if purchase time is valid
 	_, err = time.Parse("15:04", r.PurchaseTime)
 	if err != nil {
-		return false
+		return &ValidationError{Field: "purchaseTime", Reason: "must be a 24-hour time formatted HH:MM"}
 	}
-	// check if total is valid
-	_, err = strconv.ParseFloat(r.Total, 64)
-	if err != nil {
-		return false
+	// check if time zone is valid, if given
+	if r.TimeZone != "" {
+		if _, err = parseTimeZone(r.TimeZone); err != nil {
+			return &ValidationError{Field: "timeZone", Reason: "must be an IANA time zone name or a UTC offset"}
+		}
 	}
-	// check if total is negative
-	total, _ := strconv.ParseFloat(r.Total, 64)
-	if total < 0 {
-		return false
+	// check that the purchase is not in the future
+	if isFuturePurchase(r) {
+		return &ValidationError{Field: "purchaseDate", Reason: "the purchase is in the future"}
+	}
+	// check if currency is supported
+	currency, present := currencyRules(receiptCurrency(r))
+	if !present {
+		return &ValidationError{Field: "currency", Reason: "is not a supported currency"}
+	}
+	// check if total is valid - a non-negative amount with no more decimal places than the currency allows
+	_, err = parseMinorUnits(r.Total, currency)
+	if err != nil {
+		return &ValidationError{Field: "total", Reason: err.Error()}
 	}
 	// check if r.Items meets minimum length requirement of 1
 	if r.Items != nil && len(r.Items) < 1 {
-		return false
+		return &ValidationError{Field: "items", Reason: "must have at least one item"}
+	}
+	// check the receipt is within the request limits - text and image receipts are not bound from JSON
+	if invalid := requestLimits.checkReceipt(r); invalid != nil {
+		return invalid
 	}
 	// check if bad data in r.Items
-	for _, item := range r.Items {
+	for i, item := range r.Items {
+		field := fmt.Sprintf("items[%d]", i)
 		// check for empty vals
-		if item.ShortDescription == "" || item.Price == "" {
-			return false
+		if item.ShortDescription == "" {
+			return &ValidationError{Field: field + ".shortDescription", Reason: "is required"}
 		}
-		// check for invalid price
-		price, err := strconv.ParseFloat(item.Price, 64)
+		if item.Price == "" {
+			return &ValidationError{Field: field + ".price", Reason: "is required"}
+		}
+		// check for invalid or negative price
+		_, err := parseMinorUnits(item.Price, currency)
 		if err != nil {
-			return false
+			return &ValidationError{Field: field + ".price", Reason: err.Error()}
 		}
-		// check that price is above 0
-		if price < 0 {
-			return false
+		// check optional quantity, unit price and product identifiers
+		if invalid := checkItemDetails(item, currency); invalid != nil {
+			return &ValidationError{Field: field + "." + invalid.Field, Reason: invalid.Reason}
 		}
 
 	}
-	return true
+	// check optional subtotal, tax, discounts and payment method
+	return checkReceiptDetails(r, currency)
+}
+
+// Evaluate receipt - resolve its merchant, validate it and process points
+// Returns the ReceiptPoints object to store, or false if the receipt is invalid
+// Validation and scoring are traced as children of the span in ctx, if any
+func evaluateReceipt(ctx context.Context, r Receipt) (ReceiptPoints, bool) {
+	// resolve retailer to a canonical merchant - ignore any merchant ID supplied by the client
+	// resolved before validation, since the merchant's time zone is used to reject future-dated purchases
+	r.MerchantID = ""
+	m, catalogVersion, present := merchants.resolve(r.Retailer)
+	if present {
+		r.MerchantID = m.ID
+	}
+
+	// validate receipt
+	_, span := startSpan(ctx, "validateReceipt", attribute.Int("receipt.items", len(r.Items)))
+	invalid := checkReceipt(r)
+	if invalid != nil {
+		// the field only - the reason may quote the client's input
+		span.SetAttributes(attribute.String("receipt.invalid_field", invalid.Field))
+		span.SetStatus(codes.Error, "invalid receipt")
+	}
+	span.End()
+	if invalid != nil {
+		rp := ReceiptPoints{Receipt: r, Status: StatusRejected, Rejection: invalid, CatalogVersion: catalogVersion}
+		metrics.observeReceipt(rp)
+		logging.logRejection(ctx, rp)
+		return rp, false
+	}
+
+	// process points - keeping what each rule awarded
+	breakdown := pointsBreakdown(ctx, r)
+	points := 0
+	for _, rp := range breakdown {
+		points += rp.Points
+	}
+
+	purchasedAt, _ := purchaseInstant(r)
+	rp := ReceiptPoints{Receipt: r, Points: points, Breakdown: breakdown, PurchasedAt: purchasedAt, Status: StatusProcessed, CatalogVersion: catalogVersion}
+	metrics.observeReceipt(rp)
+	return rp, true
+}
+
+// Submit receipt - evaluate it and store it under a new ID
+// Shared by every route that accepts receipts synchronously. Returns the new receipt ID, or a *ValidationError if the receipt is invalid
+// The receipt is tagged with the caller attached to ctx, if any
+func submitReceipt(ctx context.Context, r Receipt) (string, error) {
+	return submitReceiptWithImage(ctx, r, "")
+}
+
+// Submit a receipt extracted from an uploaded image - linked to the image before it is stored and published
+func submitReceiptWithImage(ctx context.Context, r Receipt, imageID string) (string, error) {
+	rp, valid := evaluateReceipt(ctx, r)
+	if !valid {
+		return "", rp.Rejection
+	}
+	rp.Owner = ownerFrom(ctx)
+	rp.ImageID = imageID
+
+	// generate ID
+	id := uuid.New().String()
+
+	// add ReceiptPoints object to receipts map
+	_, span := startSpan(ctx, "store", attribute.String("receipt.id", id))
+	rs.put(id, rp)
+	span.End()
+	publishReceiptEvent(EventReceiptScored, id, rp)
+	return id, nil
 }
 
 // Calculate points for receipt - based on ruleset given
 // Assumes a valid receipt is passed in
 func processPoints(r Receipt) int {
-	// 1 point for every alphanumeric character in the retailer name.
-	// define regex for alphanumeric characters - referring to: https://gosamples.dev/remove-non-alphanumeric/
-	nonAlphaNumericRegex := regexp.MustCompile("[^a-zA-Z0-9]+")
-	// replace non alphanumeric characters with empty string
-	retailer := nonAlphaNumericRegex.ReplaceAllString(r.Retailer, "")
-	// count alphanumeric characters
-	retailerPoints := len(retailer)
-
-	// 50 points if the total is a round dollar amount with no cents. 25 points if the total is a multiple of 0.25
-	total, _ := strconv.ParseFloat(r.Total, 64)
-	// check if total is a round dollar amount
-	totalPoints := 0
-	if total == float64(int(total)) { // check if total is a round dollar amount
-		totalPoints += 50
-	}
-	if total == float64(int(total*4))/4 { // check if total is a multiple of 0.25
-		totalPoints += 25
-	}
-
-	// 5 points for every two items on the receipt.
-	itemCount := len(r.Items)
-	itemCountPoints := (itemCount / 2) * 5
-
-	// If the trimmed length of the item description is a multiple of 3, multiply the price by 0.2 and round up to the nearest integer. The result is the number of points earned.
-	itemPoints := 0
-	for _, item := range r.Items {
-		itemDesc := strings.Trim(item.ShortDescription, " ")
-		// check if trimmmed length of item description is a multiple of 3
-		if len(itemDesc)%3 == 0 {
-			price, _ := strconv.ParseFloat(item.Price, 64)
-			// calculate points
-			itemPoints += int(math.Ceil(price * 0.2)) // add to item points for each item
-		}
-	}
-
-	// 6 points if the day in the purchase date is odd.
-	// parse purchase date to int
-	datePoints := 0
-	// define a non numeric regex
-	nonNumericRegex := regexp.MustCompile("[^0-9]+")
-	// replace non numeric characters with empty string
-	purchaseDate := nonNumericRegex.ReplaceAllString(r.PurchaseDate, "")
-	purchaseDateInt, _ := strconv.Atoi(purchaseDate)
-	// check if day is odd
-	if purchaseDateInt%2 != 0 {
-		datePoints += 6
-	}
-
-	// 10 points if the time of purchase is after 2:00pm and before 4:00pm
-	timePoints := 0
-	purchaseTime := nonNumericRegex.ReplaceAllString(r.PurchaseTime, "")
-	purchaseTimeInt, _ := strconv.Atoi(purchaseTime)
-	// check if time is between 2:00pm and 4:00pm (after and before 1400 and 1600)
-	if purchaseTimeInt > 1400 && purchaseTimeInt < 1600 {
-		timePoints += 10
-	}
-	return retailerPoints + totalPoints + itemCountPoints + itemPoints + datePoints + timePoints
+	points := 0
+	// sum the points awarded by each rule - see scoringRules
+	for _, rp := range pointsBreakdown(context.Background(), r) {
+		points += rp.Points
+	}
+	return points
+}
+
+// Calculate the points awarded by each rule - processPoints is their sum
+// Each rule is traced as a child of a processPoints span. Assumes a valid receipt is passed in
+func pointsBreakdown(ctx context.Context, r Receipt) []RulePoints {
+	ctx, span := startSpan(ctx, "processPoints", attribute.String("scoring.mode", scoringMode))
+	defer span.End()
+	breakdown := make([]RulePoints, 0, len(scoringRules))
+	for _, rule := range scoringRules {
+		_, ruleSpan := startSpan(ctx, "rule "+rule.Name)
+		points := rule.Points(r)
+		ruleSpan.SetAttributes(attribute.Int("scoring.points", points))
+		ruleSpan.End()
+		breakdown = append(breakdown, RulePoints{Rule: rule.Name, Points: points})
+	}
+	return breakdown
 }
 
 // Internal Route Functions
@@ -181,30 +424,38 @@ func processPoints(r Receipt) int {
 // Payload: Receipt JSON
 // Response: JSON containing an id for the receipt.
 // Description: Takes in a JSON receipt (see example in the example directory) and returns a JSON object with an ID generated by your code.
+// With ?async=true (or "Prefer: respond-async") the receipt is queued and 202 is returned with the ID and a pending status.
 func processReceipt(c *gin.Context) {
 	var r Receipt // to store inbound receipt
 
 	// bind JSON to receipt object - upon error, return bad request
 	// unmarshaling JSON to struct, type checking for all fields
-	if err := c.ShouldBindJSON(&r); err != nil {
+	if err := bindJSON(c, &r); err != nil {
+		if errors.Is(err, errBodyTooLarge) {
+			tooLarge(c)
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"description": "The receipt is invalid"})
 		return
 	}
 
-	// validate receipt
-	if !validateReceipt(r) {
-		c.JSON(http.StatusBadRequest, gin.H{"description": "The receipt is invalid"})
+	// asynchronous mode - queue the receipt and return its ID straight away
+	if wantsAsync(c) {
+		id, queued := enqueueReceipt(c.Request.Context(), ReceiptPoints{Receipt: r}, func(ctx context.Context) (Receipt, error) { return r, nil })
+		if !queued {
+			queueFull(c)
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"id": id, "status": StatusPending})
 		return
 	}
 
-	// process points
-	points := processPoints(r)
-
-	// generate ID
-	id := uuid.New().String()
-
-	// create a ReceiptPoints object and add to receipts map
-	(*rs).ReceiptsMap[id] = ReceiptPoints{r, points}
+	// validate, score and store receipt
+	id, err := submitReceipt(c.Request.Context(), r)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"description": "The receipt is invalid"})
+		return
+	}
 
 	// return status created and receipt ID
 	c.JSON(http.StatusOK, gin.H{"id": id})
@@ -214,21 +465,230 @@ func processReceipt(c *gin.Context) {
 // Method: GET
 // Response: A JSON object containing the number of points awarded.
 // Description: A simple Getter endpoint that looks up the receipt by the ID and returns an object specifying the points awarded.
+// v1 keeps its original contract - receipts without points (pending, rejected or voided) are errors here, and their status is read from /v2/receipts/{id}/points.
 func getPoints(c *gin.Context) {
 	// get ID
 	id := c.Param("id")
 	// get receipt object with ID from receipts
-	rp, present := (*rs).ReceiptsMap[id]
-	if !present {
+	rp, present := rs.get(id)
+	// other clients' receipts are reported as missing, so their IDs cannot be probed
+	if !present || !canAccess(c.Request.Context(), rp.Owner) {
 		c.JSON(http.StatusNotFound, gin.H{"description": "No receipt found for that id"})
 		return
-	} else {
+	}
+	switch rp.Status {
+	case StatusPending:
+		c.JSON(http.StatusNotFound, gin.H{"description": "The receipt is still being processed"})
+	case StatusRejected:
+		c.JSON(http.StatusBadRequest, gin.H{"description": "The receipt is invalid"})
+	case StatusVoided:
+		c.JSON(http.StatusNotFound, gin.H{"description": "The receipt was voided"})
+	default:
 		c.JSON(http.StatusOK, gin.H{"points": rp.Points})
 	}
 }
 
+// Path: /receipts/{id}/void
+// Method: POST
+// Response: A JSON object containing the receipt's id and its new status.
+// Description: Voids a processed receipt - its points no longer count. Only processed receipts can be voided.
+func voidReceipt(c *gin.Context) {
+	id := c.Param("id")
+	var voided ReceiptPoints
+	conflict, forbidden := false, false
+	present := rs.update(id, func(rp *ReceiptPoints) {
+		if !canAccess(c.Request.Context(), rp.Owner) {
+			forbidden = true
+			return
+		}
+		if rp.Status != StatusProcessed {
+			conflict = true
+			return
+		}
+		rp.Status = StatusVoided
+		voided = *rp
+	})
+	if !present || forbidden {
+		c.JSON(http.StatusNotFound, gin.H{"description": "No receipt found for that id"})
+		return
+	}
+	if conflict {
+		c.JSON(http.StatusConflict, gin.H{"description": "Only processed receipts can be voided"})
+		return
+	}
+	publishReceiptEvent(EventReceiptVoided, id, voided)
+	c.JSON(http.StatusOK, gin.H{"id": id, "status": StatusVoided})
+}
+
 // main function - start server
 func main() {
+	serve := flag.String("serve", "http", "listeners to run: http, grpc or both")
+	flag.Parse()
+	// set the log level, format and redaction
+	l, err := loggingFromEnv(os.Stdout)
+	if err != nil {
+		log.Fatalf("failed to configure logging: %v", err)
+	}
+	logging = l
+	mode, err := scoringModeFromEnv()
+	if err != nil {
+		log.Fatalf("failed to configure scoring mode: %v", err)
+	}
+	scoringMode = mode
+	// replace the built-in currency table, if configured
+	if path := os.Getenv("CURRENCY_TABLE"); path != "" {
+		table, err := loadCurrencyTable(path)
+		if err != nil {
+			log.Fatalf("failed to load currency table: %v", err)
+		}
+		currencies = table
+	}
+	// limit the image store, if configured
+	store, err := imagesFromEnv()
+	if err != nil {
+		log.Fatalf("failed to configure image store: %v", err)
+	}
+	images = store
+	// select the OCR provider for image uploads, if configured
+	provider, err := ocrProviderFromEnv()
+	if err != nil {
+		log.Fatalf("failed to configure OCR provider: %v", err)
+	}
+	ocr = provider
+	// export traces, if configured
+	tp, err := tracerProviderFromEnv(context.Background())
+	if err != nil {
+		log.Fatalf("failed to configure tracing: %v", err)
+	}
+	if tp != nil {
+		tracerProvider = tp
+	}
+	// size the processing queue and select the default processing mode
+	if err := configureQueueFromEnv(); err != nil {
+		log.Fatalf("failed to configure queue: %v", err)
+	}
+	// deliver webhooks to private addresses too, if configured
+	if err := configureWebhooksFromEnv(); err != nil {
+		log.Fatalf("failed to configure webhooks: %v", err)
+	}
+	// turn on API key authentication, if configured
+	if path := os.Getenv("API_KEYS_FILE"); path != "" {
+		keys, err := loadAPIKeys(path)
+		if err != nil {
+			log.Fatalf("failed to load API keys: %v", err)
+		}
+		apiKeys = keys
+	}
+	// accept end users' bearer tokens, if configured
+	verifier, err := jwtVerifierFromEnv()
+	if err != nil {
+		log.Fatalf("failed to load JWKS: %v", err)
+	}
+	jwtVerifier = verifier
+	// override the request size and shape limits, if configured
+	limits, err := requestLimitsFromEnv()
+	if err != nil {
+		log.Fatalf("failed to configure request limits: %v", err)
+	}
+	requestLimits = limits
+	// limit callers' request rates, if configured
+	if path := os.Getenv("RATE_LIMITS_FILE"); path != "" {
+		limits, err := loadRateLimits(path)
+		if err != nil {
+			log.Fatalf("failed to load rate limits: %v", err)
+		}
+		rateLimits = limits
+	}
+	// trust X-Forwarded-For from these proxies only
+	proxies, err := trustedProxiesFromEnv()
+	if err != nil {
+		log.Fatalf("failed to configure trusted proxies: %v", err)
+	}
+	trustedProxies = proxies
+	// check partners' request signatures, if configured
+	if path := os.Getenv("SIGNING_KEYS_FILE"); path != "" {
+		keys, err := loadSigningKeys(path)
+		if err != nil {
+			log.Fatalf("failed to load signing keys: %v", err)
+		}
+		skew, err := signatureSkewFromEnv()
+		if err != nil {
+			log.Fatalf("failed to configure signature skew: %v", err)
+		}
+		keys.skew = skew
+		signing = keys
+	}
 	r := setupRouter()
-	r.Run() // listen and serve on default port 8080 - otherwise port defined in env variable PORT
+	if *serve != "http" && *serve != "grpc" && *serve != "both" {
+		log.Fatalf("unknown -serve value %q - expected http, grpc or both", *serve)
+	}
+	// start the workers processing queued receipts and delivering webhooks
+	receiptQueue.start()
+	webhooks.start(webhookWorkers)
+
+	// serve until a listener fails, or the process is asked to stop
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	failed := make(chan error, 2)
+	var httpServer *http.Server
+	var grpcServer *grpc.Server
+	if *serve != "grpc" {
+		// listen and serve on default port 8080 - otherwise port defined in env variable PORT
+		httpServer = &http.Server{Addr: httpAddr(), Handler: r}
+		go func() { failed <- httpServer.ListenAndServe() }()
+	}
+	if *serve != "http" {
+		// listen and serve on default port 9090 - otherwise port defined in env variable GRPC_PORT
+		grpcServer = newGRPCServer()
+		go func() { failed <- serveGRPC(grpcServer) }()
+	}
+	// startup is complete - readiness passes from here until shutdown
+	setLifecycle(lifecycleServing)
+	select {
+	case err := <-failed:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+	shutdown(httpServer, grpcServer, tp)
+}
+
+// Address the HTTP API listens on - PORT, or 8080 by default
+func httpAddr() string {
+	if port := os.Getenv("PORT"); port != "" {
+		return ":" + port
+	}
+	return ":8080"
+}
+
+// Shut down gracefully - stop taking requests, then finish the queued receipts, webhook deliveries and trace exports
+// Work still unfinished after shutdownTimeout is abandoned. Either server, and the tracer provider, may be nil
+func shutdown(httpServer *http.Server, grpcServer *grpc.Server, tp *sdktrace.TracerProvider) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	logger := logging.logger
+	logger.Info().Msg("shutting down")
+	// fail readiness first, so no new traffic is sent while draining
+	setLifecycle(lifecycleDraining)
+	if httpServer != nil {
+		if err := httpServer.Shutdown(ctx); err != nil {
+			logger.Warn().Err(err).Msg("requests left unfinished")
+		}
+	}
+	if grpcServer != nil {
+		stopGRPC(ctx, grpcServer)
+	}
+	// the servers are stopped, so no more receipts are queued
+	if err := receiptQueue.drain(ctx); err != nil {
+		logger.Warn().Err(err).Int("jobs", len(receiptQueue.jobs)).Msg("queued receipts left unprocessed")
+	}
+	// and once the queue is drained, no more events are published
+	if err := webhooks.drain(ctx); err != nil {
+		logger.Warn().Err(err).Int("deliveries", len(webhooks.pending)).Msg("webhook deliveries left unfinished")
+	}
+	if tp != nil {
+		if err := tp.Shutdown(ctx); err != nil {
+			logger.Warn().Err(err).Msg("spans left unexported")
+		}
+	}
+	logger.Info().Msg("shut down")
 }
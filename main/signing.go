@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joelyoshiya/Fetch_Rewards_Backend_Coding_Challenge/receiptpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Struct definitions & constructors
+
+// Struct representing a client's request signing secret
+type SigningKey struct {
+	// name of the client - matches its API key's name when API keys are on
+	Name   string `json:"name"`
+	Secret string `json:"secret"`
+}
+
+// Struct representing RequestSigning - the clients' signing secrets and the nonces already seen
+type RequestSigning struct {
+	mu sync.Mutex
+	// store a map of secrets accessed via client name
+	SecretsMap map[string]string `json:"secrets"`
+	// nonces seen, by "<client>:<nonce>", until they fall outside the skew window
+	nonces map[string]time.Time
+	// when expired nonces were last dropped
+	swept time.Time
+	// how far a signature's timestamp may be from now
+	skew time.Duration
+}
+
+// Constructor for RequestSigning
+func NewRequestSigning() *RequestSigning {
+	var s RequestSigning
+	s.SecretsMap = make(map[string]string)
+	s.nonces = make(map[string]time.Time)
+	s.skew = defaultSignatureSkew
+	return &s
+}
+
+// Internal data
+
+// Global request signing object - signatures are not checked until a secret is added
+var signing = NewRequestSigning() // pointer to RequestSigning object
+
+// Headers a signed request carries
+const (
+	signatureKeyHeader       = "X-Signature-Key"
+	signatureTimestampHeader = "X-Signature-Timestamp"
+	signatureNonceHeader     = "X-Signature-Nonce"
+	signatureHeader          = "X-Signature"
+)
+
+// How far a signature's timestamp may be from the server's clock, either way
+const defaultSignatureSkew = 5 * time.Minute
+
+// Longest nonce accepted, to bound the nonce cache
+const maxNonceLength = 128
+
+// How often expired nonces are dropped
+const nonceSweepInterval = time.Minute
+
+// context key marking a request whose signature was verified
+type signedKey struct{}
+
+// Internal functions - not exported
+
+// Add a client's secret
+func (s *RequestSigning) add(k SigningKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.SecretsMap[k.Name] = k.Secret
+}
+
+// Look up a client's secret
+func (s *RequestSigning) secret(name string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret, present := s.SecretsMap[name]
+	return secret, present
+}
+
+// Check if a caller must sign what it submits - clients with a secret must
+func (s *RequestSigning) mustSign(p *Principal) bool {
+	if p == nil || p.ClientID == "" {
+		return false
+	}
+	_, present := s.secret(p.ClientID)
+	return present
+}
+
+// Check if signing is on - it is once any secret is configured
+func (s *RequestSigning) enabled() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.SecretsMap) > 0
+}
+
+// Record a client's nonce - false if it was already used inside the skew window
+func (s *RequestSigning) remember(name string, nonce string, at time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(at)
+	k := name + ":" + nonce
+	if expiry, seen := s.nonces[k]; seen && !at.After(expiry) {
+		return false
+	}
+	// kept past the last moment its timestamp could still be accepted - after that a replay fails the window check
+	s.nonces[k] = at.Add(2 * s.skew)
+	return true
+}
+
+// Drop the nonces that have expired, at most once per interval - so the cache holds about a window's worth
+// Callers must hold the lock
+func (s *RequestSigning) sweep(at time.Time) {
+	if at.Sub(s.swept) < nonceSweepInterval {
+		return
+	}
+	s.swept = at
+	for k, expiry := range s.nonces {
+		if at.After(expiry) {
+			delete(s.nonces, k)
+		}
+	}
+}
+
+// Load signing secrets from a JSON file - a list of {name, secret}
+func loadSigningKeys(path string) (*RequestSigning, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []SigningKey
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	s := NewRequestSigning()
+	for _, k := range list {
+		if k.Name == "" || len(k.Secret) < 32 {
+			return nil, fmt.Errorf("invalid signing key %q - needs a name and a secret of at least 32 characters", k.Name)
+		}
+		s.add(k)
+	}
+	return s, nil
+}
+
+// Sign a request - hex HMAC-SHA256 over "<method>\n<path and query>\n<timestamp>\n<nonce>\n<body>"
+func signRequest(secret string, method string, path string, timestamp string, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + path + "\n" + timestamp + "\n" + nonce + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Check a request's signature - a description of the problem if it is rejected
+// Clients with a secret must sign; other clients may only sign with their own secret
+func (s *RequestSigning) verify(req *http.Request, p *Principal, at time.Time) string {
+	name := req.Header.Get(signatureKeyHeader)
+	if name == "" {
+		if s.mustSign(p) {
+			return "The request must be signed"
+		}
+		return ""
+	}
+	if p != nil && p.ClientID != "" && p.ClientID != name {
+		return "The request is signed with another client's key"
+	}
+	secret, present := s.secret(name)
+	if !present {
+		return "The signing key is unknown"
+	}
+	timestamp, nonce := req.Header.Get(signatureTimestampHeader), req.Header.Get(signatureNonceHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "The signature timestamp must be in Unix seconds"
+	}
+	if skew := at.Sub(time.Unix(seconds, 0)); skew > s.skew || skew < -s.skew {
+		return "The signature timestamp is outside the allowed window"
+	}
+	if nonce == "" || len(nonce) > maxNonceLength {
+		return fmt.Sprintf("The signature nonce must be 1 to %d characters", maxNonceLength)
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return "The request body could not be read"
+	}
+	// restore the body for the handler
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	expected := signRequest(secret, req.Method, req.URL.RequestURI(), timestamp, nonce, body)
+	if !hmac.Equal([]byte(strings.TrimPrefix(req.Header.Get(signatureHeader), "sha256=")), []byte(expected)) {
+		return "The signature does not match"
+	}
+	// only remember nonces of valid signatures, so forged requests cannot burn a client's nonces
+	if !s.remember(name, nonce, at) {
+		return "The request has already been processed"
+	}
+	return ""
+}
+
+// Mark a request's signature as verified
+func withSigned(ctx context.Context) context.Context {
+	return context.WithValue(ctx, signedKey{}, true)
+}
+
+// Check if a request's signature was verified
+func signedFrom(ctx context.Context) bool {
+	signed, _ := ctx.Value(signedKey{}).(bool)
+	return signed
+}
+
+// Check a submission may go ahead unsigned - a description of the problem if not
+// For routes that cannot require a signature up front, like POST /graphql, which also carries queries
+func unsignedSubmission(ctx context.Context) string {
+	if signedFrom(ctx) || !signing.mustSign(principalFrom(ctx)) {
+		return ""
+	}
+	return "The request must be signed"
+}
+
+// Middleware checking request signatures on the routes that submit receipts, once signing is on
+// Runs after requireScope, so an API key's client can be held to its own secret
+func requireSignature() gin.HandlerFunc {
+	return checkSignature(true)
+}
+
+// Middleware checking request signatures when present, once signing is on
+// Unsigned requests go through - handlers that submit receipts check unsignedSubmission
+func acceptSignature() gin.HandlerFunc {
+	return checkSignature(false)
+}
+
+// Middleware checking request signatures - required of clients with a secret, or only checked when present
+func checkSignature(required bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !signing.enabled() || (!required && c.GetHeader(signatureKeyHeader) == "") {
+			c.Next()
+			return
+		}
+		if description := signing.verify(c.Request, principalFrom(c.Request.Context()), now()); description != "" {
+			if bodyTooLarge(c) {
+				tooLarge(c)
+				c.Abort()
+				return
+			}
+			// the v2 routes keep their structured errors
+			if strings.HasPrefix(c.FullPath(), "/v2/") {
+				respondErrorV2(c, http.StatusUnauthorized, ErrCodeUnauthorized, description)
+				c.Abort()
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"description": description})
+			return
+		}
+		if c.GetHeader(signatureKeyHeader) != "" {
+			c.Request = c.Request.WithContext(withSigned(c.Request.Context()))
+		}
+		c.Next()
+	}
+}
+
+// Interceptor turning away receipts submitted over gRPC by clients with a signing secret
+// gRPC requests are not signed, so those clients must submit over HTTP
+func requireSignedRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if info.FullMethod == receiptpb.ReceiptService_ProcessReceipt_FullMethodName && signing.mustSign(principalFrom(ctx)) {
+		return nil, status.Error(codes.PermissionDenied, "Clients with a signing secret must submit receipts over HTTP, signed")
+	}
+	return handler(ctx, req)
+}
+
+// Configure the signature skew window from the environment - SIGNATURE_SKEW, a positive duration like 5m
+func signatureSkewFromEnv() (time.Duration, error) {
+	raw := os.Getenv("SIGNATURE_SKEW")
+	if raw == "" {
+		return defaultSignatureSkew, nil
+	}
+	skew, err := time.ParseDuration(raw)
+	if err != nil || skew <= 0 {
+		return 0, fmt.Errorf("invalid SIGNATURE_SKEW %q - needs a positive duration like 5m", raw)
+	}
+	return skew, nil
+}
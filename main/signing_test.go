@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/joelyoshiya/Fetch_Rewards_Backend_Coding_Challenge/receiptclient"
+	"github.com/joelyoshiya/Fetch_Rewards_Backend_Coding_Challenge/receiptpb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const testSigningSecret = "0123456789abcdef0123456789abcdef"
+
+// require signatures from partner-a for the duration of a test
+func withSigning(t *testing.T) *RequestSigning {
+	s := NewRequestSigning()
+	s.add(SigningKey{Name: "partner-a", Secret: testSigningSecret})
+	previous := signing
+	signing = s
+	t.Cleanup(func() { signing = previous })
+	return s
+}
+
+// a signed request, as a partner would send it - path is signed as given, the request can go elsewhere
+func signedRequest(t *testing.T, path string, body []byte, key string, name string, timestamp time.Time, nonce string) *http.Request {
+	req, err := http.NewRequest(http.MethodPost, path, bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(apiKeyHeader, key)
+	}
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	req.Header.Set(signatureKeyHeader, name)
+	req.Header.Set(signatureTimestampHeader, ts)
+	req.Header.Set(signatureNonceHeader, nonce)
+	req.Header.Set(signatureHeader, "sha256="+signRequest(testSigningSecret, http.MethodPost, path, ts, nonce, body))
+	return req
+}
+
+// serve a request against a fresh router
+func serve(req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	setupRouter().ServeHTTP(w, req)
+	return w
+}
+
+func TestSigning_Verified(t *testing.T) {
+	withSigning(t)
+	withReceipts(t)
+	withQueue(t, 1, 0)
+
+	w := serve(signedRequest(t, "/receipts/process", body_valid_2, "", "partner-a", time.Now(), "nonce-1"))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// the same request again is a replay
+	w = serve(signedRequest(t, "/receipts/process", body_valid_2, "", "partner-a", time.Now(), "nonce-1"))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.JSONEq(t, `{"description": "The request has already been processed"}`, w.Body.String())
+
+	// the body, path and query are all covered
+	req := signedRequest(t, "/receipts/process", body_valid_2, "", "partner-a", time.Now(), "nonce-2")
+	req.Body = io.NopCloser(bytes.NewReader(body_valid_1))
+	req.ContentLength = int64(len(body_valid_1))
+	w = serve(req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.JSONEq(t, `{"description": "The signature does not match"}`, w.Body.String())
+	req = signedRequest(t, "/receipts/process", body_valid_2, "", "partner-a", time.Now(), "nonce-3")
+	req.URL.RawQuery = "async=true"
+	assert.Equal(t, http.StatusUnauthorized, serve(req).Code)
+	req = signedRequest(t, "/receipts/process?async=true", body_valid_2, "", "partner-a", time.Now(), "nonce-3")
+	assert.Equal(t, http.StatusAccepted, serve(req).Code)
+
+	// timestamps outside the window, and unknown keys, are rejected
+	w = serve(signedRequest(t, "/receipts/process", body_valid_2, "", "partner-a", time.Now().Add(-10*time.Minute), "nonce-4"))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.JSONEq(t, `{"description": "The signature timestamp is outside the allowed window"}`, w.Body.String())
+	w = serve(signedRequest(t, "/receipts/process", body_valid_2, "", "partner-a", time.Now().Add(10*time.Minute), "nonce-4"))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = serve(signedRequest(t, "/receipts/process", body_valid_2, "", "partner-z", time.Now(), "nonce-4"))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = serve(signedRequest(t, "/receipts/process", body_valid_2, "", "partner-a", time.Now(), ""))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// unsigned requests are still accepted from callers without a secret
+	w = doKeyRequest(t, http.MethodPost, "/receipts/process", body_valid_2, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestSigning_With_API_Keys(t *testing.T) {
+	withSigning(t)
+	withAPIKeys(t)
+	withReceipts(t)
+
+	// partner-a has a secret, so must sign
+	w := doKeyRequest(t, http.MethodPost, "/receipts/process", body_valid_2, "key-a")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.JSONEq(t, `{"description": "The request must be signed"}`, w.Body.String())
+	w = serve(signedRequest(t, "/receipts/process", body_valid_2, "key-a", "partner-a", time.Now(), "nonce-1"))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// partner-b has none, and cannot borrow partner-a's
+	w = doKeyRequest(t, http.MethodPost, "/receipts/process", body_valid_2, "key-b")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve(signedRequest(t, "/receipts/process", body_valid_2, "key-b", "partner-a", time.Now(), "nonce-2"))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// the API key is checked first
+	w = serve(signedRequest(t, "/receipts/process", body_valid_2, "", "partner-a", time.Now(), "nonce-3"))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.JSONEq(t, `{"description": "An API key is required"}`, w.Body.String())
+}
+
+func TestSigning_All_Submit_Routes(t *testing.T) {
+	withSigning(t)
+	withAPIKeys(t)
+	withReceipts(t)
+	withFakeOCR(t)
+
+	// every route taking receipts asks partner-a for a signature
+	for _, path := range []string{"/receipts/process/text", "/receipts/process/image", "/v2/receipts/process"} {
+		w := doKeyRequest(t, http.MethodPost, path, body_valid_2, "key-a")
+		assert.Equal(t, http.StatusUnauthorized, w.Code, path)
+	}
+	w := doKeyRequest(t, http.MethodPost, "/v2/receipts/process", body_v2_valid_2, "key-a")
+	assert.Equal(t, ErrCodeUnauthorized, decodeErrorV2(t, w.Body.Bytes()).Code)
+	req := signedRequest(t, "/receipts/process/text", []byte(text_valid_2), "key-a", "partner-a", time.Now(), "nonce-1")
+	req.Header.Set("Content-Type", "text/plain")
+	assert.Equal(t, http.StatusOK, serve(req).Code)
+	w = serve(signedRequest(t, "/v2/receipts/process", body_v2_valid_2, "key-a", "partner-a", time.Now(), "nonce-2"))
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// the GraphQL mutation too, though queries need no signature
+	var receipt map[string]interface{}
+	json.Unmarshal(body_valid_2, &receipt)
+	mutation, _ := json.Marshal(map[string]interface{}{
+		"query":     `mutation ($receipt: ReceiptInput!) { processReceipt(receipt: $receipt) { id } }`,
+		"variables": map[string]interface{}{"receipt": receipt},
+	})
+	w = doKeyRequest(t, http.MethodPost, "/graphql", mutation, "key-a")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "The request must be signed")
+	w = serve(signedRequest(t, "/graphql", mutation, "key-a", "partner-a", time.Now(), "nonce-3"))
+	assert.NotContains(t, w.Body.String(), "errors")
+	query, _ := json.Marshal(map[string]interface{}{"query": `{ receipts { id } }`})
+	w = doKeyRequest(t, http.MethodPost, "/graphql", query, "key-a")
+	assert.NotContains(t, w.Body.String(), "errors")
+
+	// and gRPC, which has no signatures, is closed to partner-a
+	client := newGRPCClient(t)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "key-a")
+	_, err := client.ProcessReceipt(ctx, &receiptpb.ProcessReceiptRequest{Receipt: proto_valid_2})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "key-b")
+	_, err = client.ProcessReceipt(ctx, &receiptpb.ProcessReceiptRequest{Receipt: proto_valid_2})
+	assert.NoError(t, err)
+}
+
+func TestSignatureSkewFromEnv(t *testing.T) {
+	skew, err := signatureSkewFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, defaultSignatureSkew, skew)
+
+	t.Setenv("SIGNATURE_SKEW", "2m")
+	skew, err = signatureSkewFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Minute, skew)
+
+	for _, value := range []string{"5", "-1m", "0s"} {
+		t.Setenv("SIGNATURE_SKEW", value)
+		_, err = signatureSkewFromEnv()
+		assert.Error(t, err, value)
+	}
+}
+
+func TestSigning_Nonces_Expire(t *testing.T) {
+	s := NewRequestSigning()
+	start := time.Now()
+	assert.True(t, s.remember("partner-a", "nonce-1", start))
+	assert.False(t, s.remember("partner-a", "nonce-1", start.Add(time.Minute)))
+	assert.True(t, s.remember("partner-b", "nonce-1", start))
+	// once the window has passed, the nonce is forgotten
+	assert.True(t, s.remember("partner-c", "nonce-1", start.Add(11*time.Minute)))
+	assert.Len(t, s.nonces, 1)
+}
+
+func TestSigning_Nonces_Swept_On_Interval(t *testing.T) {
+	s := NewRequestSigning()
+	start := time.Now()
+	assert.True(t, s.remember("partner-a", "nonce-1", start))
+	assert.True(t, s.remember("partner-a", "nonce-2", start.Add(20*time.Minute)))
+	// nonce-1 has expired but the interval has not passed, so it is not swept yet - it is taken as new all the same
+	s.swept = start.Add(30 * time.Minute)
+	assert.True(t, s.remember("partner-a", "nonce-1", start.Add(30*time.Minute)))
+	assert.False(t, s.remember("partner-a", "nonce-1", start.Add(30*time.Minute+time.Second)))
+	assert.Len(t, s.nonces, 2)
+	// the next sweep drops the expired nonce-2
+	assert.True(t, s.remember("partner-a", "nonce-3", start.Add(31*time.Minute)))
+	assert.Len(t, s.nonces, 2)
+}
+
+func TestClient_Signing(t *testing.T) {
+	withSigning(t)
+	withAPIKeys(t)
+	withReceipts(t)
+	withQueue(t, 1, 0)
+	ctx := context.Background()
+
+	client := newClient(t, setupRouter(), receiptclient.WithAPIKey("key-a"), receiptclient.WithSigningKey("partner-a", testSigningSecret))
+	id, err := client.ProcessReceipt(ctx, clientValue[receiptclient.Receipt](t, body_valid_2))
+	assert.NoError(t, err)
+	_, err = client.ProcessReceiptAsync(ctx, clientValue[receiptclient.Receipt](t, body_valid_2))
+	assert.NoError(t, err)
+	_, err = client.GetPoints(ctx, id)
+	assert.NoError(t, err)
+
+	client = newClient(t, setupRouter(), receiptclient.WithAPIKey("key-a"), receiptclient.WithSigningKey("partner-a", "wrong"))
+	_, err = client.ProcessReceipt(ctx, clientValue[receiptclient.Receipt](t, body_valid_2))
+	assert.ErrorIs(t, err, receiptclient.ErrUnauthorized)
+}
+
+func TestLoadSigningKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signing.json")
+	os.WriteFile(path, []byte(`[{"name": "partner-a", "secret": "`+testSigningSecret+`"}]`), 0o600)
+	s, err := loadSigningKeys(path)
+	assert.NoError(t, err)
+	secret, present := s.secret("partner-a")
+	assert.True(t, present)
+	assert.Equal(t, testSigningSecret, secret)
+
+	os.WriteFile(path, []byte(`[{"name": "partner-a", "secret": "short"}]`), 0o600)
+	_, err = loadSigningKeys(path)
+	assert.Error(t, err)
+}
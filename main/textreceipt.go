@@ -0,0 +1,351 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Struct definitions & constructors
+
+// Struct representing a receipt parsed from text, with how confident the parser is in each field (0-1)
+type ParsedReceipt struct {
+	Receipt    Receipt            `json:"receipt"`
+	Confidence map[string]float64 `json:"confidence"`
+}
+
+// Internal data
+
+var (
+	// dates - ISO, US month/day/year, and US with a two digit year
+	isoDateRegex   = regexp.MustCompile(`\b(\d{4})-(\d{2})-(\d{2})\b`)
+	slashDateRegex = regexp.MustCompile(`\b(\d{1,2})/(\d{1,2})/(\d{4})\b`)
+	shortYearRegex = regexp.MustCompile(`\b(\d{1,2})/(\d{1,2})/(\d{2})\b`)
+	clockRegex     = regexp.MustCompile(`\b(\d{1,2}):(\d{2})(?::\d{2})?(?:\s*([AaPp])\.?[Mm]\.?)?`)
+	// amount at the end of a line - allows a $ sign, OCR confusions (O for 0, l/I for 1), a comma decimal point,
+	// a trailing minus for discounts and a trailing tax flag ("T", "F", "N")
+	lineAmountRegex = regexp.MustCompile(`^(?:(.*?)\s+)?(-?)\$?\s?([0-9OoIl]+[.,][0-9OoIl]{2})(-?)(?:\s+[A-Z]{1,2})?$`)
+	// quantity and unit price within an item description - "GATORADE 2 @ 2.25"
+	itemQuantityRegex = regexp.MustCompile(`^(.*?)\s+(\d+(?:\.\d{1,3})?)\s*[@xX]\s*\$?(\d+\.\d{2})$`)
+)
+
+// lines that carry payment information rather than items, and the payment method they imply - checked in order
+var paymentKeywords = []struct {
+	regex  *regexp.Regexp
+	method string
+}{
+	{regexp.MustCompile(`\b(VISA|MASTERCARD|AMEX|DISCOVER|CREDIT)\b`), "credit"},
+	{regexp.MustCompile(`\bDEBIT\b`), "debit"},
+	{regexp.MustCompile(`\bGIFT ?CARD\b`), "giftCard"},
+	{regexp.MustCompile(`\bEBT\b`), "ebt"},
+	{regexp.MustCompile(`\b(APPLE|GOOGLE|SAMSUNG) ?PAY\b`), "mobile"},
+	{regexp.MustCompile(`\bCASH\b`), "cash"},
+}
+
+// lines that are neither items nor totals
+var ignoredKeywords = []string{"CHANGE", "TENDER", "AUTH", "APPROVED", "ITEMS SOLD", "THANK YOU", "WELCOME"}
+
+// Internal functions - not exported
+
+// Parse an amount from a line, repairing common OCR confusions
+// Returns the amount and how confident the parser is in it
+func parseLineAmount(raw string) (string, float64) {
+	confidence := 1.0
+	repaired := strings.NewReplacer("O", "0", "o", "0", "I", "1", "l", "1").Replace(raw)
+	if repaired != raw {
+		confidence = 0.7
+	}
+	if strings.Contains(repaired, ",") {
+		repaired = strings.Replace(repaired, ",", ".", 1)
+		confidence = math.Min(confidence, 0.8)
+	}
+	// drop leading zeros the OCR may have picked up, keeping at least one digit before the decimal point
+	whole, fraction, _ := strings.Cut(repaired, ".")
+	whole = strings.TrimLeft(whole, "0")
+	if whole == "" {
+		whole = "0"
+	}
+	return whole + "." + fraction, confidence
+}
+
+// Find a purchase date in a line - returns the ISO date and the parser's confidence
+func findDate(line string) (string, float64, bool) {
+	if m := isoDateRegex.FindStringSubmatch(line); m != nil {
+		if d, ok := buildDate(m[1], m[2], m[3]); ok {
+			return d, 1.0, true
+		}
+	}
+	if m := slashDateRegex.FindStringSubmatch(line); m != nil {
+		// month first unless that cannot be a month
+		if d, ok := buildDate(m[3], m[1], m[2]); ok {
+			return d, 0.85, true
+		}
+		if d, ok := buildDate(m[3], m[2], m[1]); ok {
+			return d, 0.75, true
+		}
+	}
+	if m := shortYearRegex.FindStringSubmatch(line); m != nil {
+		if d, ok := buildDate("20"+m[3], m[1], m[2]); ok {
+			return d, 0.7, true
+		}
+	}
+	return "", 0, false
+}
+
+// Build an ISO date, checking the day exists
+func buildDate(year, month, day string) (string, bool) {
+	y, _ := strconv.Atoi(year)
+	m, _ := strconv.Atoi(month)
+	d, _ := strconv.Atoi(day)
+	t := time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC)
+	if t.Year() != y || int(t.Month()) != m || t.Day() != d {
+		return "", false
+	}
+	return t.Format("2006-01-02"), true
+}
+
+// Find a purchase time in a line - returns the 24-hour time and the parser's confidence
+func findTime(line string) (string, float64, bool) {
+	m := clockRegex.FindStringSubmatch(line)
+	if m == nil {
+		return "", 0, false
+	}
+	hour, _ := strconv.Atoi(m[1])
+	minute, _ := strconv.Atoi(m[2])
+	confidence := 1.0
+	switch strings.ToUpper(m[3]) {
+	case "A":
+		if hour == 12 {
+			hour = 0
+		}
+		confidence = 0.95
+	case "P":
+		if hour < 12 {
+			hour += 12
+		}
+		confidence = 0.95
+	default:
+		// a single digit hour with no AM/PM could be either
+		if len(m[1]) == 1 {
+			confidence = 0.8
+		}
+	}
+	if hour > 23 || minute > 59 {
+		return "", 0, false
+	}
+	return fmt.Sprintf("%02d:%02d", hour, minute), confidence, true
+}
+
+// Parse a plain-text receipt
+// Expects a header with the store name, a line with the date and time, one line per item ending in its price, and a TOTAL line
+// Fields that cannot be found are left empty with a confidence of 0, so validateReceipt rejects the receipt
+func parseReceiptText(text string) ParsedReceipt {
+	var r Receipt
+	confidence := map[string]float64{"retailer": 0, "purchaseDate": 0, "purchaseTime": 0, "items": 0, "total": 0}
+	var itemConfidence []float64
+	totalFound := false
+
+	lineNumber := 0
+	for _, raw := range strings.Split(text, "\n") {
+		line := strings.TrimSpace(raw)
+		if line == "" {
+			continue
+		}
+		lineNumber++
+		upper := strings.ToUpper(line)
+
+		// date and time - usually on the same line
+		if r.PurchaseDate == "" {
+			if d, c, ok := findDate(line); ok {
+				r.PurchaseDate, confidence["purchaseDate"] = d, c
+				if t, c, ok := findTime(line); ok {
+					r.PurchaseTime, confidence["purchaseTime"] = t, c
+				}
+				continue
+			}
+		}
+		if r.PurchaseTime == "" && !lineAmountRegex.MatchString(line) {
+			if t, c, ok := findTime(line); ok {
+				r.PurchaseTime, confidence["purchaseTime"] = t, c
+				continue
+			}
+		}
+
+		// payment lines
+		if method, ok := paymentKeyword(upper); ok {
+			if r.PaymentMethod == "" {
+				r.PaymentMethod = method
+				confidence["paymentMethod"] = 0.9
+			}
+			continue
+		}
+		if containsAny(upper, ignoredKeywords) {
+			continue
+		}
+
+		m := lineAmountRegex.FindStringSubmatch(line)
+		if m == nil {
+			// the first line without an amount, before any items, is the store name
+			if r.Retailer == "" && len(r.Items) == 0 && strings.IndexFunc(line, unicode.IsLetter) >= 0 {
+				r.Retailer = line
+				confidence["retailer"] = 0.9
+				if lineNumber > 1 {
+					confidence["retailer"] = 0.6
+				}
+			}
+			continue
+		}
+		label := strings.TrimSpace(m[1])
+		labelUpper := strings.ToUpper(label)
+		amount, c := parseLineAmount(m[3])
+		negative := m[2] == "-" || m[4] == "-"
+
+		switch {
+		case strings.Contains(labelUpper, "SUBTOTAL") || strings.Contains(labelUpper, "SUB TOTAL"):
+			r.Subtotal, confidence["subtotal"] = amount, c
+		case strings.Contains(labelUpper, "TAX"):
+			r.Tax, confidence["tax"] = amount, c
+		case strings.Contains(labelUpper, "TOTAL") || strings.Contains(labelUpper, "BALANCE DUE") || strings.Contains(labelUpper, "AMOUNT DUE"):
+			if !totalFound {
+				r.Total, confidence["total"] = amount, c
+				totalFound = true
+			}
+		case negative || containsAny(labelUpper, []string{"COUPON", "DISCOUNT", "SAVINGS"}):
+			r.Discounts = append(r.Discounts, Discount{Description: label, Amount: amount})
+		case !totalFound && label != "":
+			item := Item{ShortDescription: label, Price: amount}
+			if q := itemQuantityRegex.FindStringSubmatch(label); q != nil {
+				item.ShortDescription, item.Quantity, item.UnitPrice = q[1], q[2], q[3]
+			}
+			r.Items = append(r.Items, item)
+			itemConfidence = append(itemConfidence, c)
+		}
+	}
+
+	// items - average confidence of each line
+	if len(itemConfidence) > 0 {
+		sum := 0.0
+		for _, c := range itemConfidence {
+			sum += c
+		}
+		confidence["items"] = sum / float64(len(itemConfidence))
+	}
+
+	// total - trust it more when it adds up, otherwise fall back to the sum of the items
+	expected := expectedTotal(r)
+	if totalFound {
+		if expected != r.Total {
+			confidence["total"] = math.Min(confidence["total"], 0.6)
+		}
+	} else if len(r.Items) > 0 {
+		r.Total, confidence["total"] = expected, 0.3
+	}
+	// a subtotal that does not add up would fail validation - drop it rather than reject the receipt
+	if r.Subtotal != "" && checkReceiptDetails(r, currencies[DefaultCurrency]) != nil {
+		r.Subtotal = ""
+		delete(confidence, "subtotal")
+	}
+
+	// overall confidence is the weakest required field
+	overall := 1.0
+	for _, field := range []string{"retailer", "purchaseDate", "purchaseTime", "items", "total"} {
+		overall = math.Min(overall, confidence[field])
+	}
+	confidence["overall"] = overall
+	return ParsedReceipt{Receipt: r, Confidence: confidence}
+}
+
+// Total implied by the items, tax and discounts on a parsed receipt
+func expectedTotal(r Receipt) string {
+	usd := currencies[DefaultCurrency]
+	var total int64
+	if r.Subtotal != "" {
+		total, _ = parseMinorUnits(r.Subtotal, usd)
+	} else {
+		for _, item := range r.Items {
+			price, _ := parseMinorUnits(item.Price, usd)
+			total += price
+		}
+	}
+	tax, _ := parseMinorUnits(r.Tax, usd)
+	total += tax
+	for _, d := range r.Discounts {
+		amount, _ := parseMinorUnits(d.Amount, usd)
+		total -= amount
+	}
+	if total < 0 {
+		total = 0
+	}
+	return fmt.Sprintf("%d.%02d", total/100, total%100)
+}
+
+// Get the payment method implied by a line, if any
+func paymentKeyword(upper string) (string, bool) {
+	for _, keyword := range paymentKeywords {
+		if keyword.regex.MatchString(upper) {
+			return keyword.method, true
+		}
+	}
+	return "", false
+}
+
+// Check if a string contains any of the given substrings
+func containsAny(s string, substrings []string) bool {
+	for _, sub := range substrings {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// Internal Route Functions
+
+// Path: /receipts/process/text
+// Method: POST
+// Payload: Plain-text receipt
+// Query: minConfidence (optional) - reject receipts whose overall confidence is below this value
+// Response: JSON containing an id for the receipt, the parsed receipt and per-field confidence.
+// Description: Parses an OCR'd plain-text receipt and processes it like a JSON receipt.
+func processTextReceipt(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if errors.Is(err, errBodyTooLarge) {
+		tooLarge(c)
+		return
+	}
+	if err != nil || strings.TrimSpace(string(body)) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"description": "The receipt is invalid"})
+		return
+	}
+	minConfidence := 0.0
+	if raw := c.Query("minConfidence"); raw != "" {
+		minConfidence, err = strconv.ParseFloat(raw, 64)
+		if err != nil || minConfidence < 0 || minConfidence > 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"description": "minConfidence must be between 0 and 1"})
+			return
+		}
+	}
+
+	parsed := parseReceiptText(string(body))
+	if parsed.Confidence["overall"] < minConfidence {
+		c.JSON(http.StatusBadRequest, gin.H{"description": "The receipt could not be read with enough confidence", "receipt": parsed.Receipt, "confidence": parsed.Confidence})
+		return
+	}
+
+	// validate, score and store receipt - same pipeline as JSON receipts
+	id, err := submitReceipt(c.Request.Context(), parsed.Receipt)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"description": "The receipt is invalid", "receipt": parsed.Receipt, "confidence": parsed.Confidence})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": id, "receipt": parsed.Receipt, "confidence": parsed.Confidence})
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// body_valid_2 as printed by the store
+var text_valid_2 = `
+M&M Corner Market
+123 Main St, Springfield
+
+03/20/2022 2:33 PM
+
+Gatorade          2.25
+Gatorade          2.25
+Gatorade          2.25
+Gatorade          2.25
+
+TOTAL             9.00
+VISA TEND         9.00
+THANK YOU FOR SHOPPING
+`
+
+// receipt with OCR errors, tax, a coupon and a quantity line
+var text_ocr = `TARGET
+2022-01-01 13:01
+MOUNTAIN DEW 12PK 2 @ 6.49   12.98 T
+DORITOS NACHO CHEESE        3.35 T
+CIRCLE COUPON               1.00-
+SUBTOTAL                   16.33
+TAX                         1.O5
+TOTAL                      16.38
+DEBIT                      16.38
+`
+
+// post a plain-text receipt and decode the response
+func postTextReceipt(t *testing.T, path string, text string) (int, map[string]interface{}) {
+	router := setupRouter()
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodPost, path, bytes.NewBufferString(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	router.ServeHTTP(w, req)
+	var resp map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, resp
+}
+
+func TestParseReceiptText(t *testing.T) {
+	parsed := parseReceiptText(text_valid_2)
+	r := parsed.Receipt
+	assert.Equal(t, "M&M Corner Market", r.Retailer)
+	assert.Equal(t, "2022-03-20", r.PurchaseDate)
+	assert.Equal(t, "14:33", r.PurchaseTime)
+	assert.Len(t, r.Items, 4)
+	assert.Equal(t, Item{ShortDescription: "Gatorade", Price: "2.25"}, r.Items[0])
+	assert.Equal(t, "9.00", r.Total)
+	assert.Equal(t, "credit", r.PaymentMethod)
+	assert.Equal(t, 0.9, parsed.Confidence["retailer"])
+	assert.Equal(t, 1.0, parsed.Confidence["total"])
+	assert.Equal(t, 0.85, parsed.Confidence["overall"])
+
+	// same points as the JSON version
+	assert.Equal(t, body_valid_2_pts, processPoints(r))
+}
+
+func TestParseReceiptText_OCR(t *testing.T) {
+	parsed := parseReceiptText(text_ocr)
+	r := parsed.Receipt
+	assert.Equal(t, "TARGET", r.Retailer)
+	assert.Equal(t, []Item{
+		{ShortDescription: "MOUNTAIN DEW 12PK", Price: "12.98", Quantity: "2", UnitPrice: "6.49"},
+		{ShortDescription: "DORITOS NACHO CHEESE", Price: "3.35"},
+	}, r.Items)
+	assert.Equal(t, []Discount{{Description: "CIRCLE COUPON", Amount: "1.00"}}, r.Discounts)
+	assert.Equal(t, "16.33", r.Subtotal)
+	// the O in the tax amount is read as a 0, with lower confidence
+	assert.Equal(t, "1.05", r.Tax)
+	assert.Equal(t, 0.7, parsed.Confidence["tax"])
+	assert.Equal(t, "16.38", r.Total)
+	assert.Equal(t, "debit", r.PaymentMethod)
+	assert.True(t, validateReceipt(r))
+}
+
+func TestParseReceiptText_Missing_Fields(t *testing.T) {
+	// no total line - total falls back to the sum of the items with low confidence
+	parsed := parseReceiptText("CORNER SHOP\n2022-03-20 09:15\nMILK 3.49\nBREAD 2.51\n")
+	assert.Equal(t, "6.00", parsed.Receipt.Total)
+	assert.Equal(t, 0.3, parsed.Confidence["total"])
+
+	// no date - confidence 0, and the receipt fails validation
+	parsed = parseReceiptText("CORNER SHOP\nMILK 3.49\nTOTAL 3.49\n")
+	assert.Equal(t, 0.0, parsed.Confidence["purchaseDate"])
+	assert.Equal(t, 0.0, parsed.Confidence["overall"])
+	assert.False(t, validateReceipt(parsed.Receipt))
+}
+
+func TestFindDateAndTime(t *testing.T) {
+	d, c, ok := findDate("DATE 31/12/2021")
+	assert.True(t, ok)
+	assert.Equal(t, "2021-12-31", d)
+	assert.Equal(t, 0.75, c)
+	_, _, ok = findDate("02/30/2022")
+	assert.False(t, ok)
+	d, _, _ = findDate("1/2/22")
+	assert.Equal(t, "2022-01-02", d)
+
+	tm, _, _ := findTime("12:05 AM")
+	assert.Equal(t, "00:05", tm)
+	tm, _, _ = findTime("12:05 pm")
+	assert.Equal(t, "12:05", tm)
+	tm, _, _ = findTime("TIME 08:13:55")
+	assert.Equal(t, "08:13", tm)
+	_, _, ok = findTime("25:00")
+	assert.False(t, ok)
+}
+
+func TestProcessTextReceipt(t *testing.T) {
+	code, resp := postTextReceipt(t, "/receipts/process/text", text_valid_2)
+	assert.Equal(t, http.StatusOK, code)
+	id := resp["id"].(string)
+	assert.Equal(t, body_valid_2_pts, rs.ReceiptsMap[id].Points)
+	assert.Contains(t, resp, "confidence")
+
+	// below the requested confidence
+	code, resp = postTextReceipt(t, "/receipts/process/text?minConfidence=0.9", text_valid_2)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "The receipt could not be read with enough confidence", resp["description"])
+
+	// unreadable receipt
+	code, resp = postTextReceipt(t, "/receipts/process/text", "nothing to see here")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "The receipt is invalid", resp["description"])
+
+	// empty body
+	code, _ = postTextReceipt(t, "/receipts/process/text", "  ")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // embed the time zone database - the alpine image does not ship one
+)
+
+// Internal data
+
+// Current time - swapped out in tests
+var now = time.Now
+
+// Latest UTC offset in use anywhere (Kiribati, UTC+14)
+// A receipt without a time zone could have been printed anywhere, so it is only in the future if it is in the future there too
+var latestZone = time.FixedZone("UTC+14", 14*60*60)
+
+// Internal functions - not exported
+
+// Parse a time zone - either an IANA name ("America/Chicago", "UTC") or a UTC offset ("-05:00", "+0530", "Z")
+func parseTimeZone(tz string) (*time.Location, error) {
+	if tz == "" {
+		return nil, fmt.Errorf("empty time zone")
+	}
+	if tz == "Z" || tz == "z" {
+		return time.UTC, nil
+	}
+	if strings.HasPrefix(tz, "+") || strings.HasPrefix(tz, "-") {
+		for _, layout := range []string{"-07:00", "-0700", "-07"} {
+			if t, err := time.Parse(layout, tz); err == nil {
+				_, offset := t.Zone()
+				return time.FixedZone(tz, offset), nil
+			}
+		}
+		return nil, fmt.Errorf("invalid UTC offset %q", tz)
+	}
+	return time.LoadLocation(tz)
+}
+
+// Get the time zone the receipt was printed in
+// The receipt's own time zone takes precedence, then its merchant's - returns false if neither is known
+func receiptLocation(r Receipt) (*time.Location, bool) {
+	if r.TimeZone != "" {
+		if loc, err := parseTimeZone(r.TimeZone); err == nil {
+			return loc, true
+		}
+	}
+	if m, present := merchants.get(r.MerchantID); present && m.TimeZone != "" {
+		if loc, err := parseTimeZone(m.TimeZone); err == nil {
+			return loc, true
+		}
+	}
+	return nil, false
+}
+
+// Get the instant of purchase from the receipt's date, time and time zone
+// Receipts without a known time zone are treated as UTC
+func purchaseInstant(r Receipt) (time.Time, error) {
+	loc, present := receiptLocation(r)
+	if !present {
+		loc = time.UTC
+	}
+	return time.ParseInLocation("2006-01-02 15:04", r.PurchaseDate+" "+r.PurchaseTime, loc)
+}
+
+// Check if the purchase happened after the current time
+func isFuturePurchase(r Receipt) bool {
+	instant, err := purchaseInstant(r)
+	if err != nil {
+		return false
+	}
+	if _, present := receiptLocation(r); !present {
+		// re-read the wall clock in the latest zone to get the earliest instant the receipt could refer to
+		instant, _ = time.ParseInLocation("2006-01-02 15:04", r.PurchaseDate+" "+r.PurchaseTime, latestZone)
+	}
+	return instant.After(now())
+}
+
+// Get the purchase time as the store's local time
+func localPurchaseTime(r Receipt) time.Time {
+	instant, _ := purchaseInstant(r)
+	if loc, present := receiptLocation(r); present {
+		return instant.In(loc)
+	}
+	return instant
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fix the current time for the duration of a test
+func withNow(t *testing.T, fixed time.Time) {
+	previous := now
+	now = func() time.Time { return fixed }
+	t.Cleanup(func() { now = previous })
+}
+
+// receipt with only the fields the date and time rules look at
+func timedReceipt(date string, clock string, tz string) Receipt {
+	return Receipt{
+		Retailer:     "Target",
+		PurchaseDate: date,
+		PurchaseTime: clock,
+		Items:        []Item{{ShortDescription: "Pepsi - 12-oz", Price: "1.25"}},
+		Total:        "1.25",
+		TimeZone:     tz,
+	}
+}
+
+func TestParseTimeZone(t *testing.T) {
+	for _, tz := range []string{"UTC", "Z", "America/Chicago", "Asia/Tokyo", "-05:00", "+0530", "+09"} {
+		_, err := parseTimeZone(tz)
+		assert.NoError(t, err, tz)
+	}
+	for _, tz := range []string{"", "Mars/Olympus_Mons", "+5:30", "-"} {
+		_, err := parseTimeZone(tz)
+		assert.Error(t, err, tz)
+	}
+
+	loc, _ := parseTimeZone("+0530")
+	_, offset := time.Date(2022, 1, 1, 0, 0, 0, 0, loc).Zone()
+	assert.Equal(t, 5*60*60+30*60, offset)
+}
+
+func TestPurchaseInstant(t *testing.T) {
+	// without a time zone the receipt is treated as UTC
+	instant, err := purchaseInstant(timedReceipt("2022-03-20", "14:33", ""))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 3, 20, 14, 33, 0, 0, time.UTC), instant)
+
+	// with a time zone the wall clock is read in that zone
+	instant, err = purchaseInstant(timedReceipt("2022-03-20", "14:33", "America/Chicago"))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 3, 20, 19, 33, 0, 0, time.UTC), instant.UTC())
+
+	// the merchant's time zone is used when the receipt has none - added to a fresh catalog, so no other test sees it
+	mc := withMerchants(t)
+	assert.True(t, mc.add(Merchant{ID: "tz-test-mart", Name: "Tz Test Mart", TimeZone: "Asia/Tokyo"}))
+	r := timedReceipt("2022-03-20", "14:33", "")
+	r.MerchantID = "tz-test-mart"
+	instant, err = purchaseInstant(r)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 3, 20, 5, 33, 0, 0, time.UTC), instant.UTC())
+}
+
+func TestProcessPoints_LocalTime(t *testing.T) {
+	// 14:33 local time earns the time bonus regardless of the store's offset from UTC
+	base := processPoints(timedReceipt("2022-03-20", "10:00", ""))
+	assert.Equal(t, base+10, processPoints(timedReceipt("2022-03-20", "14:33", "")))
+	assert.Equal(t, base+10, processPoints(timedReceipt("2022-03-20", "14:33", "America/Los_Angeles")))
+	assert.Equal(t, base+10, processPoints(timedReceipt("2022-03-20", "14:33", "+09:00")))
+
+	// the window is exclusive on both ends
+	assert.Equal(t, base, processPoints(timedReceipt("2022-03-20", "14:00", "")))
+	assert.Equal(t, base, processPoints(timedReceipt("2022-03-20", "16:00", "")))
+
+	// the odd day rule uses the local date
+	assert.Equal(t, base+6, processPoints(timedReceipt("2022-03-21", "10:00", "Pacific/Kiritimati")))
+}
+
+func TestIsFuturePurchase(t *testing.T) {
+	withNow(t, time.Date(2022, 3, 20, 12, 0, 0, 0, time.UTC))
+
+	assert.False(t, isFuturePurchase(timedReceipt("2022-03-20", "11:59", "UTC")))
+	assert.True(t, isFuturePurchase(timedReceipt("2022-03-20", "12:01", "UTC")))
+	// 20:00 in Tokyo is 11:00 UTC - already happened
+	assert.False(t, isFuturePurchase(timedReceipt("2022-03-20", "20:00", "Asia/Tokyo")))
+	// 08:00 in Chicago is 13:00 UTC - not yet
+	assert.True(t, isFuturePurchase(timedReceipt("2022-03-20", "08:00", "America/Chicago")))
+
+	// without a time zone, only reject receipts that are in the future everywhere
+	assert.False(t, isFuturePurchase(timedReceipt("2022-03-21", "01:00", "")))
+	assert.True(t, isFuturePurchase(timedReceipt("2022-03-21", "03:00", "")))
+}
+
+func TestValidateReceipt_TimeZone(t *testing.T) {
+	withNow(t, time.Date(2022, 3, 20, 12, 0, 0, 0, time.UTC))
+
+	assert.True(t, validateReceipt(timedReceipt("2022-03-20", "10:00", "Europe/Berlin")))
+	assert.False(t, validateReceipt(timedReceipt("2022-03-20", "10:00", "Europe/Atlantis")))
+	assert.False(t, validateReceipt(timedReceipt("2023-01-01", "10:00", "")))
+}
+
+func TestProcessReceipt_Bad_Future_Date(t *testing.T) {
+	w := doRequest(t, http.MethodPost, "/receipts/process", []byte(`{
+	"retailer": "Target",
+	"purchaseDate": "2999-01-01",
+	"purchaseTime": "13:01",
+	"items": [{"shortDescription": "Mountain Dew 12PK", "price": "6.49"}],
+	"total": "6.49"
+	}`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"The receipt is invalid"`)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Internal data
+
+// Name spans are recorded under - the instrumentation scope
+const tracerName = "github.com/joelyoshiya/Fetch_Rewards_Backend_Coding_Challenge/main"
+
+// Service name reported with every span, unless OTEL_SERVICE_NAME overrides it
+const serviceName = "receipt-processor"
+
+// Global tracer provider - records nothing until an exporter is configured
+var tracerProvider trace.TracerProvider = trace.NewNoopTracerProvider()
+
+// Reads and writes W3C trace context (traceparent, tracestate) and baggage headers
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Internal functions - not exported
+
+// Start a span as a child of the span in ctx, if any
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracerProvider.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Record an error on a span and mark it failed
+func spanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// Middleware tracing every request
+// Continues the caller's trace when the request carries a traceparent header, and returns the trace context in the response
+func traceRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := propagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx, span := tracerProvider.Tracer(tracerName).Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPMethodKey.String(c.Request.Method), semconv.HTTPRouteKey.String(route)))
+		defer span.End()
+		propagator.Inject(ctx, propagation.HeaderCarrier(c.Writer.Header()))
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
+
+// Build a tracer provider batching spans to an exporter
+func newTracerProvider(ctx context.Context, exporter sdktrace.SpanExporter) (*sdktrace.TracerProvider, error) {
+	// the service name first, so OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES can override it
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceNameKey.String(serviceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res)), nil
+}
+
+// Configure tracing from the environment
+// OTEL_TRACES_EXPORTER selects the exporter: otlp (OTLP over HTTP, to OTEL_EXPORTER_OTLP_ENDPOINT - a local collector by default), stdout or none
+// Returns nil if tracing is off
+func tracerProviderFromEnv(ctx context.Context) (*sdktrace.TracerProvider, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch name := strings.ToLower(os.Getenv("OTEL_TRACES_EXPORTER")); name {
+	case "", "none":
+		return nil, nil
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx)
+	case "stdout":
+		exporter, err = stdouttrace.New()
+	default:
+		return nil, fmt.Errorf("unknown OTEL_TRACES_EXPORTER %q - expected otlp, stdout or none", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newTracerProvider(ctx, exporter)
+}
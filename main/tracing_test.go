@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/joelyoshiya/Fetch_Rewards_Backend_Coding_Challenge/receiptclient"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// a W3C traceparent header from an upstream caller
+const upstreamTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// record spans in memory for the duration of a test
+func withTracing(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := tracerProvider
+	tracerProvider = tp
+	t.Cleanup(func() { tracerProvider = previous })
+	return recorder
+}
+
+// the ended spans, by name - where names repeat across requests, the latest is kept
+func spansByName(recorder *tracetest.SpanRecorder) map[string]sdktrace.ReadOnlySpan {
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	return spans
+}
+
+// the value of a span attribute, or an empty value if it is not set
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracing_Receipt_Submission(t *testing.T) {
+	recorder := withTracing(t)
+	withReceipts(t)
+
+	router := setupRouter()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/receipts/process", bytes.NewBuffer(body_valid_2))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", upstreamTraceparent)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	spans := spansByName(recorder)
+	assert.Len(t, recorder.Ended(), 5+len(scoringRules))
+	server := spans["POST /receipts/process"]
+	if !assert.NotNil(t, server) {
+		return
+	}
+	// the request continues the caller's trace
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+	assert.True(t, server.Parent().IsRemote())
+	assert.Equal(t, int64(http.StatusOK), spanAttribute(server, "http.status_code").AsInt64())
+	assert.Contains(t, w.Header().Get("traceparent"), "4bf92f3577b34da6a3ce929d0e0e4736")
+
+	// binding, validation, scoring and the store write are children of the request
+	for _, name := range []string{"bind", "validateReceipt", "processPoints", "store"} {
+		if assert.Contains(t, spans, name) {
+			assert.Equal(t, server.SpanContext().SpanID(), spans[name].Parent().SpanID(), name)
+		}
+	}
+	// and each rule a child of scoring
+	for _, rule := range scoringRules {
+		span := spans["rule "+rule.Name]
+		if assert.NotNil(t, span, rule.Name) {
+			assert.Equal(t, spans["processPoints"].SpanContext().SpanID(), span.Parent().SpanID())
+		}
+	}
+	assert.Equal(t, int64(14), spanAttribute(spans["rule retailerName"], "scoring.points").AsInt64())
+}
+
+func TestTracing_Rejected_Receipt(t *testing.T) {
+	recorder := withTracing(t)
+	withReceipts(t)
+
+	assert.Equal(t, http.StatusBadRequest, doRequest(t, http.MethodPost, "/receipts/process", body_bad_negative_total).Code)
+	spans := spansByName(recorder)
+	validation := spans["validateReceipt"]
+	if assert.NotNil(t, validation) {
+		assert.Equal(t, codes.Error, validation.Status().Code)
+		assert.Equal(t, "total", spanAttribute(validation, "receipt.invalid_field").AsString())
+	}
+	assert.NotContains(t, spans, "processPoints")
+	assert.NotContains(t, spans, "store")
+
+	// a body that does not bind fails the bind span
+	doRequest(t, http.MethodPost, "/receipts/process", []byte(`{"retailer": 1}`))
+	assert.Equal(t, codes.Error, spansByName(recorder)["bind"].Status().Code)
+}
+
+func TestTracing_Queued_Receipt(t *testing.T) {
+	recorder := withTracing(t)
+	withReceipts(t)
+	q := withQueue(t, 1, 0)
+
+	assert.Equal(t, http.StatusAccepted, doRequest(t, http.MethodPost, "/receipts/process?async=true", body_valid_2).Code)
+	request := spansByName(recorder)["POST /receipts/process"]
+	q.process(<-q.jobs)
+
+	// the job gets a trace of its own, linked to the request that queued it
+	spans := spansByName(recorder)
+	job := spans["processQueuedReceipt"]
+	if assert.NotNil(t, job) && assert.Len(t, job.Links(), 1) {
+		assert.Equal(t, request.SpanContext().SpanID(), job.Links()[0].SpanContext.SpanID())
+		assert.NotEqual(t, request.SpanContext().TraceID(), job.SpanContext().TraceID())
+	}
+	for _, name := range []string{"validateReceipt", "processPoints", "store"} {
+		assert.Equal(t, job.SpanContext().SpanID(), spans[name].Parent().SpanID(), name)
+	}
+}
+
+func TestTracing_Client_Propagation(t *testing.T) {
+	recorder := withTracing(t)
+	withReceipts(t)
+	client := newClient(t, setupRouter())
+
+	ctx, span := startSpan(context.Background(), "caller")
+	_, err := client.ProcessReceipt(ctx, clientValue[receiptclient.Receipt](t, body_valid_2))
+	span.End()
+	assert.NoError(t, err)
+	server := spansByName(recorder)["POST /receipts/process"]
+	assert.Equal(t, span.SpanContext().TraceID(), server.SpanContext().TraceID())
+	assert.Equal(t, span.SpanContext().SpanID(), server.Parent().SpanID())
+}
+
+func TestTracing_Stdout_Exporter(t *testing.T) {
+	withReceipts(t)
+	var out bytes.Buffer
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(&out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp, err := newTracerProvider(context.Background(), exporter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := tracerProvider
+	tracerProvider = tp
+	t.Cleanup(func() { tracerProvider = previous })
+
+	doRequest(t, http.MethodPost, "/receipts/process", body_valid_2)
+	// flush the batch
+	assert.NoError(t, tp.Shutdown(context.Background()))
+	for _, name := range []string{"POST /receipts/process", "validateReceipt", "rule afternoonPurchase", "receipt-processor"} {
+		assert.Contains(t, out.String(), `"`+name+`"`)
+	}
+}
+
+func TestTracerProviderFromEnv(t *testing.T) {
+	ctx := context.Background()
+	tp, err := tracerProviderFromEnv(ctx)
+	assert.NoError(t, err)
+	assert.Nil(t, tp)
+
+	t.Setenv("OTEL_TRACES_EXPORTER", "stdout")
+	tp, err = tracerProviderFromEnv(ctx)
+	assert.NoError(t, err)
+	if assert.NotNil(t, tp) {
+		tp.Shutdown(ctx)
+	}
+
+	// the OTLP exporter connects lazily, so a collector need not be running
+	t.Setenv("OTEL_TRACES_EXPORTER", "otlp")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318")
+	tp, err = tracerProviderFromEnv(ctx)
+	assert.NoError(t, err)
+	if assert.NotNil(t, tp) {
+		tp.Shutdown(ctx)
+	}
+
+	t.Setenv("OTEL_TRACES_EXPORTER", "zipkin")
+	_, err = tracerProviderFromEnv(ctx)
+	assert.Error(t, err)
+}
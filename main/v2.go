@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Struct definitions & constructors
+
+// Struct representing a decimal sent as a JSON number - kept as its literal, so no precision is lost to float64
+type Decimal string
+
+// Struct representing a v2 receipt - money as JSON numbers, and richer items
+type ReceiptV2 struct {
+	Retailer      string       `json:"retailer"`
+	PurchaseDate  string       `json:"purchaseDate"`
+	PurchaseTime  string       `json:"purchaseTime"`
+	TimeZone      string       `json:"timeZone,omitempty"`
+	Currency      string       `json:"currency,omitempty"`
+	Items         []ItemV2     `json:"items"`
+	Subtotal      Decimal      `json:"subtotal,omitempty"`
+	Tax           Decimal      `json:"tax,omitempty"`
+	Discounts     []DiscountV2 `json:"discounts,omitempty"`
+	Total         Decimal      `json:"total"`
+	PaymentMethod string       `json:"paymentMethod,omitempty"`
+	MerchantID    string       `json:"merchantId,omitempty"`
+}
+
+// Struct representing a v2 item
+type ItemV2 struct {
+	Description string  `json:"description"`
+	Category    string  `json:"category,omitempty"`
+	Quantity    Decimal `json:"quantity,omitempty"`
+	UnitPrice   Decimal `json:"unitPrice,omitempty"`
+	Price       Decimal `json:"price"`
+	SKU         string  `json:"sku,omitempty"`
+	UPC         string  `json:"upc,omitempty"`
+}
+
+// Struct representing a v2 discount
+type DiscountV2 struct {
+	Description string  `json:"description"`
+	Amount      Decimal `json:"amount"`
+}
+
+// Struct representing a stored receipt as returned by v2
+type ReceiptResourceV2 struct {
+	ID          string           `json:"id"`
+	Status      string           `json:"status"`
+	Points      *int             `json:"points,omitempty"`
+	Breakdown   []RulePoints     `json:"breakdown,omitempty"`
+	PurchasedAt *time.Time       `json:"purchasedAt,omitempty"`
+	ImageID     string           `json:"imageId,omitempty"`
+	Rejection   *ValidationError `json:"rejection,omitempty"`
+	// version of the merchant catalog the receipt was resolved against - set once it leaves the pending status
+	CatalogVersion *int       `json:"catalogVersion,omitempty"`
+	Receipt        *ReceiptV2 `json:"receipt,omitempty"`
+}
+
+// Struct representing a v2 error - a stable code, a message and the fields at fault
+type APIError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Details []ValidationError `json:"details,omitempty"`
+}
+
+// Error codes returned by v2
+const (
+	ErrCodeInvalidJSON    = "invalid_json"
+	ErrCodeInvalidReceipt = "invalid_receipt"
+	ErrCodeNotFound       = "not_found"
+	ErrCodeQueueFull      = "queue_full"
+	ErrCodeUnauthorized   = "unauthorized"
+	ErrCodeForbidden      = "forbidden"
+	ErrCodeRateLimited    = "rate_limited"
+	ErrCodeTooLarge       = "too_large"
+	ErrCodeLimitExceeded  = "limit_exceeded"
+)
+
+// Internal functions - not exported
+
+// Decode a Decimal - the literal is kept as is, and checked to be a JSON number when the receipt is converted,
+// where the full path of the field is known
+func (d *Decimal) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+	*d = Decimal(b)
+	return nil
+}
+
+// Check if a Decimal was sent as a JSON number
+func (d Decimal) isNumber() bool {
+	var n json.Number
+	return d != "" && (d[0] == '-' || (d[0] >= '0' && d[0] <= '9')) && json.Unmarshal([]byte(d), &n) == nil
+}
+
+// Encode a Decimal as a JSON number
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	if d == "" {
+		return []byte("null"), nil
+	}
+	return []byte(d), nil
+}
+
+// Convert an amount to the stored decimal string, with the currency's number of decimal places
+func amountFromDecimal(field string, d Decimal, currency CurrencyRules) (string, *ValidationError) {
+	if d == "" {
+		return "", nil
+	}
+	if !d.isNumber() {
+		return "", &ValidationError{Field: field, Reason: "must be a number"}
+	}
+	minor, err := parseMinorUnits(string(d), currency)
+	if err != nil {
+		return "", &ValidationError{Field: field, Reason: fmt.Sprintf("must be a non-negative amount with at most %d decimal places", currency.MinorUnits)}
+	}
+	return formatMinorUnits(minor, currency), nil
+}
+
+// Convert a stored amount to a Decimal - normalized, since v1 accepts leading zeros that JSON numbers do not
+func decimalFromAmount(amount string, currency CurrencyRules) Decimal {
+	if amount == "" {
+		return ""
+	}
+	minor, err := parseMinorUnits(amount, currency)
+	if err != nil {
+		return ""
+	}
+	return Decimal(formatMinorUnits(minor, currency))
+}
+
+// Convert a v2 receipt to a Receipt - amounts are checked here, everything else by the shared validation
+func (v ReceiptV2) receipt() (Receipt, *ValidationError) {
+	r := Receipt{
+		Retailer:      v.Retailer,
+		PurchaseDate:  v.PurchaseDate,
+		PurchaseTime:  v.PurchaseTime,
+		TimeZone:      v.TimeZone,
+		Currency:      v.Currency,
+		PaymentMethod: v.PaymentMethod,
+	}
+	currency, present := currencyRules(receiptCurrency(r))
+	if !present {
+		return r, &ValidationError{Field: "currency", Reason: "is not a supported currency"}
+	}
+
+	var invalid *ValidationError
+	if r.Total, invalid = amountFromDecimal("total", v.Total, currency); invalid != nil {
+		return r, invalid
+	}
+	if r.Subtotal, invalid = amountFromDecimal("subtotal", v.Subtotal, currency); invalid != nil {
+		return r, invalid
+	}
+	if r.Tax, invalid = amountFromDecimal("tax", v.Tax, currency); invalid != nil {
+		return r, invalid
+	}
+	if v.Items != nil {
+		r.Items = make([]Item, 0, len(v.Items))
+	}
+	for i, item := range v.Items {
+		field := fmt.Sprintf("items[%d]", i)
+		if item.Description == "" {
+			return r, &ValidationError{Field: field + ".description", Reason: "is required"}
+		}
+		if item.Quantity != "" && !item.Quantity.isNumber() {
+			return r, &ValidationError{Field: field + ".quantity", Reason: "must be a number"}
+		}
+		converted := Item{ShortDescription: item.Description, category: item.Category, Quantity: string(item.Quantity), SKU: item.SKU, UPC: item.UPC}
+		if converted.Price, invalid = amountFromDecimal(field+".price", item.Price, currency); invalid != nil {
+			return r, invalid
+		}
+		if converted.UnitPrice, invalid = amountFromDecimal(field+".unitPrice", item.UnitPrice, currency); invalid != nil {
+			return r, invalid
+		}
+		r.Items = append(r.Items, converted)
+	}
+	for i, d := range v.Discounts {
+		converted := Discount{Description: d.Description}
+		if converted.Amount, invalid = amountFromDecimal(fmt.Sprintf("discounts[%d].amount", i), d.Amount, currency); invalid != nil {
+			return r, invalid
+		}
+		r.Discounts = append(r.Discounts, converted)
+	}
+	return r, nil
+}
+
+// Convert a stored Receipt to a v2 receipt
+func receiptV2(r Receipt) ReceiptV2 {
+	currency, _ := currencyRules(receiptCurrency(r))
+	v := ReceiptV2{
+		Retailer:      r.Retailer,
+		PurchaseDate:  r.PurchaseDate,
+		PurchaseTime:  r.PurchaseTime,
+		TimeZone:      r.TimeZone,
+		Currency:      receiptCurrency(r),
+		Items:         make([]ItemV2, 0, len(r.Items)),
+		Subtotal:      decimalFromAmount(r.Subtotal, currency),
+		Tax:           decimalFromAmount(r.Tax, currency),
+		Total:         decimalFromAmount(r.Total, currency),
+		PaymentMethod: r.PaymentMethod,
+		MerchantID:    r.MerchantID,
+	}
+	for _, item := range r.Items {
+		v.Items = append(v.Items, ItemV2{
+			Description: item.ShortDescription,
+			Category:    item.category,
+			Quantity:    Decimal(strconv.FormatFloat(itemQuantity(item), 'f', -1, 64)),
+			UnitPrice:   decimalFromAmount(item.UnitPrice, currency),
+			Price:       decimalFromAmount(item.Price, currency),
+			SKU:         item.SKU,
+			UPC:         item.UPC,
+		})
+	}
+	for _, d := range r.Discounts {
+		v.Discounts = append(v.Discounts, DiscountV2{Description: d.Description, Amount: decimalFromAmount(d.Amount, currency)})
+	}
+	return v
+}
+
+// Build the v2 resource for a stored receipt - the receipt itself is only included when asked for
+func receiptResourceV2(id string, rp ReceiptPoints, withReceipt bool) ReceiptResourceV2 {
+	resource := ReceiptResourceV2{ID: id, Status: rp.Status, ImageID: rp.ImageID, Rejection: rp.Rejection}
+	if rp.Status == StatusProcessed || rp.Status == StatusVoided {
+		points := rp.Points
+		resource.Points = &points
+		resource.Breakdown = rp.Breakdown
+	}
+	if !rp.PurchasedAt.IsZero() {
+		purchasedAt := rp.PurchasedAt
+		resource.PurchasedAt = &purchasedAt
+	}
+	if rp.Status != StatusPending {
+		catalogVersion := rp.CatalogVersion
+		resource.CatalogVersion = &catalogVersion
+	}
+	if withReceipt && rp.Status != StatusPending {
+		receipt := receiptV2(rp.Receipt)
+		resource.Receipt = &receipt
+	}
+	return resource
+}
+
+// Respond with a v2 error
+func respondErrorV2(c *gin.Context, status int, code string, message string, details ...ValidationError) {
+	c.JSON(status, gin.H{"error": APIError{Code: code, Message: message, Details: details}})
+}
+
+// Describe a JSON decoding error - which field has the wrong type, if the decoder knows
+func jsonErrorDetails(err error) []ValidationError {
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) || typeErr.Field == "" {
+		return nil
+	}
+	reason := "has the wrong type"
+	switch typeErr.Type.Kind() {
+	case reflect.String:
+		reason = "must be a string"
+	case reflect.Slice:
+		reason = "must be an array"
+	case reflect.Struct:
+		reason = "must be an object"
+	}
+	return []ValidationError{{Field: typeErr.Field, Reason: reason}}
+}
+
+// Internal Route Functions
+
+// Path: /v2/receipts/process
+// Method: POST
+// Payload: ReceiptV2 JSON - money as JSON numbers
+// Response: 201 with the receipt's id, status and points, and a Location header. 202 and a pending status when processed asynchronously.
+// Description: The v2 contract for /receipts/process - same validation, scoring and storage, with structured errors.
+func processReceiptV2(c *gin.Context) {
+	var v ReceiptV2
+	if err := bindJSON(c, &v); err != nil {
+		var limit *limitError
+		switch {
+		case errors.Is(err, errBodyTooLarge):
+			tooLarge(c)
+		case errors.As(err, &limit):
+			respondErrorV2(c, http.StatusBadRequest, ErrCodeLimitExceeded, "The request body exceeds a limit", limit.ValidationError)
+		default:
+			respondErrorV2(c, http.StatusBadRequest, ErrCodeInvalidJSON, "The request body is not a valid receipt", jsonErrorDetails(err)...)
+		}
+		return
+	}
+	r, invalid := v.receipt()
+	if invalid != nil {
+		respondErrorV2(c, http.StatusUnprocessableEntity, ErrCodeInvalidReceipt, "The receipt is invalid", *invalid)
+		return
+	}
+
+	// asynchronous mode - queue the receipt and return its ID straight away
+	if wantsAsync(c) {
+		id, queued := enqueueReceipt(c.Request.Context(), ReceiptPoints{Receipt: r}, func(ctx context.Context) (Receipt, error) { return r, nil })
+		if !queued {
+			c.Header("Retry-After", "1")
+			respondErrorV2(c, http.StatusServiceUnavailable, ErrCodeQueueFull, "The processing queue is full")
+			return
+		}
+		c.Header("Location", "/v2/receipts/"+id)
+		c.JSON(http.StatusAccepted, ReceiptResourceV2{ID: id, Status: StatusPending})
+		return
+	}
+
+	// validate, score and store receipt
+	id, err := submitReceipt(c.Request.Context(), r)
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		respondErrorV2(c, http.StatusUnprocessableEntity, ErrCodeInvalidReceipt, "The receipt is invalid", *validationErr)
+		return
+	}
+	rp, _ := rs.get(id)
+	c.Header("Location", "/v2/receipts/"+id)
+	c.JSON(http.StatusCreated, receiptResourceV2(id, rp, false))
+}
+
+// Path: /v2/receipts/{id}
+// Method: GET
+// Response: The stored receipt with its status, points, per-rule breakdown and - if rejected - why.
+func getReceiptV2(c *gin.Context) {
+	id := c.Param("id")
+	rp, present := rs.get(id)
+	if !present || !canAccess(c.Request.Context(), rp.Owner) {
+		respondErrorV2(c, http.StatusNotFound, ErrCodeNotFound, "No receipt found for that id")
+		return
+	}
+	c.JSON(http.StatusOK, receiptResourceV2(id, rp, true))
+}
+
+// Path: /v2/receipts/{id}/points
+// Method: GET
+// Response: The receipt's status, points and per-rule breakdown. Unlike v1, pending receipts are returned with 200.
+func getPointsV2(c *gin.Context) {
+	id := c.Param("id")
+	rp, present := rs.get(id)
+	if !present || !canAccess(c.Request.Context(), rp.Owner) {
+		respondErrorV2(c, http.StatusNotFound, ErrCodeNotFound, "No receipt found for that id")
+		return
+	}
+	c.JSON(http.StatusOK, receiptResourceV2(id, rp, false))
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// body_valid_2 in the v2 contract
+var body_v2_valid_2 = []byte(`{
+	"retailer": "M&M Corner Market",
+	"purchaseDate": "2022-03-20",
+	"purchaseTime": "14:33",
+	"items": [
+		{"description": "Gatorade", "category": "Beverages", "quantity": 2, "unitPrice": 2.25, "price": 4.5, "upc": "012000161155"},
+		{"description": "Gatorade", "price": 2.25},
+		{"description": "Gatorade", "price": 2.25}
+	],
+	"subtotal": 9,
+	"tax": 0,
+	"total": 9.00,
+	"paymentMethod": "debit"
+}`)
+
+// decode a v2 error response
+func decodeErrorV2(t *testing.T, body []byte) APIError {
+	var resp struct{ Error APIError }
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Error
+}
+
+func TestProcessReceiptV2(t *testing.T) {
+	w := doRequest(t, http.MethodPost, "/v2/receipts/process", body_v2_valid_2)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var resp ReceiptResourceV2
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "/v2/receipts/"+resp.ID, w.Header().Get("Location"))
+	assert.Equal(t, StatusProcessed, resp.Status)
+	// three item lines rather than four, so one pair fewer than body_valid_2
+	assert.Equal(t, body_valid_2_pts-5, *resp.Points)
+	assert.NotEmpty(t, resp.Breakdown)
+
+	// stored as v1 amounts, so v1 reads it too
+	rp, _ := rs.get(resp.ID)
+	assert.Equal(t, "9.00", rp.Receipt.Total)
+	assert.Equal(t, "4.50", rp.Receipt.Items[0].Price)
+	assert.Equal(t, "Beverages", rp.Receipt.Items[0].category)
+	w = doRequest(t, http.MethodGet, "/receipts/"+resp.ID+"/points", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// v2 returns the receipt with numeric money
+	w = doRequest(t, http.MethodGet, "/v2/receipts/"+resp.ID, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var full map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &full)
+	receipt := full["receipt"].(map[string]interface{})
+	assert.Equal(t, 9.0, receipt["total"])
+	assert.Equal(t, "USD", receipt["currency"])
+	item := receipt["items"].([]interface{})[1].(map[string]interface{})
+	assert.Equal(t, 2.25, item["price"])
+	assert.Equal(t, 1.0, item["quantity"])
+}
+
+func TestReceiptsV2_Read_V1_Receipt(t *testing.T) {
+	w := doRequest(t, http.MethodPost, "/receipts/process", body_valid_1)
+	var created map[string]string
+	json.Unmarshal(w.Body.Bytes(), &created)
+
+	w = doRequest(t, http.MethodGet, "/v2/receipts/"+created["id"]+"/points", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp ReceiptResourceV2
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, body_valid_1_pts, *resp.Points)
+	assert.Nil(t, resp.Receipt)
+
+	w = doRequest(t, http.MethodGet, "/v2/receipts/unknown", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, ErrCodeNotFound, decodeErrorV2(t, w.Body.Bytes()).Code)
+}
+
+func TestProcessReceiptV2_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+		field  string
+	}{
+		{"malformed", `{"retailer": `, http.StatusBadRequest, ErrCodeInvalidJSON, ""},
+		{"string retailer", `{"retailer": 7}`, http.StatusBadRequest, ErrCodeInvalidJSON, "retailer"},
+		{"string money", `{"retailer": "Target", "total": "1.25"}`, http.StatusUnprocessableEntity, ErrCodeInvalidReceipt, "total"},
+		{"string item price", `{"retailer": "Target", "items": [{"description": "Pepsi", "price": "1.25"}], "total": 1.25}`, http.StatusUnprocessableEntity, ErrCodeInvalidReceipt, "items[0].price"},
+		{"string quantity", `{"retailer": "Target", "items": [{"description": "Pepsi", "price": 1.25, "quantity": "1"}], "total": 1.25}`, http.StatusUnprocessableEntity, ErrCodeInvalidReceipt, "items[0].quantity"},
+		{"negative total", `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "items": [{"description": "Pepsi", "price": 1.25}], "total": -1.25}`, http.StatusUnprocessableEntity, ErrCodeInvalidReceipt, "total"},
+		{"too many decimals", `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "items": [{"description": "Pepsi", "price": 1.255}], "total": 1.25}`, http.StatusUnprocessableEntity, ErrCodeInvalidReceipt, "items[0].price"},
+		{"missing description", `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "items": [{"price": 1.25}], "total": 1.25}`, http.StatusUnprocessableEntity, ErrCodeInvalidReceipt, "items[0].description"},
+		{"bad date", `{"retailer": "Target", "purchaseDate": "2022-13-01", "purchaseTime": "13:01", "items": [{"description": "Pepsi", "price": 1.25}], "total": 1.25}`, http.StatusUnprocessableEntity, ErrCodeInvalidReceipt, "purchaseDate"},
+		{"bad upc", `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "items": [{"description": "Pepsi", "price": 1.25, "upc": "012000161156"}], "total": 1.25}`, http.StatusUnprocessableEntity, ErrCodeInvalidReceipt, "items[0].upc"},
+		{"unknown currency", `{"retailer": "Target", "currency": "XYZ", "total": 1.25}`, http.StatusUnprocessableEntity, ErrCodeInvalidReceipt, "currency"},
+	}
+	for _, tt := range tests {
+		w := doRequest(t, http.MethodPost, "/v2/receipts/process", []byte(tt.body))
+		assert.Equal(t, tt.status, w.Code, tt.name)
+		apiErr := decodeErrorV2(t, w.Body.Bytes())
+		assert.Equal(t, tt.code, apiErr.Code, tt.name)
+		if tt.field == "" {
+			assert.Empty(t, apiErr.Details, tt.name)
+		} else if assert.Len(t, apiErr.Details, 1, tt.name) {
+			assert.Equal(t, tt.field, apiErr.Details[0].Field, tt.name)
+		}
+	}
+}
+
+func TestCheckReceipt_Reasons(t *testing.T) {
+	r := detailedReceipt()
+	assert.Nil(t, checkReceipt(r))
+
+	r.Items[0].Quantity = "0"
+	assert.Equal(t, &ValidationError{Field: "items[0].quantity", Reason: "must be a positive number with up to 3 decimal places"}, checkReceipt(r))
+
+	r = detailedReceipt()
+	r.Subtotal = "1.00"
+	assert.Equal(t, "subtotal", checkReceipt(r).Field)
+
+	// the rejection is kept with the stored receipt
+	rp, valid := evaluateReceipt(context.Background(), Receipt{})
+	assert.False(t, valid)
+	assert.Equal(t, &ValidationError{Field: "retailer", Reason: "is required"}, rp.Rejection)
+}
+
+func TestFormatMinorUnits(t *testing.T) {
+	assert.Equal(t, "9.00", formatMinorUnits(900, currencies["USD"]))
+	assert.Equal(t, "0.05", formatMinorUnits(5, currencies["USD"]))
+	assert.Equal(t, "1500", formatMinorUnits(1500, currencies["JPY"]))
+	assert.Equal(t, "0.250", formatMinorUnits(250, currencies["BHD"]))
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Receipt event types
+const (
+	EventReceiptScored   = "receipt.scored"
+	EventReceiptRejected = "receipt.rejected"
+	EventReceiptVoided   = "receipt.voided"
+)
+
+// Struct definitions & constructors
+
+// Struct representing a receipt event - the payload posted to webhook subscribers
+type ReceiptEvent struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"createdAt"`
+	Data      struct {
+		ReceiptID string `json:"receiptId"`
+		Status    string `json:"status"`
+		Points    int    `json:"points"`
+	} `json:"data"`
+	// who submitted the receipt - only subscribers who can see it get the event
+	owner Owner
+}
+
+// Struct representing a webhook subscription
+type Subscription struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// shared secret used to sign payloads - only returned when the subscription is created
+	Secret string `json:"secret,omitempty"`
+	// event types to deliver - all events if empty
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"createdAt"`
+	// who subscribed - clients and end users only get events for their own receipts
+	Owner
+	// subscribed with the admin scope, or with authentication off - gets events for every receipt
+	admin bool
+}
+
+// Struct representing a single delivery attempt
+type DeliveryAttempt struct {
+	SubscriptionID string    `json:"subscriptionId"`
+	EventID        string    `json:"eventId"`
+	EventType      string    `json:"eventType"`
+	Attempt        int       `json:"attempt"`
+	StatusCode     int       `json:"statusCode,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	Success        bool      `json:"success"`
+	At             time.Time `json:"at"`
+}
+
+// Struct representing an event that could not be delivered after every retry
+type DeadLetter struct {
+	SubscriptionID string       `json:"subscriptionId"`
+	Event          ReceiptEvent `json:"event"`
+	Attempts       int          `json:"attempts"`
+	LastError      string       `json:"lastError"`
+	FailedAt       time.Time    `json:"failedAt"`
+	// the subscription's owner, who may list it
+	owner Owner
+}
+
+// Struct representing an event waiting for a delivery worker
+type webhookDelivery struct {
+	subscription Subscription
+	event        ReceiptEvent
+}
+
+// Struct representing Webhooks - subscriptions, the delivery log and the dead-letter list
+type Webhooks struct {
+	mu sync.RWMutex
+	// store a map of subscriptions accessed via ID
+	SubscriptionsMap map[string]Subscription `json:"subscriptions"`
+	deliveries       map[string][]DeliveryAttempt
+	deadLetters      []DeadLetter
+	// events waiting for a worker, and the deliveries not yet finished
+	pending  chan webhookDelivery
+	inFlight sync.WaitGroup
+	workers  sync.WaitGroup
+	client   *http.Client
+	// attempts per event, and the delay before the first retry - doubled after each failed attempt
+	maxAttempts int
+	backoff     time.Duration
+	// set once draining - events published after that are not delivered
+	closed bool
+	// cancelled once a drain runs out of time - deliveries in progress stop, and retries give up
+	ctx    context.Context
+	cancel context.CancelFunc
+	// allow callback URLs on loopback and private networks - off, so subscribers cannot reach internal services
+	allowPrivateURLs bool
+}
+
+// Constructor for Webhooks - deliveries wait until the workers are started
+func NewWebhooks() *Webhooks {
+	var wh Webhooks
+	wh.SubscriptionsMap = make(map[string]Subscription)
+	wh.deliveries = make(map[string][]DeliveryAttempt)
+	wh.pending = make(chan webhookDelivery, webhookQueueSize)
+	wh.ctx, wh.cancel = context.WithCancel(context.Background())
+	// addresses are checked as they are dialed, so DNS names and redirects cannot lead to private networks either
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: func(network string, address string, _ syscall.RawConn) error {
+		if wh.allowPrivateURLs {
+			return nil
+		}
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); ip == nil || privateAddress(ip) {
+			return errPrivateAddress
+		}
+		return nil
+	}}
+	wh.client = &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{DialContext: dialer.DialContext}}
+	wh.maxAttempts = 5
+	wh.backoff = time.Second
+	return &wh
+}
+
+// Internal data
+
+// Global webhooks object - in place of persisting data
+var webhooks = NewWebhooks() // pointer to Webhooks object
+
+// Most delivery attempts kept per subscription
+const maxDeliveryLog = 100
+
+// Most undeliverable events kept - the oldest are dropped first
+const maxDeadLetters = 1000
+
+// Events waiting for a delivery worker, and the workers delivering them
+const (
+	webhookQueueSize = 1000
+	webhookWorkers   = 8
+)
+
+// Error for callbacks to loopback, private and link-local addresses
+var errPrivateAddress = errors.New("callback address is not public")
+
+// Internal functions - not exported
+
+// Publish a receipt event to the event stream and webhook subscribers - called whenever a receipt is scored, rejected or voided
+func publishReceiptEvent(eventType string, id string, rp ReceiptPoints) {
+	var event ReceiptEvent
+	event.ID = uuid.New().String()
+	event.Type = eventType
+	event.CreatedAt = now().UTC()
+	event.Data.ReceiptID = id
+	event.Data.Status = rp.Status
+	event.Data.Points = rp.Points
+	event.owner = rp.Owner
+	receiptEvents.publish(event)
+	webhooks.dispatch(event)
+}
+
+// Sign a payload - hex HMAC-SHA256 over "<timestamp>.<body>"
+func signPayload(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Generate a random subscription secret
+func newSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return "whsec_" + hex.EncodeToString(b)
+}
+
+// Check if a subscription wants an event type
+func (s Subscription) wants(eventType string) bool {
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, e := range s.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if a subscription gets an event - one it wants, for a receipt its owner can see
+func (s Subscription) receives(event ReceiptEvent) bool {
+	switch {
+	case !s.wants(event.Type):
+		return false
+	case s.admin:
+		return true
+	case s.UserID != "":
+		return event.owner.UserID == s.UserID
+	default:
+		return event.owner.ClientID == s.ClientID
+	}
+}
+
+// Check if an address is loopback, private, link-local or otherwise not on the public internet
+func privateAddress(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// Check a callback URL is http(s) and, unless private URLs are allowed, not obviously internal
+// Names are only resolved when dialed, where the address is checked again
+func (wh *Webhooks) validCallback(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return false
+	}
+	if wh.allowPrivateURLs {
+		return true
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil && privateAddress(ip) {
+		return false
+	}
+	return true
+}
+
+// Start the delivery workers
+func (wh *Webhooks) start(workers int) {
+	for i := 0; i < workers; i++ {
+		wh.workers.Add(1)
+		go wh.work()
+	}
+}
+
+// Worker loop - deliver events until the webhooks are stopped
+func (wh *Webhooks) work() {
+	defer wh.workers.Done()
+	for d := range wh.pending {
+		wh.deliver(d.subscription, d.event)
+	}
+}
+
+// Stop accepting events and wait for the workers to deliver those already queued, or for ctx to be done
+// Returns ctx's error if deliveries were left unfinished - those still retrying, or queued, go to the dead-letter list
+func (wh *Webhooks) drain(ctx context.Context) error {
+	wh.mu.Lock()
+	if !wh.closed {
+		close(wh.pending)
+		wh.closed = true
+	}
+	wh.mu.Unlock()
+	err := waitFor(ctx, &wh.workers)
+	if err != nil {
+		// deliveries give up straight away once cancelled, so the workers finish promptly
+		wh.cancel()
+		wh.workers.Wait()
+	}
+	return err
+}
+
+// Queue an event for every subscription that gets it
+// Events that find the queue full go straight to the dead-letter list, rather than holding up the request
+func (wh *Webhooks) dispatch(event ReceiptEvent) {
+	wh.mu.RLock()
+	if wh.closed {
+		wh.mu.RUnlock()
+		return
+	}
+	var full []Subscription
+	for _, s := range wh.SubscriptionsMap {
+		if !s.receives(event) {
+			continue
+		}
+		wh.inFlight.Add(1)
+		select {
+		case wh.pending <- webhookDelivery{subscription: s, event: event}:
+		default:
+			wh.inFlight.Done()
+			full = append(full, s)
+		}
+	}
+	wh.mu.RUnlock()
+	for _, s := range full {
+		wh.deadLetter(DeadLetter{SubscriptionID: s.ID, Event: event, LastError: "delivery queue full", FailedAt: now().UTC(), owner: s.Owner})
+	}
+}
+
+// Deliver an event to a subscription, retrying with exponential backoff
+// Events still undelivered after the last attempt go to the dead-letter list
+func (wh *Webhooks) deliver(s Subscription, event ReceiptEvent) {
+	defer wh.inFlight.Done()
+	body, _ := json.Marshal(event)
+	delay := wh.backoff
+	lastError := ""
+	attempts := 0
+	for attempts < wh.maxAttempts {
+		if wh.ctx.Err() != nil {
+			lastError = "gave up while shutting down"
+			break
+		}
+		if attempts > 0 && !wh.pause(delay) {
+			lastError = "gave up while shutting down, after: " + lastError
+			break
+		}
+		delay *= 2
+		attempts++
+		statusCode, err := wh.post(s, event, body)
+		log := DeliveryAttempt{SubscriptionID: s.ID, EventID: event.ID, EventType: event.Type, Attempt: attempts, StatusCode: statusCode, At: now().UTC()}
+		switch {
+		case err != nil:
+			log.Error = err.Error()
+		case statusCode < 200 || statusCode > 299:
+			log.Error = fmt.Sprintf("unexpected status %d", statusCode)
+		default:
+			log.Success = true
+		}
+		wh.record(log)
+		if log.Success {
+			return
+		}
+		lastError = log.Error
+	}
+	wh.deadLetter(DeadLetter{SubscriptionID: s.ID, Event: event, Attempts: attempts, LastError: lastError, FailedAt: now().UTC(), owner: s.Owner})
+}
+
+// Wait before a retry - false if the webhooks were cancelled first
+func (wh *Webhooks) pause(delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-wh.ctx.Done():
+		return false
+	}
+}
+
+// Add an event to the dead-letter list, keeping the most recent
+func (wh *Webhooks) deadLetter(letter DeadLetter) {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	wh.deadLetters = append(wh.deadLetters, letter)
+	if len(wh.deadLetters) > maxDeadLetters {
+		wh.deadLetters = append([]DeadLetter{}, wh.deadLetters[len(wh.deadLetters)-maxDeadLetters:]...)
+	}
+}
+
+// Post a signed payload to a subscription's URL - returns the response status code
+func (wh *Webhooks) post(s Subscription, event ReceiptEvent, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(wh.ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Id", event.ID)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+signPayload(s.Secret, timestamp, body))
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// Record a delivery attempt, keeping the most recent attempts per subscription
+// Attempts for a subscription deleted while in flight are dropped
+func (wh *Webhooks) record(attempt DeliveryAttempt) {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	if _, present := wh.SubscriptionsMap[attempt.SubscriptionID]; !present {
+		return
+	}
+	log := append(wh.deliveries[attempt.SubscriptionID], attempt)
+	if len(log) > maxDeliveryLog {
+		log = log[len(log)-maxDeliveryLog:]
+	}
+	wh.deliveries[attempt.SubscriptionID] = log
+}
+
+// Wait for every queued and in-flight delivery to finish
+func (wh *Webhooks) wait() {
+	wh.inFlight.Wait()
+}
+
+// Configure webhooks from the environment - WEBHOOK_ALLOW_PRIVATE_URLS=true allows callbacks to internal addresses
+func configureWebhooksFromEnv() error {
+	if raw := os.Getenv("WEBHOOK_ALLOW_PRIVATE_URLS"); raw != "" {
+		allow, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid WEBHOOK_ALLOW_PRIVATE_URLS %q - expected true or false", raw)
+		}
+		webhooks.allowPrivateURLs = allow
+	}
+	return nil
+}
+
+// Internal Route Functions
+
+// Path: /webhooks
+// Method: POST
+// Payload: Subscription JSON - url, and optionally events and secret
+// Response: The created subscription, including its secret.
+// Description: Subscribes a URL to receipt events - for the caller's own receipts, or every receipt with the admin scope. Payloads are signed with the secret - see the README.
+func createSubscription(c *gin.Context) {
+	var s Subscription
+	if err := bindJSON(c, &s); err != nil {
+		if errors.Is(err, errBodyTooLarge) {
+			tooLarge(c)
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"description": "The subscription is invalid"})
+		return
+	}
+	if !webhooks.validCallback(s.URL) {
+		c.JSON(http.StatusBadRequest, gin.H{"description": "The subscription is invalid"})
+		return
+	}
+	for _, e := range s.Events {
+		if e != EventReceiptScored && e != EventReceiptRejected && e != EventReceiptVoided {
+			c.JSON(http.StatusBadRequest, gin.H{"description": "The subscription is invalid"})
+			return
+		}
+	}
+	if s.Events == nil {
+		s.Events = []string{}
+	}
+	if s.Secret == "" {
+		s.Secret = newSecret()
+	}
+	s.ID = uuid.New().String()
+	s.CreatedAt = now().UTC()
+	s.Owner = ownerFrom(c.Request.Context())
+	s.admin = allowed(c.Request.Context(), ScopeAdmin)
+
+	webhooks.mu.Lock()
+	webhooks.SubscriptionsMap[s.ID] = s
+	webhooks.mu.Unlock()
+	c.JSON(http.StatusCreated, s)
+}
+
+// Path: /webhooks
+// Method: GET
+// Response: JSON array of the caller's subscriptions - every subscription with the admin scope - without their secrets.
+func listSubscriptions(c *gin.Context) {
+	webhooks.mu.RLock()
+	list := make([]Subscription, 0, len(webhooks.SubscriptionsMap))
+	for _, s := range webhooks.SubscriptionsMap {
+		if !canAccess(c.Request.Context(), s.Owner) {
+			continue
+		}
+		s.Secret = ""
+		list = append(list, s)
+	}
+	webhooks.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	c.JSON(http.StatusOK, gin.H{"subscriptions": list})
+}
+
+// Path: /webhooks/{id}
+// Method: DELETE
+// Description: Unsubscribes, and drops the subscription's delivery log. Deliveries already in flight still finish.
+func deleteSubscription(c *gin.Context) {
+	id := c.Param("id")
+	webhooks.mu.Lock()
+	s, present := webhooks.SubscriptionsMap[id]
+	present = present && canAccess(c.Request.Context(), s.Owner)
+	if present {
+		delete(webhooks.SubscriptionsMap, id)
+		delete(webhooks.deliveries, id)
+	}
+	webhooks.mu.Unlock()
+	if !present {
+		c.JSON(http.StatusNotFound, gin.H{"description": "No subscription found for that id"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Path: /webhooks/{id}/deliveries
+// Method: GET
+// Response: JSON array of the most recent delivery attempts for the subscription, oldest first.
+func listDeliveries(c *gin.Context) {
+	id := c.Param("id")
+	webhooks.mu.RLock()
+	s, present := webhooks.SubscriptionsMap[id]
+	log := append([]DeliveryAttempt{}, webhooks.deliveries[id]...)
+	webhooks.mu.RUnlock()
+	if !present || !canAccess(c.Request.Context(), s.Owner) {
+		c.JSON(http.StatusNotFound, gin.H{"description": "No subscription found for that id"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": log})
+}
+
+// Path: /webhooks/dead-letters
+// Method: GET
+// Response: JSON array of events that could not be delivered to the caller's subscriptions after every retry, oldest first.
+func listDeadLetters(c *gin.Context) {
+	webhooks.mu.RLock()
+	list := []DeadLetter{}
+	for _, letter := range webhooks.deadLetters {
+		if canAccess(c.Request.Context(), letter.owner) {
+			list = append(list, letter)
+		}
+	}
+	webhooks.mu.RUnlock()
+	c.JSON(http.StatusOK, gin.H{"deadLetters": list})
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// a delivery received by a test subscriber
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+// use fresh webhooks with fast retries for the duration of a test
+func withWebhooks(t *testing.T) *Webhooks {
+	wh := NewWebhooks()
+	wh.backoff = time.Millisecond
+	wh.maxAttempts = 3
+	// test subscribers listen on loopback
+	wh.allowPrivateURLs = true
+	wh.start(2)
+	previous := webhooks
+	webhooks = wh
+	t.Cleanup(func() {
+		wh.drain(context.Background())
+		webhooks = previous
+	})
+	return wh
+}
+
+// start a subscriber that answers with the given status codes in turn, then 200
+func newSubscriber(t *testing.T, statuses ...int) (*httptest.Server, func() []receivedWebhook) {
+	var mu sync.Mutex
+	var received []receivedWebhook
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		received = append(received, receivedWebhook{header: r.Header.Clone(), body: body})
+		n := len(received)
+		mu.Unlock()
+		if n <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []receivedWebhook {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]receivedWebhook{}, received...)
+	}
+}
+
+// subscribe a URL and return the created subscription
+func subscribe(t *testing.T, body string) Subscription {
+	w := doRequest(t, http.MethodPost, "/webhooks", []byte(body))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var s Subscription
+	if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestWebhooks_Scored_And_Voided(t *testing.T) {
+	wh := withWebhooks(t)
+	server, received := newSubscriber(t)
+	s := subscribe(t, `{"url": "`+server.URL+`"}`)
+	assert.True(t, strings.HasPrefix(s.Secret, "whsec_"))
+
+	w := doRequest(t, http.MethodPost, "/receipts/process", body_valid_2)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp map[string]string
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	wh.wait()
+
+	w = doRequest(t, http.MethodPost, "/receipts/"+resp["id"]+"/void", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	wh.wait()
+
+	deliveries := received()
+	if assert.Len(t, deliveries, 2) {
+		// payload is signed with the subscription secret
+		d := deliveries[0]
+		signature := signPayload(s.Secret, d.header.Get("X-Webhook-Timestamp"), d.body)
+		assert.Equal(t, "sha256="+signature, d.header.Get("X-Webhook-Signature"))
+
+		var event ReceiptEvent
+		json.Unmarshal(d.body, &event)
+		assert.Equal(t, EventReceiptScored, event.Type)
+		assert.Equal(t, event.ID, d.header.Get("X-Webhook-Id"))
+		assert.Equal(t, resp["id"], event.Data.ReceiptID)
+		assert.Equal(t, body_valid_2_pts, event.Data.Points)
+
+		json.Unmarshal(deliveries[1].body, &event)
+		assert.Equal(t, EventReceiptVoided, event.Type)
+		assert.Equal(t, StatusVoided, event.Data.Status)
+	}
+
+	// voided receipts have no points on v1, and cannot be voided twice
+	w = doRequest(t, http.MethodGet, "/receipts/"+resp["id"]+"/points", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.JSONEq(t, `{"description": "The receipt was voided"}`, w.Body.String())
+	w = doRequest(t, http.MethodPost, "/receipts/"+resp["id"]+"/void", nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = doRequest(t, http.MethodPost, "/receipts/unknown/void", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// delivery log
+	w = doRequest(t, http.MethodGet, "/webhooks/"+s.ID+"/deliveries", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var log map[string][]DeliveryAttempt
+	json.Unmarshal(w.Body.Bytes(), &log)
+	if assert.Len(t, log["deliveries"], 2) {
+		assert.True(t, log["deliveries"][0].Success)
+		assert.Equal(t, http.StatusOK, log["deliveries"][0].StatusCode)
+	}
+}
+
+func TestWebhooks_Rejected_Event_Filter(t *testing.T) {
+	wh := withWebhooks(t)
+	withQueue(t, 10, 1)
+	server, received := newSubscriber(t)
+	subscribe(t, `{"url": "`+server.URL+`", "events": ["receipt.rejected"]}`)
+
+	// scored receipts are filtered out
+	doRequest(t, http.MethodPost, "/receipts/process", body_valid_1)
+	w := doRequest(t, http.MethodPost, "/receipts/process?async=true", body_bad_negative_total)
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	waitForStatus(t, resp["id"].(string))
+	wh.wait()
+
+	deliveries := received()
+	if assert.Len(t, deliveries, 1) {
+		var event ReceiptEvent
+		json.Unmarshal(deliveries[0].body, &event)
+		assert.Equal(t, EventReceiptRejected, event.Type)
+		assert.Equal(t, resp["id"], event.Data.ReceiptID)
+	}
+}
+
+func TestWebhooks_Retry_And_Dead_Letter(t *testing.T) {
+	wh := withWebhooks(t)
+
+	// fails twice, then succeeds on the last attempt
+	flaky, flakyReceived := newSubscriber(t, http.StatusInternalServerError, http.StatusBadGateway)
+	subscribe(t, `{"url": "`+flaky.URL+`"}`)
+	// always fails
+	down, _ := newSubscriber(t, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	dead := subscribe(t, `{"url": "`+down.URL+`", "secret": "s3cret"}`)
+
+	doRequest(t, http.MethodPost, "/receipts/process", body_valid_1)
+	wh.wait()
+	assert.Len(t, flakyReceived(), 3)
+
+	w := doRequest(t, http.MethodGet, "/webhooks/dead-letters", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp map[string][]DeadLetter
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if assert.Len(t, resp["deadLetters"], 1) {
+		letter := resp["deadLetters"][0]
+		assert.Equal(t, dead.ID, letter.SubscriptionID)
+		assert.Equal(t, 3, letter.Attempts)
+		assert.Equal(t, "unexpected status 503", letter.LastError)
+		assert.Equal(t, EventReceiptScored, letter.Event.Type)
+	}
+}
+
+func TestWebhooks_Subscriptions(t *testing.T) {
+	withWebhooks(t)
+
+	// invalid URL or event
+	w := doRequest(t, http.MethodPost, "/webhooks", []byte(`{"url": "ftp://example.com"}`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = doRequest(t, http.MethodPost, "/webhooks", []byte(`{"url": "https://example.com", "events": ["receipt.eaten"]}`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	s := subscribe(t, `{"url": "https://example.com/hooks", "secret": "s3cret"}`)
+	assert.Equal(t, "s3cret", s.Secret)
+
+	// secrets are never listed
+	w = doRequest(t, http.MethodGet, "/webhooks", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "s3cret")
+	assert.Contains(t, w.Body.String(), s.ID)
+
+	w = doRequest(t, http.MethodDelete, "/webhooks/"+s.ID, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = doRequest(t, http.MethodDelete, "/webhooks/"+s.ID, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = doRequest(t, http.MethodGet, "/webhooks/"+s.ID+"/deliveries", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestWebhooks_Scoped_To_Owner(t *testing.T) {
+	wh := withWebhooks(t)
+	withAPIKeys(t)
+	withReceipts(t)
+	server, received := newSubscriber(t)
+	ops, opsReceived := newSubscriber(t)
+
+	// clients with the submit scope subscribe to their own receipts, admins to everyone's
+	body := []byte(`{"url": "` + server.URL + `", "clientId": "partner-b"}`)
+	w := doKeyRequest(t, http.MethodPost, "/webhooks", body, "key-a")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var s Subscription
+	json.Unmarshal(w.Body.Bytes(), &s)
+	assert.Equal(t, "partner-a", s.ClientID)
+	assert.Equal(t, http.StatusCreated, doKeyRequest(t, http.MethodPost, "/webhooks", []byte(`{"url": "`+ops.URL+`"}`), "key-ops").Code)
+	assert.Equal(t, http.StatusForbidden, doKeyRequest(t, http.MethodPost, "/webhooks", body, "key-reader").Code)
+
+	doKeyRequest(t, http.MethodPost, "/receipts/process", body_valid_1, "key-a")
+	doKeyRequest(t, http.MethodPost, "/receipts/process", body_valid_2, "key-b")
+	wh.wait()
+	assert.Len(t, received(), 1)
+	assert.Len(t, opsReceived(), 2)
+
+	// and only see their own subscriptions
+	w = doKeyRequest(t, http.MethodGet, "/webhooks", nil, "key-b")
+	assert.JSONEq(t, `{"subscriptions": []}`, w.Body.String())
+	w = doKeyRequest(t, http.MethodGet, "/webhooks", nil, "key-a")
+	assert.Contains(t, w.Body.String(), s.ID)
+	assert.Equal(t, http.StatusNotFound, doKeyRequest(t, http.MethodGet, "/webhooks/"+s.ID+"/deliveries", nil, "key-b").Code)
+	assert.Equal(t, http.StatusNotFound, doKeyRequest(t, http.MethodDelete, "/webhooks/"+s.ID, nil, "key-b").Code)
+	assert.Equal(t, http.StatusOK, doKeyRequest(t, http.MethodGet, "/webhooks/"+s.ID+"/deliveries", nil, "key-ops").Code)
+
+	// deleting a subscription drops its delivery log
+	assert.Equal(t, http.StatusNoContent, doKeyRequest(t, http.MethodDelete, "/webhooks/"+s.ID, nil, "key-a").Code)
+	assert.NotContains(t, wh.deliveries, s.ID)
+}
+
+func TestWebhooks_Private_URLs(t *testing.T) {
+	wh := withWebhooks(t)
+	wh.allowPrivateURLs = false
+	for _, url := range []string{"http://127.0.0.1:8080", "http://localhost/hooks", "http://10.0.0.1", "http://169.254.169.254/latest/meta-data", "http://[::1]/"} {
+		w := doRequest(t, http.MethodPost, "/webhooks", []byte(`{"url": "`+url+`"}`))
+		assert.Equal(t, http.StatusBadRequest, w.Code, url)
+	}
+
+	// addresses are checked again when dialed, whatever name led there
+	server, received := newSubscriber(t)
+	_, err := wh.post(Subscription{ID: "s", URL: server.URL, Secret: "s3cret"}, ReceiptEvent{ID: "e"}, []byte(`{}`))
+	assert.ErrorIs(t, err, errPrivateAddress)
+	assert.Empty(t, received())
+}
+
+func TestWebhooks_Bounded(t *testing.T) {
+	wh := NewWebhooks()
+	wh.SubscriptionsMap["s"] = Subscription{ID: "s", admin: true}
+
+	// with no worker free, events past the queue's size go to the dead-letter list
+	for i := 0; i < webhookQueueSize+2; i++ {
+		wh.dispatch(ReceiptEvent{ID: strconv.Itoa(i), Type: EventReceiptScored})
+	}
+	assert.Len(t, wh.pending, webhookQueueSize)
+	if assert.Len(t, wh.deadLetters, 2) {
+		assert.Equal(t, "delivery queue full", wh.deadLetters[0].LastError)
+	}
+
+	// and the dead-letter list keeps the most recent
+	for i := 0; i < maxDeadLetters+5; i++ {
+		wh.deadLetter(DeadLetter{SubscriptionID: "s", Event: ReceiptEvent{ID: strconv.Itoa(i)}})
+	}
+	assert.Len(t, wh.deadLetters, maxDeadLetters)
+	assert.Equal(t, strconv.Itoa(maxDeadLetters+4), wh.deadLetters[maxDeadLetters-1].Event.ID)
+}
+
+func TestWebhooks_Drain(t *testing.T) {
+	wh := withWebhooks(t)
+	server, received := newSubscriber(t)
+	subscribe(t, `{"url": "`+server.URL+`"}`)
+
+	// events queued before draining are delivered, those after are dropped
+	wh.dispatch(ReceiptEvent{ID: "1", Type: EventReceiptScored})
+	assert.NoError(t, wh.drain(context.Background()))
+	wh.dispatch(ReceiptEvent{ID: "2", Type: EventReceiptScored})
+	assert.Len(t, received(), 1)
+	assert.Empty(t, wh.deadLetters)
+}
+
+func TestWebhooks_Drain_Gives_Up_Retrying(t *testing.T) {
+	wh := withWebhooks(t)
+	wh.backoff = time.Hour
+	server, received := newSubscriber(t, http.StatusInternalServerError)
+	subscribe(t, `{"url": "`+server.URL+`"}`)
+
+	// the first attempt fails, and the retry would be an hour away - a drain that runs out of time cancels it
+	wh.dispatch(ReceiptEvent{ID: "1", Type: EventReceiptScored})
+	for len(received()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, wh.drain(ctx), context.DeadlineExceeded)
+	assert.Len(t, received(), 1)
+	if assert.Len(t, wh.deadLetters, 1) {
+		assert.Equal(t, 1, wh.deadLetters[0].Attempts)
+		assert.Equal(t, "gave up while shutting down, after: unexpected status 500", wh.deadLetters[0].LastError)
+	}
+}
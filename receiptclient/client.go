@@ -0,0 +1,425 @@
+// Package receiptclient is a typed Go client for the receipt processor's REST API
+//
+// Every method takes a context, which bounds the whole call including retries. Requests that fail with
+// 429, or 503 with a Retry-After header, are retried - as are GET and DELETE requests that fail on the
+// network or with 502, 503 or 504. Error responses are returned as *Error, which matches ErrInvalid,
+// ErrUnauthorized, ErrForbidden, ErrNotFound, ErrConflict, ErrRateLimited and ErrUnavailable with errors.Is.
+package receiptclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Struct representing a client for one receipt processor service
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	// sent in the X-API-Key header, if set
+	apiKey string
+	// sent in the Authorization header, if set
+	bearerToken string
+	// requests are signed with the secret, if set
+	signingKey    string
+	signingSecret string
+	// retries after the first attempt, and the delay before the first retry - doubled after each one
+	maxRetries int
+	backoff    time.Duration
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// Use the given HTTP client, instead of one with a 30 second timeout
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// Authenticate with an API key
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+// Authenticate as an end user, with a JWT from the identity provider
+func WithBearerToken(token string) Option {
+	return func(c *Client) { c.bearerToken = token }
+}
+
+// Sign requests with a client's HMAC secret, for partners the service requires signatures from
+func WithSigningKey(name string, secret string) Option {
+	return func(c *Client) {
+		c.signingKey = name
+		c.signingSecret = secret
+	}
+}
+
+// Retry failed requests up to maxRetries times, waiting backoff before the first retry - 3 and 200ms by default
+func WithRetries(maxRetries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.backoff = backoff
+	}
+}
+
+// Constructor for Client - baseURL is the service's address, like http://localhost:8080
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		maxRetries: 3,
+		backoff:    200 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Receipts - v1
+
+// Process a receipt, returning its ID
+func (c *Client) ProcessReceipt(ctx context.Context, r Receipt) (string, error) {
+	var resp struct {
+		ID string `json:"id"`
+	}
+	_, err := c.doJSON(ctx, http.MethodPost, "/receipts/process", r, &resp)
+	return resp.ID, err
+}
+
+// Queue a receipt for processing, returning its ID - poll GetPoints until it leaves the pending status
+func (c *Client) ProcessReceiptAsync(ctx context.Context, r Receipt) (string, error) {
+	var resp struct {
+		ID string `json:"id"`
+	}
+	_, err := c.doJSON(ctx, http.MethodPost, "/receipts/process?async=true", r, &resp)
+	return resp.ID, err
+}
+
+// Read a receipt from plain text, like OCR output, and process it
+func (c *Client) ProcessTextReceipt(ctx context.Context, text string) (*ParsedReceipt, error) {
+	var resp ParsedReceipt
+	if _, err := c.do(ctx, http.MethodPost, "/receipts/process/text", "text/plain", []byte(text), &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Upload a receipt image, read it with the service's OCR provider and process it
+func (c *Client) ProcessImageReceipt(ctx context.Context, filename string, image io.Reader) (*ParsedReceipt, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("image", filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(part, image); err != nil {
+		return nil, err
+	}
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+	var resp ParsedReceipt
+	if _, err := c.do(ctx, http.MethodPost, "/receipts/process/image", form.FormDataContentType(), body.Bytes(), &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Return an uploaded receipt image and its content type
+func (c *Client) GetImage(ctx context.Context, id string) ([]byte, string, error) {
+	var image rawBody
+	if _, err := c.do(ctx, http.MethodGet, "/images/"+url.PathEscape(id), "", nil, &image); err != nil {
+		return nil, "", err
+	}
+	return image.data, image.contentType, nil
+}
+
+// Return the points awarded for a receipt - a pending receipt is returned with no points, not as an error
+func (c *Client) GetPoints(ctx context.Context, id string) (*Points, error) {
+	// v1 only answers for processed receipts, so the status is read from the v2 route
+	resource, err := c.GetPointsV2(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	points := Points{Status: resource.Status}
+	switch resource.Status {
+	case StatusProcessed:
+		if resource.Points != nil {
+			points.Points = *resource.Points
+		}
+	case StatusRejected:
+		points.Description = "The receipt is invalid"
+	case StatusVoided:
+		points.Description = "The receipt was voided"
+	}
+	return &points, nil
+}
+
+// Void a processed receipt, so its points no longer count
+func (c *Client) VoidReceipt(ctx context.Context, id string) error {
+	_, err := c.doJSON(ctx, http.MethodPost, "/receipts/"+url.PathEscape(id)+"/void", nil, nil)
+	return err
+}
+
+// Receipts - v2
+
+// Process a receipt through the v2 routes, returning it as stored with its points and breakdown
+func (c *Client) ProcessReceiptV2(ctx context.Context, r ReceiptV2) (*ReceiptResource, error) {
+	var resp ReceiptResource
+	if _, err := c.doJSON(ctx, http.MethodPost, "/v2/receipts/process", r, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Queue a receipt for processing through the v2 routes, returning it as pending
+func (c *Client) ProcessReceiptV2Async(ctx context.Context, r ReceiptV2) (*ReceiptResource, error) {
+	var resp ReceiptResource
+	if _, err := c.doJSON(ctx, http.MethodPost, "/v2/receipts/process?async=true", r, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Return a stored receipt, with its status, points and breakdown
+func (c *Client) GetReceiptV2(ctx context.Context, id string) (*ReceiptResource, error) {
+	var resp ReceiptResource
+	if _, err := c.doJSON(ctx, http.MethodGet, "/v2/receipts/"+url.PathEscape(id), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Return a receipt's status, points and breakdown, without the receipt itself
+func (c *Client) GetPointsV2(ctx context.Context, id string) (*ReceiptResource, error) {
+	var resp ReceiptResource
+	if _, err := c.doJSON(ctx, http.MethodGet, "/v2/receipts/"+url.PathEscape(id)+"/points", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Merchants
+
+// List the merchant catalog
+func (c *Client) ListMerchants(ctx context.Context) ([]Merchant, error) {
+	var resp struct {
+		Merchants []Merchant `json:"merchants"`
+	}
+	_, err := c.doJSON(ctx, http.MethodGet, "/merchants", nil, &resp)
+	return resp.Merchants, err
+}
+
+// Add a merchant to the catalog
+func (c *Client) CreateMerchant(ctx context.Context, m Merchant) (*Merchant, error) {
+	return c.merchant(ctx, http.MethodPost, "/merchants", m)
+}
+
+// Return a merchant from the catalog
+func (c *Client) GetMerchant(ctx context.Context, id string) (*Merchant, error) {
+	return c.merchant(ctx, http.MethodGet, "/merchants/"+url.PathEscape(id), nil)
+}
+
+// Add an alias to a merchant, returning the updated merchant
+func (c *Client) AddMerchantAlias(ctx context.Context, id string, alias string) (*Merchant, error) {
+	return c.merchant(ctx, http.MethodPost, "/merchants/"+url.PathEscape(id)+"/aliases", map[string]string{"alias": alias})
+}
+
+// Remove an alias from a merchant, returning the updated merchant
+func (c *Client) RemoveMerchantAlias(ctx context.Context, id string, alias string) (*Merchant, error) {
+	return c.merchant(ctx, http.MethodDelete, "/merchants/"+url.PathEscape(id)+"/aliases/"+url.PathEscape(alias), nil)
+}
+
+func (c *Client) merchant(ctx context.Context, method string, path string, body interface{}) (*Merchant, error) {
+	var m Merchant
+	if _, err := c.doJSON(ctx, method, path, body, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Webhooks
+
+// Subscribe a URL to receipt events - all events if none are given. The returned secret is not shown again
+func (c *Client) CreateSubscription(ctx context.Context, callbackURL string, events ...string) (*Subscription, error) {
+	var s Subscription
+	body := map[string]interface{}{"url": callbackURL, "events": events}
+	if _, err := c.doJSON(ctx, http.MethodPost, "/webhooks", body, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// List webhook subscriptions, without their secrets
+func (c *Client) ListSubscriptions(ctx context.Context) ([]Subscription, error) {
+	var resp struct {
+		Subscriptions []Subscription `json:"subscriptions"`
+	}
+	_, err := c.doJSON(ctx, http.MethodGet, "/webhooks", nil, &resp)
+	return resp.Subscriptions, err
+}
+
+// Delete a webhook subscription
+func (c *Client) DeleteSubscription(ctx context.Context, id string) error {
+	_, err := c.doJSON(ctx, http.MethodDelete, "/webhooks/"+url.PathEscape(id), nil, nil)
+	return err
+}
+
+// List the recent delivery attempts for a webhook subscription
+func (c *Client) ListDeliveries(ctx context.Context, id string) ([]DeliveryAttempt, error) {
+	var resp struct {
+		Deliveries []DeliveryAttempt `json:"deliveries"`
+	}
+	_, err := c.doJSON(ctx, http.MethodGet, "/webhooks/"+url.PathEscape(id)+"/deliveries", nil, &resp)
+	return resp.Deliveries, err
+}
+
+// List the events that could not be delivered after every retry
+func (c *Client) ListDeadLetters(ctx context.Context) ([]DeadLetter, error) {
+	var resp struct {
+		DeadLetters []DeadLetter `json:"deadLetters"`
+	}
+	_, err := c.doJSON(ctx, http.MethodGet, "/webhooks/dead-letters", nil, &resp)
+	return resp.DeadLetters, err
+}
+
+// Requests
+
+// A response body returned as is, with its content type
+type rawBody struct {
+	data        []byte
+	contentType string
+}
+
+// Send a request with a JSON body, if any, and decode the JSON response into out
+func (c *Client) doJSON(ctx context.Context, method string, path string, in interface{}, out interface{}) (int, error) {
+	if in == nil {
+		return c.do(ctx, method, path, "", nil, out)
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return 0, err
+	}
+	return c.do(ctx, method, path, "application/json", body, out)
+}
+
+// Send a request, retrying as described in the package doc, and decode the response into out
+func (c *Client) do(ctx context.Context, method string, path string, contentType string, body []byte, out interface{}) (int, error) {
+	idempotent := method == http.MethodGet || method == http.MethodDelete
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(body))
+		if err != nil {
+			return 0, err
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if c.apiKey != "" {
+			req.Header.Set("X-API-Key", c.apiKey)
+		}
+		if c.bearerToken != "" {
+			req.Header.Set("Authorization", "Bearer "+c.bearerToken)
+		}
+		if c.signingKey != "" {
+			c.sign(req, body)
+		}
+		// continue the caller's trace, if ctx carries one
+		propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(req.Header))
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			if ctx.Err() == nil && idempotent && attempt < c.maxRetries && c.wait(ctx, c.backoff<<attempt) == nil {
+				continue
+			}
+			return 0, err
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return resp.StatusCode, err
+		}
+
+		if resp.StatusCode >= 400 {
+			delay, retry := c.retryDelay(resp, idempotent, attempt)
+			if retry && c.wait(ctx, delay) == nil {
+				continue
+			}
+			return resp.StatusCode, decodeError(resp.StatusCode, data)
+		}
+		switch out := out.(type) {
+		case nil:
+		case *rawBody:
+			out.data, out.contentType = data, resp.Header.Get("Content-Type")
+		default:
+			if len(data) > 0 {
+				if err := json.Unmarshal(data, out); err != nil {
+					return resp.StatusCode, err
+				}
+			}
+		}
+		return resp.StatusCode, nil
+	}
+}
+
+// Sign a request - each attempt gets a fresh timestamp and nonce, so retries are not rejected as replays
+func (c *Client) sign(req *http.Request, body []byte) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	b := make([]byte, 16)
+	rand.Read(b)
+	nonce := hex.EncodeToString(b)
+	mac := hmac.New(sha256.New, []byte(c.signingSecret))
+	mac.Write([]byte(req.Method + "\n" + req.URL.RequestURI() + "\n" + timestamp + "\n" + nonce + "\n"))
+	mac.Write(body)
+	req.Header.Set("X-Signature-Key", c.signingKey)
+	req.Header.Set("X-Signature-Timestamp", timestamp)
+	req.Header.Set("X-Signature-Nonce", nonce)
+	req.Header.Set("X-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+}
+
+// Whether a failed response should be retried, and after how long - the Retry-After header wins over the backoff
+func (c *Client) retryDelay(resp *http.Response, idempotent bool, attempt int) (time.Duration, bool) {
+	if attempt >= c.maxRetries {
+		return 0, false
+	}
+	delay := c.backoff << attempt
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	hasRetryAfter := err == nil && seconds >= 0
+	if hasRetryAfter {
+		delay = time.Duration(seconds) * time.Second
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return delay, true
+	case http.StatusServiceUnavailable:
+		// the service refused the request before doing any work, so it is safe to send again
+		return delay, hasRetryAfter || idempotent
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return delay, idempotent
+	}
+	return 0, false
+}
+
+// Wait for d, or until the context is done
+func (c *Client) wait(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,85 @@
+package receiptclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Errors an *Error matches with errors.Is, by status code
+var (
+	ErrInvalid      = errors.New("receiptclient: invalid request")
+	ErrUnauthorized = errors.New("receiptclient: unauthorized")
+	ErrForbidden    = errors.New("receiptclient: forbidden")
+	ErrNotFound     = errors.New("receiptclient: not found")
+	ErrConflict     = errors.New("receiptclient: conflict")
+	ErrRateLimited  = errors.New("receiptclient: rate limited")
+	ErrUnavailable  = errors.New("receiptclient: service unavailable")
+)
+
+// Struct representing an error response from the service
+type Error struct {
+	StatusCode int
+	// stable error code - only set by the v2 routes, like invalid_receipt
+	Code    string
+	Message string
+	// the fields at fault - only set by the v2 routes
+	Details []ValidationError
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("receiptclient: %d %s", e.StatusCode, e.Message)
+	if e.Code != "" {
+		msg += " (" + e.Code + ")"
+	}
+	for _, d := range e.Details {
+		msg += "; " + d.Field + " " + d.Reason
+	}
+	return msg
+}
+
+// Match the sentinel errors by status code
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrInvalid:
+		return e.StatusCode == http.StatusBadRequest || e.StatusCode == http.StatusUnprocessableEntity
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrUnavailable:
+		return e.StatusCode == http.StatusServiceUnavailable
+	}
+	return false
+}
+
+// Decode an error response - v2 routes return {"error": {...}}, the others {"description": ...}
+func decodeError(status int, body []byte) *Error {
+	e := &Error{StatusCode: status}
+	var resp struct {
+		Error *struct {
+			Code    string            `json:"code"`
+			Message string            `json:"message"`
+			Details []ValidationError `json:"details"`
+		} `json:"error"`
+		Description string `json:"description"`
+	}
+	if json.Unmarshal(body, &resp) == nil {
+		if resp.Error != nil {
+			e.Code, e.Message, e.Details = resp.Error.Code, resp.Error.Message, resp.Error.Details
+		} else {
+			e.Message = resp.Description
+		}
+	}
+	if e.Message == "" {
+		e.Message = http.StatusText(status)
+	}
+	return e
+}
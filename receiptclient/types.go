@@ -0,0 +1,186 @@
+package receiptclient
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Receipt statuses
+const (
+	StatusPending   = "pending"
+	StatusProcessed = "processed"
+	StatusRejected  = "rejected"
+	StatusVoided    = "voided"
+)
+
+// Receipt event types, for webhook subscriptions
+const (
+	EventReceiptScored   = "receipt.scored"
+	EventReceiptRejected = "receipt.rejected"
+	EventReceiptVoided   = "receipt.voided"
+)
+
+// Struct representing a receipt in the v1 contract - money as strings, like "9.00"
+type Receipt struct {
+	Retailer      string     `json:"retailer"`
+	PurchaseDate  string     `json:"purchaseDate"`
+	PurchaseTime  string     `json:"purchaseTime"`
+	Items         []Item     `json:"items"`
+	Total         string     `json:"total"`
+	TimeZone      string     `json:"timeZone,omitempty"`
+	Currency      string     `json:"currency,omitempty"`
+	Subtotal      string     `json:"subtotal,omitempty"`
+	Tax           string     `json:"tax,omitempty"`
+	Discounts     []Discount `json:"discounts,omitempty"`
+	PaymentMethod string     `json:"paymentMethod,omitempty"`
+	// set by the service, from its merchant catalog
+	MerchantID string `json:"merchantId,omitempty"`
+}
+
+// Struct representing a single item on a v1 receipt
+type Item struct {
+	ShortDescription string `json:"shortDescription"`
+	Price            string `json:"price"`
+	Quantity         string `json:"quantity,omitempty"`
+	UnitPrice        string `json:"unitPrice,omitempty"`
+	SKU              string `json:"sku,omitempty"`
+	UPC              string `json:"upc,omitempty"`
+}
+
+// Struct representing a discount on a v1 receipt
+type Discount struct {
+	Description string `json:"description"`
+	Amount      string `json:"amount"`
+}
+
+// Struct representing a receipt in the v2 contract - money as JSON numbers, kept as their literals so no precision is lost
+type ReceiptV2 struct {
+	Retailer      string       `json:"retailer"`
+	PurchaseDate  string       `json:"purchaseDate"`
+	PurchaseTime  string       `json:"purchaseTime"`
+	TimeZone      string       `json:"timeZone,omitempty"`
+	Currency      string       `json:"currency,omitempty"`
+	Items         []ItemV2     `json:"items"`
+	Subtotal      json.Number  `json:"subtotal,omitempty"`
+	Tax           json.Number  `json:"tax,omitempty"`
+	Discounts     []DiscountV2 `json:"discounts,omitempty"`
+	Total         json.Number  `json:"total"`
+	PaymentMethod string       `json:"paymentMethod,omitempty"`
+	// set by the service, from its merchant catalog
+	MerchantID string `json:"merchantId,omitempty"`
+}
+
+// Struct representing a single item on a v2 receipt
+type ItemV2 struct {
+	Description string      `json:"description"`
+	Category    string      `json:"category,omitempty"`
+	Quantity    json.Number `json:"quantity,omitempty"`
+	UnitPrice   json.Number `json:"unitPrice,omitempty"`
+	Price       json.Number `json:"price"`
+	SKU         string      `json:"sku,omitempty"`
+	UPC         string      `json:"upc,omitempty"`
+}
+
+// Struct representing a discount on a v2 receipt
+type DiscountV2 struct {
+	Description string      `json:"description"`
+	Amount      json.Number `json:"amount"`
+}
+
+// Struct representing a receipt as stored by the service, as returned by the v2 routes
+type ReceiptResource struct {
+	ID          string           `json:"id"`
+	Status      string           `json:"status"`
+	Points      *int             `json:"points,omitempty"`
+	Breakdown   []RulePoints     `json:"breakdown,omitempty"`
+	PurchasedAt *time.Time       `json:"purchasedAt,omitempty"`
+	ImageID     string           `json:"imageId,omitempty"`
+	Rejection   *ValidationError `json:"rejection,omitempty"`
+	// version of the merchant catalog the receipt was resolved against - set once it leaves the pending status
+	CatalogVersion *int `json:"catalogVersion,omitempty"`
+	// only returned by GetReceiptV2
+	Receipt *ReceiptV2 `json:"receipt,omitempty"`
+}
+
+// Struct representing the points awarded by a single scoring rule
+type RulePoints struct {
+	Rule   string `json:"rule"`
+	Points int    `json:"points"`
+}
+
+// Struct representing why a receipt is invalid - the field, as a JSON path like items[0].price, and the reason
+type ValidationError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// Struct representing the points awarded for a receipt, as returned by GetPoints
+type Points struct {
+	Status string `json:"status"`
+	// only set once the receipt is processed
+	Points int `json:"points"`
+	// why a rejected or voided receipt has no points
+	Description string `json:"description,omitempty"`
+}
+
+// Struct representing a receipt read from text or an image
+type ParsedReceipt struct {
+	ID      string  `json:"id"`
+	ImageID string  `json:"imageId,omitempty"`
+	Status  string  `json:"status,omitempty"`
+	Receipt Receipt `json:"receipt"`
+	// how sure the parser is of each field, from 0 to 1
+	Confidence map[string]float64 `json:"confidence,omitempty"`
+}
+
+// Struct representing a canonical merchant in the service's catalog
+type Merchant struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Aliases  []string `json:"aliases"`
+	TimeZone string   `json:"timeZone,omitempty"`
+}
+
+// Struct representing a webhook subscription
+type Subscription struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// shared secret used to sign payloads - only returned by CreateSubscription
+	Secret string `json:"secret,omitempty"`
+	// event types delivered - all events if empty
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Struct representing a receipt event - the payload posted to webhook subscribers
+type ReceiptEvent struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"createdAt"`
+	Data      struct {
+		ReceiptID string `json:"receiptId"`
+		Status    string `json:"status"`
+		Points    int    `json:"points"`
+	} `json:"data"`
+}
+
+// Struct representing a single webhook delivery attempt
+type DeliveryAttempt struct {
+	SubscriptionID string    `json:"subscriptionId"`
+	EventID        string    `json:"eventId"`
+	EventType      string    `json:"eventType"`
+	Attempt        int       `json:"attempt"`
+	StatusCode     int       `json:"statusCode,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	Success        bool      `json:"success"`
+	At             time.Time `json:"at"`
+}
+
+// Struct representing an event that could not be delivered after every retry
+type DeadLetter struct {
+	SubscriptionID string       `json:"subscriptionId"`
+	Event          ReceiptEvent `json:"event"`
+	Attempts       int          `json:"attempts"`
+	LastError      string       `json:"lastError"`
+	FailedAt       time.Time    `json:"failedAt"`
+}
@@ -0,0 +1,4 @@
+// Package receiptpb holds the protobuf definition of the receipt processor's gRPC API, and the code generated from it
+package receiptpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative receipts.proto